@@ -15,6 +15,9 @@
 package collectors
 
 import (
+	"log_exporter/internal/utils"
+	"math"
+	"reflect"
 	"testing"
 	"time"
 
@@ -316,3 +319,173 @@ func TestCustomHistogram_Describe(t *testing.T) {
 		t.Error("Expected same descriptor")
 	}
 }
+
+func TestCustomHistogram_ObserveNative(t *testing.T) {
+	desc := prometheus.NewDesc("test_native_histogram", "test native histogram", []string{"label1"}, nil)
+	histogram := NewCustomNativeHistogram(desc, 0, 0.001)
+
+	labels := map[string]string{"label1": "value1"}
+	labelKeys := []string{"label1"}
+	histogram.ObserveNative(0, 0, 0, 0, nil, nil, labels, labelKeys, nil)
+	histogram.ObserveNative(6.5, 3, 1, 0, map[int]int64{1: 1, 2: 1}, nil, labels, labelKeys, nil)
+	histogram.ObserveNative(-1, 2, 0, 0, map[int]int64{2: 1}, map[int]int64{0: 1}, labels, labelKeys, nil)
+
+	ch := make(chan prometheus.Metric, 1)
+	histogram.Collect(ch)
+	close(ch)
+
+	metric := <-ch
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		t.Fatalf("Failed to write metric: %v", err)
+	}
+
+	if m.Histogram.GetSampleCount() != 5 || m.Histogram.GetSampleSum() != 5.5 || m.Histogram.GetZeroCount() != 1 {
+		t.Errorf("Expected count 5, sum 5.5 and zero count 1, got: %v, %v and %v", m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum(), m.Histogram.GetZeroCount())
+	}
+	if m.Histogram.GetSchema() != 0 || m.Histogram.GetZeroThreshold() != 0.001 {
+		t.Errorf("Expected schema 0 and zero threshold 0.001, got: %v and %v", m.Histogram.GetSchema(), m.Histogram.GetZeroThreshold())
+	}
+	// positive buckets 1 and 2 with counts 1 and 2 are encoded as one span with deltas
+	if len(m.Histogram.PositiveSpan) != 1 || m.Histogram.PositiveSpan[0].GetOffset() != 1 || m.Histogram.PositiveSpan[0].GetLength() != 2 {
+		t.Errorf("Unexpected positive spans: %+v", m.Histogram.PositiveSpan)
+	}
+	if len(m.Histogram.PositiveDelta) != 2 || m.Histogram.PositiveDelta[0] != 1 || m.Histogram.PositiveDelta[1] != 1 {
+		t.Errorf("Unexpected positive deltas: %+v", m.Histogram.PositiveDelta)
+	}
+	if len(m.Histogram.NegativeDelta) != 1 || m.Histogram.NegativeDelta[0] != 1 {
+		t.Errorf("Unexpected negative deltas: %+v", m.Histogram.NegativeDelta)
+	}
+}
+
+func TestCustomHistogram_ObserveNativeReducesSchema(t *testing.T) {
+	desc := prometheus.NewDesc("test_native_histogram", "test native histogram", []string{"label1"}, nil)
+	histogram := NewCustomNativeHistogram(desc, 2, 0.001)
+
+	labels := map[string]string{"label1": "value1"}
+	labelKeys := []string{"label1"}
+	histogram.ObserveNative(0, 2, 0, 2, map[int]int64{3: 1, 4: 1}, nil, labels, labelKeys, nil)
+	// the observations of the lower schema reduce the schema of the series
+	histogram.ObserveNative(0, 1, 0, 1, map[int]int64{2: 1}, nil, labels, labelKeys, nil)
+	// the buckets over the limit reduce the schema until they fit
+	buckets := make(map[int]int64)
+	for key := 1; key <= 2*NATIVE_HISTOGRAM_MAX_BUCKETS; key++ {
+		buckets[key] = 1
+	}
+	histogram.ObserveNative(0, uint64(len(buckets)), 0, 1, buckets, nil, labels, labelKeys, nil)
+
+	ch := make(chan prometheus.Metric, 1)
+	histogram.Collect(ch)
+	close(ch)
+
+	metric := <-ch
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		t.Fatalf("Failed to write metric: %v", err)
+	}
+	if m.Histogram.GetSchema() != 0 {
+		t.Errorf("Expected schema 0, got: %v", m.Histogram.GetSchema())
+	}
+	var bucketsNumber uint32
+	for _, span := range m.Histogram.PositiveSpan {
+		bucketsNumber += span.GetLength()
+	}
+	if bucketsNumber != NATIVE_HISTOGRAM_MAX_BUCKETS {
+		t.Errorf("Expected %v buckets, got: %v", NATIVE_HISTOGRAM_MAX_BUCKETS, bucketsNumber)
+	}
+}
+
+func TestReduceNativeSchema(t *testing.T) {
+	// the bucket k becomes the bucket ceil(k/2) for every step down
+	result := ReduceNativeSchema(map[int]int64{-3: 1, -2: 1, 0: 1, 1: 1, 2: 1, 3: 1, 5: 1}, 3, 2)
+	expected := map[int]int64{-1: 2, 0: 1, 1: 2, 2: 1, 3: 1}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got: %v", expected, result)
+	}
+	result = ReduceNativeSchema(map[int]int64{5: 1, 8: 1}, 3, 1)
+	expected = map[int]int64{2: 2}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got: %v", expected, result)
+	}
+}
+
+func TestQuantileSketch_AddAndMerge(t *testing.T) {
+	if value := CreateQuantileSketch(0.01).Quantile(0.5); !math.IsNaN(value) {
+		t.Errorf("Expected NaN for the empty sketch, got: %v", value)
+	}
+
+	single := CreateQuantileSketch(0.01)
+	left := CreateQuantileSketch(0.01)
+	right := CreateQuantileSketch(0.01)
+	for i := 1; i <= 1000; i++ {
+		single.Add(float64(i))
+		if i%2 == 0 {
+			left.Add(float64(i))
+		} else {
+			right.Add(float64(i))
+		}
+	}
+	left.Merge(right)
+	if left.Count != single.Count || left.Sum != single.Sum {
+		t.Errorf("Expected merged count %v and sum %v, got: %v %v", single.Count, single.Sum, left.Count, left.Sum)
+	}
+	for q, expected := range map[float64]float64{0.5: 500, 0.9: 900, 0.99: 990} {
+		if value := left.Quantile(q); value != single.Quantile(q) || math.Abs(value-expected) > expected*0.01+1 {
+			t.Errorf("Expected quantile %v to be about %v for the merged and the single sketch, got: %v %v", q, expected, value, single.Quantile(q))
+		}
+	}
+}
+
+func TestCustomSummary_ObserveMaxAge(t *testing.T) {
+	desc := prometheus.NewDesc("test_summary", "test summary", []string{"label1"}, nil)
+	summary := NewCustomSummary(desc, []float64{0.5, 0.99}, 10*time.Minute, 5)
+
+	labels := map[string]string{"label1": "value1"}
+	labelKeys := []string{"label1"}
+	observe := func(timestamp time.Time, values ...float64) map[float64]float64 {
+		sketch := CreateQuantileSketch(0.01)
+		for _, value := range values {
+			sketch.Add(value)
+		}
+		summary.Observe(sketch, labels, labelKeys, &timestamp)
+
+		ch := make(chan prometheus.Metric, 1)
+		summary.Collect(ch)
+		close(ch)
+		var m dto.Metric
+		if err := (<-ch).Write(&m); err != nil {
+			t.Fatalf("Failed to write metric: %v", err)
+		}
+		if m.TimestampMs == nil || *m.TimestampMs != timestamp.UnixMilli() {
+			t.Errorf("Expected timestamp %v, got %v", timestamp.UnixMilli(), m.TimestampMs)
+		}
+		quantiles := make(map[float64]float64)
+		for _, q := range m.Summary.Quantile {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return quantiles
+	}
+
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	observe(start, 100, 100, 100)
+	quantiles := observe(start.Add(5*time.Minute), 1, 1)
+	if quantiles[0.5] < 99 || quantiles[0.5] > 101 {
+		t.Errorf("Expected median about 100 within max age, got %v", quantiles[0.5])
+	}
+
+	// the first observations are older than max-age, sum and count are kept
+	quantiles = observe(start.Add(11*time.Minute), 1)
+	if quantiles[0.5] < 0.99 || quantiles[0.99] > 1.01 {
+		t.Errorf("Expected quantiles about 1 after max age, got %v", quantiles)
+	}
+	state := summary.stateMap[utils.MapToString(labels)]
+	if state.Cnt != 6 || state.Sum != 303 {
+		t.Errorf("Expected count 6 and sum 303, got %v and %v", state.Cnt, state.Sum)
+	}
+
+	// all observations are out of the window
+	quantiles = observe(start.Add(time.Hour))
+	if !math.IsNaN(quantiles[0.5]) {
+		t.Errorf("Expected NaN for the empty window, got %v", quantiles[0.5])
+	}
+}
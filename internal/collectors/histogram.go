@@ -15,6 +15,7 @@
 package collectors
 
 import (
+	"log_exporter/internal/config"
 	"log_exporter/internal/utils"
 	"sync"
 	"time"
@@ -22,17 +23,29 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// NATIVE_HISTOGRAM_MAX_BUCKETS limits the number of the buckets of the native histogram series like NativeHistogramMaxBucketNumber
+// of the Prometheus client: if there are more buckets, the schema is reduced and the adjacent buckets are merged
+const NATIVE_HISTOGRAM_MAX_BUCKETS = 160
+
 type CustomHistogram struct {
 	sync.RWMutex
 	constHistogramMap map[string]*prometheus.Metric
 	stateMap          map[string]*CurrentHistogramState
 	Desc              *prometheus.Desc
+	// Native histogram parameters, they are used only by ObserveNative
+	Schema        int32
+	ZeroThreshold float64
 }
 
 type CurrentHistogramState struct {
-	Sum     float64
-	Cnt     uint64
-	Buckets map[float64]uint64
+	Sum             float64
+	Cnt             uint64
+	Buckets         map[float64]uint64
+	ZeroCount       uint64
+	Schema          int32
+	PositiveBuckets map[int]int64
+	NegativeBuckets map[int]int64
+	Created         time.Time
 }
 
 func NewCustomHistogram(desc *prometheus.Desc) *CustomHistogram {
@@ -43,6 +56,14 @@ func NewCustomHistogram(desc *prometheus.Desc) *CustomHistogram {
 	return &customHistogram
 }
 
+// NewCustomNativeHistogram creates the histogram with the exponential buckets of the schema, it must be updated by ObserveNative
+func NewCustomNativeHistogram(desc *prometheus.Desc, schema int32, zeroThreshold float64) *CustomHistogram {
+	customHistogram := NewCustomHistogram(desc)
+	customHistogram.Schema = schema
+	customHistogram.ZeroThreshold = zeroThreshold
+	return customHistogram
+}
+
 func (h *CustomHistogram) Describe(ch chan<- *prometheus.Desc) {
 	h.RLock()
 	defer h.RUnlock()
@@ -121,3 +142,73 @@ func (h *CustomHistogram) ObserveSingle(val float64, bucketList []float64, label
 	}
 	h.constHistogramMap[histKey] = &constHistogram
 }
+
+// ObserveNative adds the observations of the native histogram, the buckets are identified by the keys of the schema.
+// The observations and the state of the series are brought to the lower of their schemas before they are added
+func (h *CustomHistogram) ObserveNative(sum float64, cnt uint64, zeroCount uint64, schema int32, positiveBuckets map[int]int64, negativeBuckets map[int]int64, labels map[string]string, labelKeys []string, timestamp *time.Time) {
+	h.Lock()
+	defer h.Unlock()
+	labelValues := utils.GetOrderedMapValues(labels, labelKeys)
+	histKey := utils.MapToString(labels)
+	histState := h.stateMap[histKey]
+	if histState == nil {
+		histState = &CurrentHistogramState{
+			Schema:          h.Schema,
+			PositiveBuckets: make(map[int]int64),
+			NegativeBuckets: make(map[int]int64),
+			Created:         time.Now(),
+		}
+		h.stateMap[histKey] = histState
+	}
+	histState.Sum += sum
+	histState.Cnt += cnt
+	histState.ZeroCount += zeroCount
+	if schema < histState.Schema {
+		histState.PositiveBuckets = ReduceNativeSchema(histState.PositiveBuckets, histState.Schema, schema)
+		histState.NegativeBuckets = ReduceNativeSchema(histState.NegativeBuckets, histState.Schema, schema)
+		histState.Schema = schema
+	}
+	for key, value := range ReduceNativeSchema(positiveBuckets, schema, histState.Schema) {
+		histState.PositiveBuckets[key] += value
+	}
+	for key, value := range ReduceNativeSchema(negativeBuckets, schema, histState.Schema) {
+		histState.NegativeBuckets[key] += value
+	}
+	histState.Schema, histState.PositiveBuckets, histState.NegativeBuckets = LimitNativeBuckets(histState.Schema, histState.PositiveBuckets, histState.NegativeBuckets)
+	constHistogram := prometheus.MustNewConstNativeHistogram(h.Desc, histState.Cnt, histState.Sum, histState.PositiveBuckets, histState.NegativeBuckets,
+		histState.ZeroCount, histState.Schema, h.ZeroThreshold, histState.Created, labelValues...)
+	if timestamp != nil {
+		constHistogram = prometheus.NewMetricWithTimestamp(*timestamp, constHistogram)
+	}
+	h.constHistogramMap[histKey] = &constHistogram
+}
+
+// ReduceNativeSchema converts the buckets of the schema to the buckets of the lower newSchema: every step down
+// merges the buckets 2j-1 and 2j into the bucket j, so the bucket k becomes the bucket ceil(k/2)
+func ReduceNativeSchema(buckets map[int]int64, schema int32, newSchema int32) map[int]int64 {
+	if schema <= newSchema {
+		return buckets
+	}
+	result := make(map[int]int64, len(buckets))
+	for key, value := range buckets {
+		for s := schema; s > newSchema; s-- {
+			if key > 0 {
+				key++
+			}
+			key /= 2
+		}
+		result[key] += value
+	}
+	return result
+}
+
+// LimitNativeBuckets reduces the schema while there are more than NATIVE_HISTOGRAM_MAX_BUCKETS buckets,
+// the schema is not reduced below the minimal one
+func LimitNativeBuckets(schema int32, positiveBuckets map[int]int64, negativeBuckets map[int]int64) (int32, map[int]int64, map[int]int64) {
+	for len(positiveBuckets)+len(negativeBuckets) > NATIVE_HISTOGRAM_MAX_BUCKETS && schema > config.NATIVE_HISTOGRAM_SCHEMA_MIN {
+		positiveBuckets = ReduceNativeSchema(positiveBuckets, schema, schema-1)
+		negativeBuckets = ReduceNativeSchema(negativeBuckets, schema, schema-1)
+		schema--
+	}
+	return schema, positiveBuckets, negativeBuckets
+}
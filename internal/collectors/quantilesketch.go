@@ -0,0 +1,174 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// QUANTILE_SKETCH_MAX_BINS is the maximum number of bins for the positive and for the negative values.
+	// With the relative accuracy 0.01 it covers the values from 1 to about 10^17 without collapsing
+	QUANTILE_SKETCH_MAX_BINS = 2048
+	// QUANTILE_SKETCH_MIN_VALUE is the smallest absolute value kept in the bins, smaller values are counted as zeros
+	QUANTILE_SKETCH_MIN_VALUE = 1e-9
+	// QUANTILE_SKETCH_RELATIVE_ACCURACY_DEFAULT is used if the relative accuracy is not in the range (0 ; 1)
+	QUANTILE_SKETCH_RELATIVE_ACCURACY_DEFAULT = 0.01
+)
+
+// QuantileSketch is the DDSketch: the values are counted in the bins with logarithmically growing bounds, so any quantile
+// is estimated with the relative error not greater than the relative accuracy. The sketches with the same relative accuracy
+// can be merged, the result is the same as if all values were added to one sketch.
+// When there are more than QUANTILE_SKETCH_MAX_BINS bins, the lowest bins are collapsed, so the accuracy of the smallest values is lost
+type QuantileSketch struct {
+	RelativeAccuracy float64
+	Count            uint64
+	Sum              float64
+	Min              float64
+	Max              float64
+	zeroCount        uint64
+	positive         map[int]uint64
+	negative         map[int]uint64
+	gamma            float64
+	logGamma         float64
+}
+
+func CreateQuantileSketch(relativeAccuracy float64) *QuantileSketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = QUANTILE_SKETCH_RELATIVE_ACCURACY_DEFAULT
+	}
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &QuantileSketch{
+		RelativeAccuracy: relativeAccuracy,
+		Min:              math.Inf(1),
+		Max:              math.Inf(-1),
+		positive:         make(map[int]uint64),
+		negative:         make(map[int]uint64),
+		gamma:            gamma,
+		logGamma:         math.Log(gamma),
+	}
+}
+
+func (s *QuantileSketch) Add(value float64) {
+	switch {
+	case value > QUANTILE_SKETCH_MIN_VALUE:
+		s.positive[s.index(value)]++
+		collapseLowestBins(s.positive)
+	case value < -QUANTILE_SKETCH_MIN_VALUE:
+		s.negative[s.index(-value)]++
+		collapseLowestBins(s.negative)
+	default:
+		s.zeroCount++
+	}
+	s.Count++
+	s.Sum += value
+	s.Min = math.Min(s.Min, value)
+	s.Max = math.Max(s.Max, value)
+}
+
+// Merge adds the values of the other sketch with the same relative accuracy
+func (s *QuantileSketch) Merge(other *QuantileSketch) {
+	for index, count := range other.positive {
+		s.positive[index] += count
+	}
+	for index, count := range other.negative {
+		s.negative[index] += count
+	}
+	collapseLowestBins(s.positive)
+	collapseLowestBins(s.negative)
+	s.zeroCount += other.zeroCount
+	s.Count += other.Count
+	s.Sum += other.Sum
+	s.Min = math.Min(s.Min, other.Min)
+	s.Max = math.Max(s.Max, other.Max)
+}
+
+// Quantile returns the estimated value of the quantile q (from 0 to 1), NaN is returned for the empty sketch
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	if s.Count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	if q == 0 {
+		return s.Min
+	}
+	if q == 1 {
+		return s.Max
+	}
+	rank := uint64(q * float64(s.Count-1))
+	var result float64
+	var seen uint64
+	found := false
+	// the negative values are counted from the largest absolute value
+	for _, index := range sortedIndexes(s.negative, true) {
+		seen += s.negative[index]
+		if seen > rank {
+			result, found = -s.value(index), true
+			break
+		}
+	}
+	if !found {
+		seen += s.zeroCount
+		if seen > rank {
+			result, found = 0, true
+		}
+	}
+	if !found {
+		result = s.Max
+		for _, index := range sortedIndexes(s.positive, false) {
+			seen += s.positive[index]
+			if seen > rank {
+				result = s.value(index)
+				break
+			}
+		}
+	}
+	return math.Max(s.Min, math.Min(s.Max, result))
+}
+
+// index returns the bin of the positive value, the bin i contains the values from gamma^(i-1) to gamma^i
+func (s *QuantileSketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / s.logGamma))
+}
+
+// value returns the value of the bin with the relative error not greater than the relative accuracy for all values of the bin
+func (s *QuantileSketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+func collapseLowestBins(bins map[int]uint64) {
+	if len(bins) <= QUANTILE_SKETCH_MAX_BINS {
+		return
+	}
+	indexes := sortedIndexes(bins, false)
+	target := indexes[len(indexes)-QUANTILE_SKETCH_MAX_BINS]
+	for _, index := range indexes[:len(indexes)-QUANTILE_SKETCH_MAX_BINS] {
+		bins[target] += bins[index]
+		delete(bins, index)
+	}
+}
+
+func sortedIndexes(bins map[int]uint64, descending bool) []int {
+	result := make([]int, 0, len(bins))
+	for index := range bins {
+		result = append(result, index)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.IntSlice(result)))
+	} else {
+		sort.Ints(result)
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"log_exporter/internal/utils"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CustomSummary exposes the summaries, which sum and count are accumulated for the whole time and the quantiles (objectives)
+// are evaluated for the observations of the last max-age. The observations are kept in age-buckets sketches, every sketch
+// covers max-age / age-buckets of time, the oldest sketch is replaced by the empty one when its time is over
+type CustomSummary struct {
+	sync.RWMutex
+	constSummaryMap map[string]*prometheus.Metric
+	stateMap        map[string]*CurrentSummaryState
+	Desc            *prometheus.Desc
+	Objectives      []float64
+	MaxAge          time.Duration
+	AgeBuckets      int
+}
+
+type CurrentSummaryState struct {
+	Sum         float64
+	Cnt         uint64
+	ageBuckets  []*QuantileSketch
+	head        int
+	headStarted time.Time
+}
+
+func NewCustomSummary(desc *prometheus.Desc, objectives []float64, maxAge time.Duration, ageBuckets int) *CustomSummary {
+	customSummary := CustomSummary{}
+	customSummary.Desc = desc
+	customSummary.Objectives = objectives
+	customSummary.MaxAge = maxAge
+	customSummary.AgeBuckets = max(ageBuckets, 1)
+	customSummary.stateMap = make(map[string]*CurrentSummaryState)
+	customSummary.constSummaryMap = make(map[string]*prometheus.Metric)
+	return &customSummary
+}
+
+func (s *CustomSummary) Describe(ch chan<- *prometheus.Desc) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, constSummary := range s.constSummaryMap {
+		ch <- (*constSummary).Desc()
+	}
+}
+
+func (s *CustomSummary) Collect(ch chan<- prometheus.Metric) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, constSummary := range s.constSummaryMap {
+		ch <- *constSummary
+	}
+}
+
+func (s *CustomSummary) CollectWithTimestamp(ch chan<- prometheus.Metric, timestamp time.Time) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, constSummary := range s.constSummaryMap {
+		ch <- prometheus.NewMetricWithTimestamp(timestamp, *constSummary)
+	}
+}
+
+// Observe adds the observations of the sketch to the summary. The age buckets are rotated by the timestamp,
+// if it is nil, by the current time
+func (s *CustomSummary) Observe(sketch *QuantileSketch, labels map[string]string, labelKeys []string, timestamp *time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	labelValues := utils.GetOrderedMapValues(labels, labelKeys)
+	summaryKey := utils.MapToString(labels)
+	now := time.Now()
+	if timestamp != nil {
+		now = *timestamp
+	}
+	state := s.stateMap[summaryKey]
+	if state == nil {
+		state = &CurrentSummaryState{
+			ageBuckets:  make([]*QuantileSketch, s.AgeBuckets),
+			headStarted: now,
+		}
+		for i := range state.ageBuckets {
+			state.ageBuckets[i] = CreateQuantileSketch(sketch.RelativeAccuracy)
+		}
+		s.stateMap[summaryKey] = state
+	}
+	s.rotate(state, now)
+	state.ageBuckets[state.head].Merge(sketch)
+	state.Sum += sketch.Sum
+	state.Cnt += sketch.Count
+
+	window := CreateQuantileSketch(sketch.RelativeAccuracy)
+	for _, ageBucket := range state.ageBuckets {
+		window.Merge(ageBucket)
+	}
+	quantiles := make(map[float64]float64, len(s.Objectives))
+	for _, objective := range s.Objectives {
+		quantiles[objective] = window.Quantile(objective)
+	}
+	constSummary := prometheus.MustNewConstSummary(s.Desc, state.Cnt, state.Sum, quantiles, labelValues...)
+	if timestamp != nil {
+		constSummary = prometheus.NewMetricWithTimestamp(*timestamp, constSummary)
+	}
+	s.constSummaryMap[summaryKey] = &constSummary
+}
+
+// rotate replaces the age buckets which time is over with the empty ones
+func (s *CustomSummary) rotate(state *CurrentSummaryState, now time.Time) {
+	bucketDuration := s.MaxAge / time.Duration(s.AgeBuckets)
+	if bucketDuration <= 0 {
+		return
+	}
+	for i := 0; i < s.AgeBuckets && now.Sub(state.headStarted) >= bucketDuration; i++ {
+		state.head = (state.head + 1) % s.AgeBuckets
+		state.ageBuckets[state.head] = CreateQuantileSketch(state.ageBuckets[state.head].RelativeAccuracy)
+		state.headStarted = state.headStarted.Add(bucketDuration)
+	}
+	if now.Sub(state.headStarted) >= bucketDuration {
+		state.headStarted = now
+	}
+}
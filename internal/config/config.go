@@ -28,6 +28,7 @@ import (
 	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
 	"math"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -48,7 +49,6 @@ type Config struct {
 	General         *GeneralConfig
 	Flags           map[string]string      `yaml:"flags,omitempty"`
 	GraylogEmulator *GraylogEmulatorConfig `yaml:"graylog-emulator,omitempty"`
-	DsName          string                 `yaml:"-"`
 }
 
 type TLSHostConfig struct {
@@ -62,12 +62,58 @@ type TLSHostConfig struct {
 	TLSKeyFile            *string       `yaml:"tls-key-file,omitempty"`             // no default
 	TLSCACertFile         *string       `yaml:"tls-ca-cert-file,omitempty"`         // no default
 	TlsConfig             *tls.Config   `yaml:"-"`
+	MaxIdleConns          int           `yaml:"max-idle-conns,omitempty"`          // 100
+	MaxIdleConnsPerHost   int           `yaml:"max-idle-conns-per-host,omitempty"` // 10
+	MaxConnsPerHost       int           `yaml:"max-conns-per-host,omitempty"`      // 0 (no limit)
+	IdleConnTimeout       time.Duration `yaml:"idle-conn-timeout,omitempty"`       // 90s
+	DisableHTTP2          bool          `yaml:"disable-http2,omitempty"`           // false
+	Auth                  *AuthConfig   `yaml:",omitempty"`
+}
+
+// AuthConfig describes the authorization added to every request to the host in addition to (or instead of) the Basic auth
+type AuthConfig struct {
+	BearerToken     string            `yaml:"bearer-token,omitempty"`
+	BearerTokenFile string            `yaml:"bearer-token-file,omitempty"`
+	Headers         map[string]string `yaml:",omitempty"`
+	OAuth2          *OAuth2Config     `yaml:"oauth2,omitempty"`
+}
+
+type OAuth2Config struct {
+	TokenUrl         string            `yaml:"token-url"`
+	ClientId         string            `yaml:"client-id"`
+	ClientSecret     string            `yaml:"client-secret,omitempty"`
+	ClientSecretFile string            `yaml:"client-secret-file,omitempty"`
+	Scopes           []string          `yaml:",flow,omitempty"`
+	EndpointParams   map[string]string `yaml:"endpoint-params,omitempty"`
 }
 
 type DatasourceConfig struct {
-	TLSHostConfig `yaml:",inline"`
-	Labels        map[string]string `yaml:",omitempty"`
-	Type          string
+	TLSHostConfig         `yaml:",inline"`
+	Labels                map[string]string `yaml:",omitempty"`
+	Type                  string
+	Index                 string        `yaml:",omitempty"`
+	TimestampField        string        `yaml:"timestamp-field,omitempty"`
+	PageSize              int           `yaml:"page-size,omitempty"`
+	MaxRecords            int           `yaml:"max-records,omitempty"`
+	MaxResponseSize       int64         `yaml:"max-response-size,omitempty"`
+	Mode                  string        `yaml:",omitempty"`
+	Api                   string        `yaml:",omitempty"`
+	Path                  string        `yaml:",omitempty"`
+	Format                string        `yaml:",omitempty"`
+	OffsetsFile           string        `yaml:"offsets-file,omitempty"`
+	SyslogUdpAddress      string        `yaml:"syslog-udp-address,omitempty"`
+	SyslogTcpAddress      string        `yaml:"syslog-tcp-address,omitempty"`
+	HttpAddress           string        `yaml:"http-address,omitempty"`
+	MaxConcurrentRequests int           `yaml:"max-concurrent-requests,omitempty"`
+	RequestsPerSecond     float64       `yaml:"requests-per-second,omitempty"`
+	Record                *RecordConfig `yaml:",omitempty"`
+}
+
+// RecordConfig describes the archive the query results of the datasource are written to
+type RecordConfig struct {
+	Path     string
+	MaxFiles int           `yaml:"max-files,omitempty"` // 1440 per query
+	MaxAge   time.Duration `yaml:"max-age,omitempty"`   // no limit
 }
 
 type ExportConfig struct {
@@ -99,6 +145,14 @@ type MetricsConfig struct {
 	IdFieldStrategy            string                         `yaml:"id-field-strategy,omitempty"`
 	IdFieldTTL                 int                            `yaml:"id-field-ttl,omitempty"`
 	Buckets                    []float64                      `yaml:",flow,omitempty"`
+	NativeHistogram            *NativeHistogramConfig         `yaml:"native-histogram,omitempty"`
+	Quantiles                  []float64                      `yaml:",flow,omitempty"`
+	Objectives                 []float64                      `yaml:",flow,omitempty"`
+	MaxAge                     time.Duration                  `yaml:"max-age,omitempty"`     // 10m
+	AgeBuckets                 int                            `yaml:"age-buckets,omitempty"` // 5
+	QuantileRelativeAccuracy   float64                        `yaml:"-"`
+	DistinctPrecision          int                            `yaml:"-"`
+	DistinctWindowIntervals    int                            `yaml:"-"`
 	Parameters                 map[string]string              `yaml:",omitempty"`
 	ChildMetrics               []string                       `yaml:"child-metrics,flow,omitempty"`
 	HasDurationNoResponseChild bool                           `yaml:"-"`
@@ -107,6 +161,24 @@ type MetricsConfig struct {
 	Cond                       []map[string]map[string]string `yaml:"conditions,omitempty"` //slice -> condition type -> parameter name -> parameter value
 }
 
+// NativeHistogramConfig makes the histogram metric native: the buckets are exponential and defined by the schema
+// (the bucket boundaries grow by the factor 2^(2^-schema)), the observations close to zero are counted in the zero bucket
+type NativeHistogramConfig struct {
+	Schema              string  `yaml:",omitempty"`               // 3
+	ZeroThreshold       string  `yaml:"zero-threshold,omitempty"` // 2^-128
+	SchemaParsed        int32   `yaml:"-"`
+	ZeroThresholdParsed float64 `yaml:"-"`
+}
+
+const DATASOURCE_TYPE_DEFAULT = "graylog"
+
+const (
+	NATIVE_HISTOGRAM_SCHEMA_MIN  = -4
+	NATIVE_HISTOGRAM_SCHEMA_MAX  = 8
+	COUNT_DISTINCT_PRECISION_MIN = 4
+	COUNT_DISTINCT_PRECISION_MAX = 18
+)
+
 type MultiValueFieldConfig struct {
 	FieldName string `yaml:"field-name,omitempty"`
 	LabelName string `yaml:"label-name,omitempty"`
@@ -114,15 +186,17 @@ type MultiValueFieldConfig struct {
 }
 
 type QueryConfig struct {
+	Datasource               string   `yaml:",omitempty"`
 	Metrics                  []string `yaml:",flow"`
 	Streams                  []string
 	StreamsJson              string `yaml:"-"`
 	QueryString              string `yaml:"query_string"`
 	QueryStringJson          string `yaml:"-"`
 	Timerange                string
-	TimerangeDuration        time.Duration `yaml:"-"`
-	FieldsInOrder            []string      `yaml:"fields_in_order"`
-	FieldsInOrderJson        string        `yaml:"-"`
+	TimerangeDuration        time.Duration                  `yaml:"-"`
+	FieldsInOrder            []string                       `yaml:"fields_in_order"`
+	FieldsInOrderJson        string                         `yaml:"-"`
+	Cond                     []map[string]map[string]string `yaml:"conditions,omitempty"` //slice -> condition type -> parameter name -> parameter value
 	Croniter                 string
 	Interval                 string                  `yaml:",omitempty"`
 	IntervalDuration         time.Duration           `yaml:"-"`
@@ -142,6 +216,9 @@ type QueryConfig struct {
 	MaxHistoryLookupDuration time.Duration           `yaml:"-"`
 	LastTimestampEndpoint    string                  `yaml:"last-timestamp-endpoint,omitempty"`
 	LastTimestampJsonPath    string                  `yaml:"last-timestamp-json-path,omitempty"`
+	SplitMinTimerange        string                  `yaml:"split-min-timerange,omitempty"`
+	SplitMinTimerangeParsed  time.Duration           `yaml:"-"`
+	SplitMaxRecords          int                     `yaml:"split-max-records,omitempty"`
 }
 
 type CacheConfig struct {
@@ -168,23 +245,38 @@ type URIProcessingConfig struct {
 }
 
 type GeneralConfig struct {
-	GMQueueSelfMonSize          string            `yaml:"gm-queue-self-mon-size,omitempty"`
-	GMQueueSelfMonSizeParsed    int               `yaml:"-"`
-	DisablePushCloudLabels      bool              `yaml:"disable-push-cloud-labels,omitempty"`
-	PushCloudLabels             map[string]string `yaml:"push-cloud-labels,omitempty"`
-	NamespaceName               string            `yaml:"-"`
-	PodName                     string            `yaml:"-"`
-	ContainerName               string            `yaml:"-"`
-	LTSRetryCount               string            `yaml:"last-timestamp-retry-count,omitempty"`
-	LTSRetryPeriod              string            `yaml:"last-timestamp-retry-period,omitempty"`
-	LTSRetryCountParsed         int               `yaml:"-"`
-	LTSRetryPeriodParsed        time.Duration     `yaml:"-"`
-	DatasourceRetry             *bool             `yaml:"datasource-retry,omitempty"`
-	DatasourceRetryPeriod       string            `yaml:"datasource-retry-period,omitempty"`
-	DatasourceRetryPeriodParsed time.Duration     `yaml:"-"`
-	PushRetry                   *bool             `yaml:"push-retry,omitempty"`
-	PushRetryPeriod             string            `yaml:"push-retry-period,omitempty"`
-	PushRetryPeriodParsed       time.Duration     `yaml:"-"`
+	GMQueueSelfMonSize                  string            `yaml:"gm-queue-self-mon-size,omitempty"`
+	GMQueueSelfMonSizeParsed            int               `yaml:"-"`
+	DisablePushCloudLabels              bool              `yaml:"disable-push-cloud-labels,omitempty"`
+	PushCloudLabels                     map[string]string `yaml:"push-cloud-labels,omitempty"`
+	NamespaceName                       string            `yaml:"-"`
+	PodName                             string            `yaml:"-"`
+	ContainerName                       string            `yaml:"-"`
+	LTSRetryCount                       string            `yaml:"last-timestamp-retry-count,omitempty"`
+	LTSRetryPeriod                      string            `yaml:"last-timestamp-retry-period,omitempty"`
+	LTSRetryCountParsed                 int               `yaml:"-"`
+	LTSRetryPeriodParsed                time.Duration     `yaml:"-"`
+	DatasourceRetry                     *bool             `yaml:"datasource-retry,omitempty"`
+	DatasourceRetryPeriod               string            `yaml:"datasource-retry-period,omitempty"`
+	DatasourceRetryPeriodParsed         time.Duration     `yaml:"-"`
+	DatasourceRetryMaxPeriod            string            `yaml:"datasource-retry-max-period,omitempty"`
+	DatasourceRetryMaxPeriodParsed      time.Duration     `yaml:"-"`
+	DatasourceRetryMultiplier           string            `yaml:"datasource-retry-multiplier,omitempty"`
+	DatasourceRetryMultiplierParsed     float64           `yaml:"-"`
+	DatasourceRetryJitter               string            `yaml:"datasource-retry-jitter,omitempty"`
+	DatasourceRetryJitterParsed         float64           `yaml:"-"`
+	DatasourceRetryMaxAttempts          string            `yaml:"datasource-retry-max-attempts,omitempty"`
+	DatasourceRetryMaxAttemptsParsed    int               `yaml:"-"`
+	DatasourceRetryMaxElapsedTime       string            `yaml:"datasource-retry-max-elapsed-time,omitempty"`
+	DatasourceRetryMaxElapsedTimeParsed time.Duration     `yaml:"-"`
+	DatasourceBreakerThreshold          string            `yaml:"datasource-circuit-breaker-threshold,omitempty"`
+	DatasourceBreakerThresholdParsed    int               `yaml:"-"`
+	DatasourceBreakerOpenTimeout        string            `yaml:"datasource-circuit-breaker-open-timeout,omitempty"`
+	DatasourceBreakerOpenTimeoutParsed  time.Duration     `yaml:"-"`
+	PushRetry                           *bool             `yaml:"push-retry,omitempty"`
+	PushRetryPeriod                     string            `yaml:"push-retry-period,omitempty"`
+	PushRetryPeriodParsed               time.Duration     `yaml:"-"`
+	QueryTemplateEnv                    []string          `yaml:"query-template-env,omitempty"`
 }
 
 type GraylogEmulatorConfig struct {
@@ -248,7 +340,7 @@ func Read(path string) (*Config, error) {
 		return nil, fmt.Errorf("error unmarshaling config file %v : %+v", path, err)
 	}
 
-	initDSName(&config)
+	initQueriesDatasource(&config)
 	enrichFromEnvironmentVariables(&config)
 	err = ValidateConfig(&config)
 	if err != nil {
@@ -307,48 +399,96 @@ func enrichFromEnvironmentVariables(config *Config) {
 		log.Infof("Values for push cloud labels were set up : %v, %v, %v", config.General.NamespaceName, config.General.PodName, config.General.ContainerName)
 	}
 
-	for _, datasource := range config.Datasources {
+	for dsName, datasource := range config.Datasources {
+		if datasource == nil {
+			continue
+		}
+		getenv := func(name string) string {
+			return getDatasourceEnv(name, dsName, len(config.Datasources) == 1)
+		}
 		if (datasource.Type == "" || strings.ToUpper(datasource.Type) == "GRAYLOG") && datasource.User == "" {
-			datasource.User = os.Getenv("GRAYLOG_USER")
+			datasource.User = getenv("GRAYLOG_USER")
 			if datasource.User == "" {
-				log.WithField(ec.FIELD, ec.LME_8103).Error("GRAYLOG_USER is not defined neither in config, nor in the environment variable")
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v GRAYLOG_USER is not defined neither in config, nor in the environment variable", dsName)
 			} else {
 				log.Debug("GRAYLOG_USER is extracted from environment variable")
 			}
-			datasource.Password = os.Getenv("GRAYLOG_PASSWORD")
+			datasource.Password = getenv("GRAYLOG_PASSWORD")
 			if datasource.Password == "" {
-				log.WithField(ec.FIELD, ec.LME_8103).Error("GRAYLOG_PASSWORD is not defined in the environment variable")
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v GRAYLOG_PASSWORD is not defined in the environment variable", dsName)
 			} else {
 				log.Debug("GRAYLOG_PASSWORD is extracted from environment variable")
 			}
 		} else if strings.ToUpper(datasource.Type) == "NEWRELIC" && datasource.User == "" {
-			datasource.User = os.Getenv("NEWRELIC_ACCOUNT_ID")
+			datasource.User = getenv("NEWRELIC_ACCOUNT_ID")
 			if datasource.User == "" {
-				log.WithField(ec.FIELD, ec.LME_8103).Error("NEWRELIC_ACCOUNT_ID is not defined neither in config, nor in the environment variable")
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v NEWRELIC_ACCOUNT_ID is not defined neither in config, nor in the environment variable", dsName)
 			} else {
 				log.Debug("NEWRELIC_ACCOUNT_ID is extracted from environment variable")
 			}
-			datasource.Password = os.Getenv("NEWRELIC_X_QUERY_KEY")
+			keyEnvName := "NEWRELIC_X_QUERY_KEY"
+			if strings.ToLower(datasource.Api) == "nerdgraph" {
+				keyEnvName = "NEWRELIC_API_KEY"
+			}
+			datasource.Password = getenv(keyEnvName)
 			if datasource.Password == "" {
-				log.WithField(ec.FIELD, ec.LME_8103).Error("NEWRELIC_X_QUERY_KEY is not defined in the environment variable")
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v %v is not defined in the environment variable", dsName, keyEnvName)
 			} else {
-				log.Debug("NEWRELIC_X_QUERY_KEY is extracted from environment variable")
+				log.Debugf("%v is extracted from environment variable", keyEnvName)
 			}
 		} else if strings.ToUpper(datasource.Type) == "LOKI" && datasource.User == "" {
-			datasource.User = os.Getenv("LOKI_USER")
+			datasource.User = getenv("LOKI_USER")
 			if datasource.User == "" {
-				log.WithField(ec.FIELD, ec.LME_8103).Error("LOKI_USER is not defined neither in config, nor in the environment variable")
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v LOKI_USER is not defined neither in config, nor in the environment variable", dsName)
 			} else {
 				log.Debug("LOKI_USER is extracted from environment variable")
 			}
-			datasource.Password = os.Getenv("LOKI_PASSWORD")
+			datasource.Password = getenv("LOKI_PASSWORD")
 			if datasource.Password == "" {
-				log.WithField(ec.FIELD, ec.LME_8103).Error("LOKI_PASSWORD is not defined in the environment variable")
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v LOKI_PASSWORD is not defined in the environment variable", dsName)
 			} else {
 				log.Debug("LOKI_PASSWORD is extracted from environment variable")
 			}
+		} else if (strings.ToUpper(datasource.Type) == "OPENSEARCH" || strings.ToUpper(datasource.Type) == "ELASTICSEARCH") && datasource.User == "" {
+			datasource.User = getenv("OPENSEARCH_USER")
+			if datasource.User == "" {
+				log.Infof("For datasource %v OPENSEARCH_USER is not defined neither in config, nor in the environment variable, requests will be sent without authorization", dsName)
+			} else {
+				log.Debug("OPENSEARCH_USER is extracted from environment variable")
+			}
+			datasource.Password = getenv("OPENSEARCH_PASSWORD")
+			if datasource.User != "" && datasource.Password == "" {
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v OPENSEARCH_PASSWORD is not defined in the environment variable", dsName)
+			} else if datasource.Password != "" {
+				log.Debug("OPENSEARCH_PASSWORD is extracted from environment variable")
+			}
+		} else if strings.ToUpper(datasource.Type) == "VICTORIALOGS" && datasource.User == "" {
+			datasource.User = getenv("VICTORIALOGS_USER")
+			if datasource.User == "" {
+				log.Infof("For datasource %v VICTORIALOGS_USER is not defined neither in config, nor in the environment variable, requests will be sent without authorization", dsName)
+			} else {
+				log.Debug("VICTORIALOGS_USER is extracted from environment variable")
+			}
+			datasource.Password = getenv("VICTORIALOGS_PASSWORD")
+			if datasource.User != "" && datasource.Password == "" {
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v VICTORIALOGS_PASSWORD is not defined in the environment variable", dsName)
+			} else if datasource.Password != "" {
+				log.Debug("VICTORIALOGS_PASSWORD is extracted from environment variable")
+			}
+		} else if strings.ToUpper(datasource.Type) == "CLICKHOUSE" && datasource.User == "" {
+			datasource.User = getenv("CLICKHOUSE_USER")
+			if datasource.User == "" {
+				log.Infof("For datasource %v CLICKHOUSE_USER is not defined neither in config, nor in the environment variable, requests will be sent without authorization", dsName)
+			} else {
+				log.Debug("CLICKHOUSE_USER is extracted from environment variable")
+			}
+			datasource.Password = getenv("CLICKHOUSE_PASSWORD")
+			if datasource.User != "" && datasource.Password == "" {
+				log.WithField(ec.FIELD, ec.LME_8103).Errorf("For datasource %v CLICKHOUSE_PASSWORD is not defined in the environment variable", dsName)
+			} else if datasource.Password != "" {
+				log.Debug("CLICKHOUSE_PASSWORD is extracted from environment variable")
+			}
 		}
-		break
 	}
 
 	for _, exportConfig := range config.Exports {
@@ -419,7 +559,21 @@ func processHiddenFields(config *Config) {
 	LAST_TIMESTAMP_RETRY_COUNT_DEFAULT := 5
 	LAST_TIMESTAMP_RETRY_PERIOD_DEFAULT := time.Second * 10
 	DATASOURCE_RETRY_PERIOD_DEFAULT := time.Second * 5
+	DATASOURCE_RETRY_MAX_PERIOD_DEFAULT := time.Minute * 5
+	DATASOURCE_RETRY_MULTIPLIER_DEFAULT := 1.0
+	DATASOURCE_RETRY_JITTER_DEFAULT := 0.0
+	DATASOURCE_BREAKER_THRESHOLD_DEFAULT := 0
+	DATASOURCE_BREAKER_OPEN_TIMEOUT_DEFAULT := time.Second * 30
 	PUSH_RETRY_PERIOD_DEFAULT := time.Second * 5
+	QUANTILES_DEFAULT := []float64{0.5, 0.95, 0.99}
+	QUANTILE_RELATIVE_ACCURACY_DEFAULT := 0.01
+	SUMMARY_OBJECTIVES_DEFAULT := []float64{0.5, 0.9, 0.99}
+	SUMMARY_MAX_AGE_DEFAULT := time.Minute * 10
+	SUMMARY_AGE_BUCKETS_DEFAULT := 5
+	NATIVE_HISTOGRAM_SCHEMA_DEFAULT := 3.0
+	NATIVE_HISTOGRAM_ZERO_THRESHOLD_DEFAULT := math.Ldexp(1, -128)
+	COUNT_DISTINCT_PRECISION_DEFAULT := 14
+	COUNT_DISTINCT_WINDOW_INTERVALS_DEFAULT := 1
 
 	if config.General.GMQueueSelfMonSize == "" {
 		config.General.GMQueueSelfMonSizeParsed = GM_QUEUE_SELF_MON_SIZE_DEFAULT
@@ -493,6 +647,18 @@ func processHiddenFields(config *Config) {
 		}
 	}
 
+	config.General.DatasourceRetryMaxPeriodParsed = parseDurationOrDefault("datasource-retry-max-period", config.General.DatasourceRetryMaxPeriod, DATASOURCE_RETRY_MAX_PERIOD_DEFAULT)
+	if config.General.DatasourceRetryMaxPeriodParsed < config.General.DatasourceRetryPeriodParsed {
+		log.WithField(ec.FIELD, ec.LME_8104).Errorf("datasource-retry-max-period %v is less than datasource-retry-period %v, datasource-retry-period will be used as the maximum", config.General.DatasourceRetryMaxPeriodParsed, config.General.DatasourceRetryPeriodParsed)
+		config.General.DatasourceRetryMaxPeriodParsed = config.General.DatasourceRetryPeriodParsed
+	}
+	config.General.DatasourceRetryMultiplierParsed = parseFloatOrDefault("datasource-retry-multiplier", config.General.DatasourceRetryMultiplier, DATASOURCE_RETRY_MULTIPLIER_DEFAULT, 1, math.MaxFloat64)
+	config.General.DatasourceRetryJitterParsed = parseFloatOrDefault("datasource-retry-jitter", config.General.DatasourceRetryJitter, DATASOURCE_RETRY_JITTER_DEFAULT, 0, 1)
+	config.General.DatasourceRetryMaxAttemptsParsed = parseIntOrDefault("datasource-retry-max-attempts", config.General.DatasourceRetryMaxAttempts, 0)
+	config.General.DatasourceRetryMaxElapsedTimeParsed = parseDurationOrDefault("datasource-retry-max-elapsed-time", config.General.DatasourceRetryMaxElapsedTime, 0)
+	config.General.DatasourceBreakerThresholdParsed = parseIntOrDefault("datasource-circuit-breaker-threshold", config.General.DatasourceBreakerThreshold, DATASOURCE_BREAKER_THRESHOLD_DEFAULT)
+	config.General.DatasourceBreakerOpenTimeoutParsed = parseDurationOrDefault("datasource-circuit-breaker-open-timeout", config.General.DatasourceBreakerOpenTimeout, DATASOURCE_BREAKER_OPEN_TIMEOUT_DEFAULT)
+
 	if config.General.PushRetry == nil {
 		config.General.PushRetry = &defaultTrue
 	}
@@ -614,6 +780,15 @@ func processHiddenFields(config *Config) {
 			queryLag = -1
 		}
 		queryConfig.QueryLagDuration = queryLag
+
+		if queryConfig.SplitMinTimerange != "" {
+			splitMinTimerange, err := time.ParseDuration(queryConfig.SplitMinTimerange)
+			if err != nil || splitMinTimerange < 0 {
+				log.WithField(ec.FIELD, ec.LME_8104).Errorf("Error parsing split-min-timerange %v for query %v, time range splitting is disabled : %+v", queryConfig.SplitMinTimerange, queryName, err)
+				splitMinTimerange = 0
+			}
+			queryConfig.SplitMinTimerangeParsed = splitMinTimerange
+		}
 	}
 
 	for queryName, queryConfig := range config.Queries {
@@ -681,6 +856,43 @@ func processHiddenFields(config *Config) {
 			}
 		}
 		log.Infof("For metric %v found labels : %+v", metricName, metric.Labels)
+		if metric.Type == "summary" {
+			if len(metric.Objectives) == 0 {
+				metric.Objectives = append([]float64(nil), SUMMARY_OBJECTIVES_DEFAULT...)
+			}
+			if metric.MaxAge <= 0 {
+				metric.MaxAge = SUMMARY_MAX_AGE_DEFAULT
+			}
+			if metric.AgeBuckets <= 0 {
+				metric.AgeBuckets = SUMMARY_AGE_BUCKETS_DEFAULT
+			}
+		}
+		if metric.NativeHistogram != nil {
+			schema := parseFloatOrDefault(fmt.Sprintf("Native histogram schema for metric %v", metricName), metric.NativeHistogram.Schema, NATIVE_HISTOGRAM_SCHEMA_DEFAULT, NATIVE_HISTOGRAM_SCHEMA_MIN, NATIVE_HISTOGRAM_SCHEMA_MAX)
+			if schema != math.Trunc(schema) {
+				log.WithField(ec.FIELD, ec.LME_8104).Errorf("Native histogram schema for metric %v must be an integer, default value %v will be used instead", metricName, NATIVE_HISTOGRAM_SCHEMA_DEFAULT)
+				schema = NATIVE_HISTOGRAM_SCHEMA_DEFAULT
+			}
+			metric.NativeHistogram.SchemaParsed = int32(schema)
+			metric.NativeHistogram.ZeroThresholdParsed = parseFloatOrDefault(fmt.Sprintf("Native histogram zero-threshold for metric %v", metricName), metric.NativeHistogram.ZeroThreshold, NATIVE_HISTOGRAM_ZERO_THRESHOLD_DEFAULT, 0, math.MaxFloat64)
+		}
+		if metric.Operation == "count-distinct" {
+			metric.DistinctPrecision = parseIntOrDefault(fmt.Sprintf("Precision for metric %v", metricName), metric.Parameters["precision"], COUNT_DISTINCT_PRECISION_DEFAULT)
+			if metric.DistinctPrecision < COUNT_DISTINCT_PRECISION_MIN || metric.DistinctPrecision > COUNT_DISTINCT_PRECISION_MAX {
+				log.WithField(ec.FIELD, ec.LME_8104).Errorf("Precision for metric %v can not be out of range [%v ; %v], default value %v will be used instead", metricName, COUNT_DISTINCT_PRECISION_MIN, COUNT_DISTINCT_PRECISION_MAX, COUNT_DISTINCT_PRECISION_DEFAULT)
+				metric.DistinctPrecision = COUNT_DISTINCT_PRECISION_DEFAULT
+			}
+			metric.DistinctWindowIntervals = parseIntOrDefault(fmt.Sprintf("Window intervals for metric %v", metricName), metric.Parameters["window-intervals"], COUNT_DISTINCT_WINDOW_INTERVALS_DEFAULT)
+			if metric.DistinctWindowIntervals == 0 {
+				metric.DistinctWindowIntervals = COUNT_DISTINCT_WINDOW_INTERVALS_DEFAULT
+			}
+		}
+		if metric.Operation == "quantile" && len(metric.Quantiles) == 0 {
+			metric.Quantiles = append([]float64(nil), QUANTILES_DEFAULT...)
+		}
+		if metric.Operation == "quantile" || metric.Type == "summary" {
+			metric.QuantileRelativeAccuracy = parseFloatOrDefault(fmt.Sprintf("Relative accuracy for metric %v", metricName), metric.Parameters["relative-accuracy"], QUANTILE_RELATIVE_ACCURACY_DEFAULT, 0.0001, 0.5)
+		}
 	}
 
 	for metricName, metric := range config.Metrics {
@@ -716,10 +928,91 @@ func processHiddenFields(config *Config) {
 	}
 }
 
-func initDSName(config *Config) {
-	for k := range config.Datasources {
-		config.DsName = k
-		break
+// getDatasourceEnv returns the value of the environment variable name_DSNAME for the datasource dsName,
+// the variable without the datasource suffix is used only if the datasource is the only one
+func getDatasourceEnv(name string, dsName string, isOnlyDatasource bool) string {
+	suffix := strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(dsName))
+	if value, ok := os.LookupEnv(name + "_" + suffix); ok {
+		log.Debugf("%v_%v is used for datasource %v", name, suffix, dsName)
+		return value
+	}
+	if isOnlyDatasource {
+		return os.Getenv(name)
+	}
+	return ""
+}
+
+func parseDurationOrDefault(name string, value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		log.Infof("%v is empty, using the default value %v", name, defaultValue)
+		return defaultValue
+	}
+	val, err := time.ParseDuration(value)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_8104).Errorf("Error parsing %v default value %v will be used : %+v", name, defaultValue, err)
+		return defaultValue
+	}
+	if val < 0 {
+		log.WithField(ec.FIELD, ec.LME_8104).Errorf("%v can not be negative, default value %v will be used instead", name, defaultValue)
+		return defaultValue
+	}
+	log.Infof("%v %+v parsed successfully", name, val)
+	return val
+}
+
+func parseIntOrDefault(name string, value string, defaultValue int) int {
+	if value == "" {
+		log.Infof("%v is empty, using the default value %v", name, defaultValue)
+		return defaultValue
+	}
+	val, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_8104).Errorf("Error parsing %v default value %v will be used : %+v", name, defaultValue, err)
+		return defaultValue
+	}
+	if val < 0 || val > math.MaxInt32 {
+		log.WithField(ec.FIELD, ec.LME_8104).Errorf("%v can not be out of range [0 ; %v], default value %v will be used instead", name, math.MaxInt32, defaultValue)
+		return defaultValue
+	}
+	log.Infof("%v %v parsed successfully", name, val)
+	return int(val)
+}
+
+func parseFloatOrDefault(name string, value string, defaultValue float64, min float64, max float64) float64 {
+	if value == "" {
+		log.Infof("%v is empty, using the default value %v", name, defaultValue)
+		return defaultValue
+	}
+	val, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_8104).Errorf("Error parsing %v default value %v will be used : %+v", name, defaultValue, err)
+		return defaultValue
+	}
+	if val < min || val > max {
+		log.WithField(ec.FIELD, ec.LME_8104).Errorf("%v can not be out of range [%v ; %v], default value %v will be used instead", name, min, max, defaultValue)
+		return defaultValue
+	}
+	log.Infof("%v %v parsed successfully", name, val)
+	return val
+}
+
+// initQueriesDatasource binds the queries without datasource to the only datasource if exactly one datasource is configured
+func initQueriesDatasource(config *Config) {
+	if len(config.Datasources) != 1 {
+		return
+	}
+	for dsName := range config.Datasources {
+		for queryName, queryConfig := range config.Queries {
+			if queryConfig != nil && queryConfig.Datasource == "" {
+				log.Debugf("Query %v is bound to the only datasource %v", queryName, dsName)
+				queryConfig.Datasource = dsName
+			}
+		}
 	}
 }
 
@@ -786,9 +1079,51 @@ func (tlsh *TLSHostConfig) processTlsSettings() error {
 		return err
 	}
 	tlsh.TlsConfig = tlsCfg
+	if err = tlsh.Auth.check(); err != nil {
+		return err
+	}
 	if tlsh.ConnectionTimeout == 0 {
 		tlsh.ConnectionTimeout = time.Second * 30
 	}
+	if tlsh.MaxIdleConns <= 0 {
+		tlsh.MaxIdleConns = 100
+	}
+	if tlsh.MaxIdleConnsPerHost <= 0 {
+		tlsh.MaxIdleConnsPerHost = 10
+	}
+	if tlsh.MaxConnsPerHost < 0 {
+		tlsh.MaxConnsPerHost = 0
+	}
+	if tlsh.IdleConnTimeout <= 0 {
+		tlsh.IdleConnTimeout = time.Second * 90
+	}
+	return nil
+}
+
+func (auth *AuthConfig) check() error {
+	if auth == nil {
+		return nil
+	}
+	tokenSources := 0
+	for _, isSet := range []bool{auth.BearerToken != "", auth.BearerTokenFile != "", auth.OAuth2 != nil} {
+		if isSet {
+			tokenSources++
+		}
+	}
+	if tokenSources > 1 {
+		return fmt.Errorf("only one of bearer-token, bearer-token-file and oauth2 can be set in auth")
+	}
+	if auth.OAuth2 != nil {
+		if auth.OAuth2.ClientId == "" {
+			return fmt.Errorf("oauth2 must have field client-id defined")
+		}
+		if auth.OAuth2.ClientSecret != "" && auth.OAuth2.ClientSecretFile != "" {
+			return fmt.Errorf("only one of client-secret and client-secret-file can be set in oauth2")
+		}
+		if _, err := url.ParseRequestURI(auth.OAuth2.TokenUrl); err != nil {
+			return fmt.Errorf("oauth2 must have correct token-url, current value %v is incorrect : %w", auth.OAuth2.TokenUrl, err)
+		}
+	}
 	return nil
 }
 
@@ -24,10 +24,18 @@ import (
 func TestExampleConfigs(t *testing.T) {
 	log.SetLevel(log.ErrorLevel)
 	exampleConfigs := []string{
+		"../../examples/config_clickhouse.yaml",
 		"../../examples/config_cloud_promrw.yaml",
 		"../../examples/config_cloud_victoria.yaml",
 		"../../examples/config_emu.yaml",
+		"../../examples/config_file.yaml",
+		"../../examples/config_loki_metrics.yaml",
+		"../../examples/config_multi_datasource.yaml",
 		"../../examples/config_nr.yaml",
+		"../../examples/config_nr_nerdgraph.yaml",
+		"../../examples/config_opensearch.yaml",
+		"../../examples/config_receiver.yaml",
+		"../../examples/config_victorialogs.yaml",
 		"../../examples/unit_test.yaml",
 	}
 	for i, path := range exampleConfigs {
@@ -43,3 +51,79 @@ func TestExampleConfigs(t *testing.T) {
 		})
 	}
 }
+
+func TestQuantileMetricReservedLabel(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	path := "../../examples/config_opensearch.yaml"
+	testConfig, err := SimpleSilentRead(path)
+	if err != nil {
+		t.Fatalf("Error parsing test config %v : %+v", path, err)
+	}
+	metricConfig := testConfig.Metrics["opensearch_http_duration_quantiles"]
+	metricConfig.LabelsInitial = append(metricConfig.LabelsInitial, "quantile")
+	if err = ValidateConfig(testConfig); err == nil {
+		t.Errorf("Expected config to be invalid for the quantile metric with label quantile")
+	}
+}
+
+func TestQueriesDatasourceBinding(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	path := "../../examples/config_multi_datasource.yaml"
+	for _, tc := range []struct {
+		name       string
+		datasource string
+		valid      bool
+	}{
+		{name: "Defined", datasource: "loki1", valid: true},
+		{name: "Missing", datasource: "", valid: false},
+		{name: "Unknown", datasource: "loki2", valid: false},
+	} {
+		t.Run("TestQueriesDatasourceBinding"+tc.name, func(t *testing.T) {
+			testConfig, err := SimpleSilentRead(path)
+			if err != nil {
+				t.Fatalf("Error parsing test config %v : %+v", path, err)
+			}
+			testConfig.Queries["query_loki"].Datasource = tc.datasource
+			err = ValidateConfig(testConfig)
+			if tc.valid && err != nil {
+				t.Errorf("Expected config to be valid, got : %+v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Errorf("Expected config to be invalid for datasource %q", tc.datasource)
+			}
+		})
+	}
+
+	testConfig, err := SimpleSilentRead("../../examples/config_loki.yaml")
+	if err != nil {
+		t.Fatalf("Error parsing test config : %+v", err)
+	}
+	for queryName, queryConfig := range testConfig.Queries {
+		if queryConfig.Datasource != "loki" {
+			t.Errorf("Expected query %v to be bound to the only datasource loki, got %q", queryName, queryConfig.Datasource)
+		}
+	}
+}
+
+func TestEnrichFromEnvironmentVariables_DatasourceCredentials(t *testing.T) {
+	log.SetLevel(log.FatalLevel)
+	t.Setenv("GRAYLOG_USER", "shared")
+	t.Setenv("GRAYLOG_PASSWORD", "shared-password")
+	t.Setenv("GRAYLOG_USER_GRAYLOG_EU", "eu")
+	t.Setenv("GRAYLOG_PASSWORD_GRAYLOG_EU", "eu-password")
+
+	testConfig := &Config{Datasources: map[string]*DatasourceConfig{"graylog-eu": {}, "graylog-us": {}}}
+	enrichFromEnvironmentVariables(testConfig)
+	if eu := testConfig.Datasources["graylog-eu"]; eu.User != "eu" || eu.Password != "eu-password" {
+		t.Errorf("Expected the credentials with the datasource suffix, got %q / %q", eu.User, eu.Password)
+	}
+	if us := testConfig.Datasources["graylog-us"]; us.User != "" || us.Password != "" {
+		t.Errorf("Expected no shared credentials for several datasources, got %q / %q", us.User, us.Password)
+	}
+
+	testConfig = &Config{Datasources: map[string]*DatasourceConfig{"graylog-us": {}}}
+	enrichFromEnvironmentVariables(testConfig)
+	if us := testConfig.Datasources["graylog-us"]; us.User != "shared" || us.Password != "shared-password" {
+		t.Errorf("Expected the credentials without suffix for the only datasource, got %q / %q", us.User, us.Password)
+	}
+}
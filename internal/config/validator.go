@@ -20,9 +20,11 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"log_exporter/internal/utils"
@@ -37,11 +39,37 @@ var allowedMetricOperations = map[string]bool{
 	"value":                true,
 	"duration":             true,
 	"duration-no-response": true,
+	"quantile":             true,
+	"count-distinct":       true,
 }
 var allowedMetricTypes = map[string]bool{
 	"gauge":     true,
 	"counter":   true,
 	"histogram": true,
+	"summary":   true,
+}
+
+var allowedLokiModes = map[string]bool{
+	"logs":    true,
+	"metrics": true,
+}
+
+var allowedNewRelicApis = map[string]bool{
+	"insights":  true,
+	"nerdgraph": true,
+}
+
+var maxResponseSizeDatasourceTypes = map[string]bool{
+	"graylog":      true,
+	"victorialogs": true,
+	"clickhouse":   true,
+	"file":         true,
+}
+
+var allowedFileFormats = map[string]bool{
+	"raw":    true,
+	"json":   true,
+	"logfmt": true,
 }
 
 var countMetricsAllowedParams = map[string]bool{
@@ -50,9 +78,26 @@ var countMetricsAllowedParams = map[string]bool{
 }
 
 var valueMetricsAllowedParams = map[string]bool{
-	"value-field":   true,
-	"init-value":    true,
-	"default-value": true,
+	"value-field":       true,
+	"init-value":        true,
+	"default-value":     true,
+	"relative-accuracy": true,
+	"aggregation":       true,
+	"time_field":        true,
+	"time_format":       true,
+}
+
+var countDistinctMetricsAllowedParams = map[string]bool{
+	"precision":        true,
+	"window-intervals": true,
+	"default-value":    true,
+}
+
+var valueMetricsAggregations = map[string]bool{
+	"min":   true,
+	"max":   true,
+	"first": true,
+	"last":  true,
 }
 
 var durationMetricsAllowedParams = map[string]bool{
@@ -66,6 +111,22 @@ var durationMetricsAllowedParams = map[string]bool{
 	"cache-update":          true,
 	"init-value":            true,
 	"default-value":         true,
+	"relative-accuracy":     true,
+}
+
+var quantileMetricsAllowedParams = map[string]bool{
+	"value-field":           true,
+	"time_field":            true,
+	"time_format":           true,
+	"message_type_field":    true,
+	"message_type_request":  true,
+	"message_type_response": true,
+	"correlation_id_field":  true,
+	"cache":                 true,
+	"cache-update":          true,
+	"init-value":            true,
+	"default-value":         true,
+	"relative-accuracy":     true,
 }
 
 var durationNoRespMetricsAllowedParams = map[string]bool{
@@ -111,7 +172,7 @@ func SimpleSilentRead(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling config file %v : %+v", path, err)
 	}
-	initDSName(&config)
+	initQueriesDatasource(&config)
 
 	return &config, nil
 }
@@ -124,12 +185,22 @@ func ValidateConfig(config *Config) error {
 		startupBlockingErrors = append(startupBlockingErrors, err.Error())
 	}
 
-	if len(config.Datasources) != 1 {
-		startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section datasources : Datasources count is %v, but datasources count must be equal to 1", len(config.Datasources)))
+	if len(config.Datasources) == 0 {
+		startupBlockingErrors = append(startupBlockingErrors, "Section datasources : No datasources are specified")
 	}
 
 	for dsName, dsConfig := range config.Datasources {
-		if dsConfig == nil || dsConfig.Host == "" /* || dsConfig.User == "" || dsConfig.Password == "" */ {
+		if dsConfig != nil && strings.ToLower(dsConfig.Type) == "file" {
+			if dsConfig.Path == "" {
+				startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section datasources : Datasource %v of type file must have field path defined", dsName))
+			} else if _, err := filepath.Glob(dsConfig.Path); err != nil {
+				startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section datasources : Datasource %v must have correct path, current value %v is incorrect : %+v", dsName, dsConfig.Path, err))
+			}
+		} else if dsConfig != nil && strings.ToLower(dsConfig.Type) == "receiver" {
+			if dsConfig.SyslogUdpAddress == "" && dsConfig.SyslogTcpAddress == "" && dsConfig.HttpAddress == "" {
+				startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section datasources : Datasource %v of type receiver must have at least one of the fields syslog-udp-address, syslog-tcp-address and http-address defined", dsName))
+			}
+		} else if dsConfig == nil || dsConfig.Host == "" /* || dsConfig.User == "" || dsConfig.Password == "" */ {
 			startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section datasources : Datasource %v must have field host defined", dsName))
 		} else {
 			_, err := url.ParseRequestURI(dsConfig.Host)
@@ -137,6 +208,9 @@ func ValidateConfig(config *Config) error {
 				startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section datasources : Datasource %v must have correct host, current value %v is incorrect : %+v", dsName, dsConfig.Host, err))
 			}
 		}
+		if dsConfig != nil && dsConfig.Record != nil && dsConfig.Record.Path == "" {
+			startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section datasources : Datasource %v must have field record.path defined", dsName))
+		}
 	}
 
 	pullExportersCount := 0
@@ -192,6 +266,27 @@ func ValidateConfig(config *Config) error {
 		startupBlockingErrors = append(startupBlockingErrors, "Section queries : No queries are specified")
 	}
 
+	for metricName, metricConfig := range config.Metrics {
+		if metricConfig == nil || metricConfig.Operation != "quantile" {
+			continue
+		}
+		if _, ok := metricConfig.LabelFieldMap["quantile"]; ok || utils.FindStringIndexInArray(metricConfig.LabelsInitial, "quantile") >= 0 {
+			startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section metrics : Metric %v of quantile operation has label quantile, which is reserved for the quantile value", metricName))
+		}
+	}
+
+	for queryName, queryConfig := range config.Queries {
+		if queryConfig == nil {
+			continue
+		}
+		if queryConfig.Datasource == "" {
+			startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section queries : For query %v datasource must be specified, because %v datasources are configured", queryName, len(config.Datasources)))
+		} else if config.Datasources[queryConfig.Datasource] == nil {
+			startupBlockingErrors = append(startupBlockingErrors, fmt.Sprintf("Section queries : For query %v datasource %v is not defined in the datasources section", queryName, queryConfig.Datasource))
+		}
+	}
+
+	performDatasourcesNonBlockingChecks(config)
 	performExportsNonBlockingChecks(config)
 	performMetricsNonBlockingChecks(config)
 	performQueriesNonBlockingChecks(config)
@@ -209,6 +304,83 @@ func ValidateConfig(config *Config) error {
 	return nil
 }
 
+func performDatasourcesNonBlockingChecks(config *Config) {
+	queriesCount := make(map[string]int)
+	for _, queryConfig := range config.Queries {
+		if queryConfig != nil {
+			queriesCount[queryConfig.Datasource]++
+		}
+	}
+	for dsName, dsConfig := range config.Datasources {
+		if dsConfig == nil {
+			continue
+		}
+		if queriesCount[dsName] == 0 {
+			log.Warnf("Section datasources : Datasource %v is not used by any query", dsName)
+		}
+		dsType := strings.ToLower(dsConfig.Type)
+		if dsType == "" {
+			dsType = DATASOURCE_TYPE_DEFAULT
+		}
+		if dsConfig.Mode != "" {
+			if dsType != "loki" {
+				log.Warnf("Section datasources : For datasource %v mode %v is set, but mode is supported only by the loki datasource type", dsName, dsConfig.Mode)
+			} else if !allowedLokiModes[strings.ToLower(dsConfig.Mode)] {
+				log.Warnf("Section datasources : For datasource %v mode %v is not supported, allowed values are 'logs' and 'metrics'", dsName, dsConfig.Mode)
+			}
+		}
+		if dsConfig.Api != "" {
+			if dsType != "newrelic" {
+				log.Warnf("Section datasources : For datasource %v api %v is set, but api is supported only by the newrelic datasource type", dsName, dsConfig.Api)
+			} else if !allowedNewRelicApis[strings.ToLower(dsConfig.Api)] {
+				log.Warnf("Section datasources : For datasource %v api %v is not supported, allowed values are 'insights' and 'nerdgraph'", dsName, dsConfig.Api)
+			}
+		}
+		if dsType == "file" {
+			if dsConfig.Format != "" && !allowedFileFormats[strings.ToLower(dsConfig.Format)] {
+				log.Warnf("Section datasources : For datasource %v format %v is not supported, allowed values are 'raw', 'json' and 'logfmt'", dsName, dsConfig.Format)
+			}
+			if dsConfig.OffsetsFile == "" {
+				log.Warnf("Section datasources : For datasource %v offsets-file is not set, the file offsets are not kept across restarts", dsName)
+			}
+		} else if dsConfig.Path != "" || dsConfig.Format != "" || dsConfig.OffsetsFile != "" {
+			log.Warnf("Section datasources : For datasource %v path, format or offsets-file is set, but they are supported only by the file datasource type", dsName)
+		}
+		if dsType != "receiver" && (dsConfig.SyslogUdpAddress != "" || dsConfig.SyslogTcpAddress != "" || dsConfig.HttpAddress != "") {
+			log.Warnf("Section datasources : For datasource %v syslog-udp-address, syslog-tcp-address or http-address is set, but they are supported only by the receiver datasource type", dsName)
+		}
+		if dsConfig.PageSize < 0 {
+			log.Warnf("Section datasources : For datasource %v page-size %v is negative, the default value will be used", dsName, dsConfig.PageSize)
+		}
+		if dsConfig.MaxRecords < 0 {
+			log.Warnf("Section datasources : For datasource %v max-records %v is negative, the default value will be used", dsName, dsConfig.MaxRecords)
+		}
+		if dsConfig.MaxResponseSize < 0 {
+			log.Warnf("Section datasources : For datasource %v max-response-size %v is negative, the response size will not be limited", dsName, dsConfig.MaxResponseSize)
+		} else if dsConfig.MaxResponseSize > 0 && !maxResponseSizeDatasourceTypes[dsType] {
+			log.Warnf("Section datasources : For datasource %v max-response-size is set, but it is supported only by the graylog, victorialogs, clickhouse and file datasource types", dsName)
+		}
+		if dsConfig.MaxConcurrentRequests < 0 {
+			log.Warnf("Section datasources : For datasource %v max-concurrent-requests %v is negative, the number of concurrent requests will not be limited", dsName, dsConfig.MaxConcurrentRequests)
+		}
+		if dsConfig.RequestsPerSecond < 0 {
+			log.Warnf("Section datasources : For datasource %v requests-per-second %v is negative, the request rate will not be limited", dsName, dsConfig.RequestsPerSecond)
+		}
+		if (dsType == "file" || dsType == "receiver") && (dsConfig.MaxConcurrentRequests != 0 || dsConfig.RequestsPerSecond != 0) {
+			log.Warnf("Section datasources : For datasource %v max-concurrent-requests or requests-per-second is set, but the %v datasource type sends no requests", dsName, dsType)
+		}
+		if (dsType == "file" || dsType == "receiver") && dsConfig.Record != nil {
+			log.Warnf("Section datasources : For datasource %v record is set, but the %v datasource type receives no HTTP responses, nothing is recorded", dsName, dsType)
+		}
+		if dsConfig.Record != nil && dsConfig.Record.MaxFiles < 0 {
+			log.Warnf("Section datasources : For datasource %v record.max-files %v is negative, the default value will be used", dsName, dsConfig.Record.MaxFiles)
+		}
+		if dsConfig.Record != nil && dsConfig.Record.MaxAge < 0 {
+			log.Warnf("Section datasources : For datasource %v record.max-age %v is negative, the recorded results will not be removed by age", dsName, dsConfig.Record.MaxAge)
+		}
+	}
+}
+
 func performExportsNonBlockingChecks(config *Config) {
 	for exportName, exportConfig := range config.Exports {
 		if exportConfig == nil {
@@ -232,6 +404,8 @@ func performExportsNonBlockingChecks(config *Config) {
 func performMetricsNonBlockingChecks(config *Config) {
 	queryMetrics := getQueryMetricsCountMap(config)
 	childMetrics := getChildMetricsCountMap(config)
+	pushExport := getPushExport(config)
+	isVictoriaPush := pushExport != nil && (pushExport.Consumer == "" || pushExport.Consumer == "victoria-vmagent")
 
 	for metricName, metricConfig := range config.Metrics {
 		if metricConfig == nil {
@@ -260,7 +434,14 @@ func performMetricsNonBlockingChecks(config *Config) {
 			log.Warnf("Section metrics : Metric %v has empty description", metricName)
 		}
 
-		if metricConfig.Type == "histogram" && len(metricConfig.Buckets) == 0 {
+		if metricConfig.Type == "histogram" && metricConfig.NativeHistogram != nil {
+			if len(metricConfig.Buckets) > 0 {
+				log.Warnf("Section metrics : Metric %v is a native histogram and has buckets configured, which are ignored", metricName)
+			}
+			if isVictoriaPush {
+				log.Warnf("Section metrics : Metric %v is a native histogram, but the victoria-vmagent push export does not support native histograms, only the sum, the count and the +Inf bucket are pushed", metricName)
+			}
+		} else if metricConfig.Type == "histogram" && len(metricConfig.Buckets) == 0 {
 			log.Warnf("Section metrics : Metric %v of histogram type doesn't have buckets configured", metricName)
 		} else if metricConfig.Type != "histogram" && len(metricConfig.Buckets) > 0 {
 			log.Warnf("Section metrics : Metric %v of %v type has buckets configured", metricName, metricConfig.Type)
@@ -273,6 +454,9 @@ func performMetricsNonBlockingChecks(config *Config) {
 				buckets[bucketValue] = true
 			}
 		}
+		if metricConfig.Type != "histogram" && metricConfig.NativeHistogram != nil {
+			log.Warnf("Section metrics : Metric %v of %v type has native-histogram configured, which is supported only for the histogram type", metricName, metricConfig.Type)
+		}
 
 		if len(metricConfig.MultiValueFields) > 0 {
 			if metricConfig.Operation != "count" {
@@ -284,9 +468,9 @@ func performMetricsNonBlockingChecks(config *Config) {
 			log.Warnf("Section metrics : Metric %v of %v operation has id-field configured, which is supported only for the count operation", metricName, metricConfig.Operation)
 		}
 
-		if metricConfig.MetricValue == "" && metricConfig.Operation == "value" {
-			log.Warnf("Section metrics : Metric %v of value operation doesn't have metric-value configured", metricName)
-		} else if metricConfig.MetricValue != "" && metricConfig.Operation != "value" {
+		if metricConfig.MetricValue == "" && (metricConfig.Operation == "value" || metricConfig.Operation == "count-distinct") {
+			log.Warnf("Section metrics : Metric %v of %v operation doesn't have metric-value configured", metricName, metricConfig.Operation)
+		} else if metricConfig.MetricValue != "" && metricConfig.Operation != "value" && metricConfig.Operation != "quantile" && metricConfig.Operation != "count-distinct" {
 			log.Warnf("Section metrics : Metric %v of %v operation has metric-value configured", metricName, metricConfig.Operation)
 		}
 
@@ -301,12 +485,32 @@ func performMetricsNonBlockingChecks(config *Config) {
 			}
 		}
 
+		if metricConfig.Operation == "count-distinct" {
+			for paramName := range metricConfig.Parameters {
+				if !countDistinctMetricsAllowedParams[paramName] {
+					log.Warnf("Section metrics : Metric %v has not supported parameter %v for count-distinct operation", metricName, paramName)
+				}
+			}
+			if metricConfig.Type != "gauge" {
+				log.Warnf("Section metrics : Metric %v of count-distinct operation has type %v, only gauge type is supported", metricName, metricConfig.Type)
+			}
+		}
+
 		if metricConfig.Operation == "value" {
 			for paramName := range metricConfig.Parameters {
 				if !valueMetricsAllowedParams[paramName] {
 					log.Warnf("Section metrics : Metric %v has not supported parameter %v for value operation", metricName, paramName)
 				}
 			}
+			aggregation := metricConfig.Parameters["aggregation"]
+			if aggregation != "" && !valueMetricsAggregations[aggregation] {
+				log.Warnf("Section metrics : Metric %v has not supported aggregation %v, only min, max, first and last are supported", metricName, aggregation)
+			} else if aggregation != "" && metricConfig.Type != "gauge" {
+				log.Warnf("Section metrics : Metric %v of %v type has aggregation %v, which is supported only for gauge type and is ignored", metricName, metricConfig.Type, aggregation)
+			}
+			if metricConfig.Parameters["time_field"] != "" && aggregation != "first" && aggregation != "last" {
+				log.Warnf("Section metrics : Metric %v has parameter time_field, which is used by the value operation only for the first and last aggregations", metricName)
+			}
 		}
 
 		if metricConfig.Operation == "duration" {
@@ -335,6 +539,59 @@ func performMetricsNonBlockingChecks(config *Config) {
 			}
 		}
 
+		if metricConfig.Operation == "quantile" {
+			if metricConfig.Type != "gauge" && metricConfig.Type != "summary" {
+				log.Warnf("Section metrics : Metric %v of quantile operation has type %v, only gauge and summary types are supported", metricName, metricConfig.Type)
+			}
+			if metricConfig.Type == "summary" && len(metricConfig.Quantiles) > 0 {
+				log.Warnf("Section metrics : Metric %v of summary type has quantiles configured, which are ignored, objectives are used instead", metricName)
+			}
+			quantiles := make(map[float64]bool, len(metricConfig.Quantiles))
+			for _, quantile := range metricConfig.Quantiles {
+				if quantile < 0 || quantile > 1 {
+					log.Warnf("Section metrics : Metric %v of quantile operation has quantile %v out of range [0 ; 1], its value will be NaN", metricName, quantile)
+				}
+				if quantiles[quantile] {
+					log.Warnf("Section metrics : Metric %v of quantile operation has duplicate quantile %v configured", metricName, quantile)
+				}
+				quantiles[quantile] = true
+			}
+			for paramName := range metricConfig.Parameters {
+				if !quantileMetricsAllowedParams[paramName] {
+					log.Warnf("Section metrics : Metric %v has not supported parameter %v for quantile operation", metricName, paramName)
+				}
+			}
+		} else if len(metricConfig.Quantiles) > 0 {
+			log.Warnf("Section metrics : Metric %v of %v operation has quantiles configured, which are supported only for the quantile operation", metricName, metricConfig.Operation)
+		}
+
+		if metricConfig.Type == "summary" {
+			if metricConfig.Operation != "value" && metricConfig.Operation != "duration" && metricConfig.Operation != "quantile" {
+				log.Warnf("Section metrics : Metric %v of summary type has operation %v, only value, duration and quantile operations are supported", metricName, metricConfig.Operation)
+			}
+			objectives := make(map[float64]bool, len(metricConfig.Objectives))
+			for _, objective := range metricConfig.Objectives {
+				if objective < 0 || objective > 1 {
+					log.Warnf("Section metrics : Metric %v of summary type has objective %v out of range [0 ; 1], its value will be NaN", metricName, objective)
+				}
+				if objectives[objective] {
+					log.Warnf("Section metrics : Metric %v of summary type has duplicate objective %v configured", metricName, objective)
+				}
+				objectives[objective] = true
+			}
+			if metricConfig.MaxAge < 0 {
+				log.Warnf("Section metrics : Metric %v of summary type has negative max-age %v, the default value will be used", metricName, metricConfig.MaxAge)
+			}
+			if metricConfig.AgeBuckets < 0 {
+				log.Warnf("Section metrics : Metric %v of summary type has negative age-buckets %v, the default value will be used", metricName, metricConfig.AgeBuckets)
+			}
+		} else if len(metricConfig.Objectives) > 0 || metricConfig.MaxAge != 0 || metricConfig.AgeBuckets != 0 {
+			log.Warnf("Section metrics : Metric %v of %v type has objectives, max-age or age-buckets configured, which are supported only for the summary type", metricName, metricConfig.Type)
+		}
+		if metricConfig.Parameters["relative-accuracy"] != "" && metricConfig.Operation != "quantile" && metricConfig.Type != "summary" {
+			log.Warnf("Section metrics : Metric %v has parameter relative-accuracy, which is supported only for the quantile operation and the summary type", metricName)
+		}
+
 		if metricConfig.Operation == "duration-no-response" {
 			for paramName := range metricConfig.Parameters {
 				if !durationNoRespMetricsAllowedParams[paramName] {
@@ -382,7 +639,6 @@ func performMetricsNonBlockingChecks(config *Config) {
 }
 
 func performQueriesNonBlockingChecks(config *Config) {
-	isNewRelic := config.Datasources[config.DsName].Type == "newrelic"
 	parser := utils.GetCronParser()
 	pushExport := getPushExport(config)
 	for queryName, queryConfig := range config.Queries {
@@ -391,6 +647,18 @@ func performQueriesNonBlockingChecks(config *Config) {
 			queryConfig = &QueryConfig{}
 		}
 
+		dsConfig := config.Datasources[queryConfig.Datasource]
+		dsType := ""
+		if dsConfig != nil {
+			dsType = strings.ToLower(dsConfig.Type)
+		}
+		isNewRelic := dsType == "newrelic"
+		isLokiMetrics := dsType == "loki" && strings.ToLower(dsConfig.Mode) == "metrics"
+		isClickHouse := dsType == "clickhouse"
+		isVictoriaLogs := dsType == "victorialogs"
+		isFile := dsType == "file"
+		isReceiver := dsType == "receiver"
+
 		metrics := make(map[string]bool, len(queryConfig.Metrics))
 		for _, metricName := range queryConfig.Metrics {
 			if config.Metrics[metricName] == nil {
@@ -403,8 +671,12 @@ func performQueriesNonBlockingChecks(config *Config) {
 			}
 		}
 
-		if len(queryConfig.QueryString) == 0 {
+		if len(queryConfig.QueryString) == 0 && !isFile && !isReceiver {
 			log.Warnf("Section queries : For query %v query_string is empty", queryName)
+		} else if strings.Contains(queryConfig.QueryString, "{{") {
+			if _, err := template.New("query_template").Parse(queryConfig.QueryString); err != nil {
+				log.Warnf("Section queries : For query %v query_string can not be parsed as template : %+v", queryName, err)
+			}
 		}
 
 		if len(queryConfig.Timerange) == 0 {
@@ -416,10 +688,19 @@ func performQueriesNonBlockingChecks(config *Config) {
 			}
 		}
 
-		if len(queryConfig.FieldsInOrder) == 0 && !isNewRelic {
+		if len(queryConfig.FieldsInOrder) == 0 && !isNewRelic && !isLokiMetrics && !isClickHouse && !isFile && !isReceiver {
 			log.Warnf("Section queries : For query %v fields_in_order list is empty", queryName)
 		}
 
+		if len(queryConfig.Cond) > 0 && !isReceiver {
+			log.Warnf("Section queries : For query %v conditions are set, but they are supported only by the receiver datasource type", queryName)
+		}
+		for i, condition := range queryConfig.Cond {
+			if condition["equ"] == nil {
+				log.Warnf("Section queries : For query %v condition %v has no equ operation and never matches", queryName, i)
+			}
+		}
+
 		if len(queryConfig.Croniter) == 0 {
 			log.Warnf("Section queries : For query %v croniter is empty", queryName)
 		} else {
@@ -481,6 +762,10 @@ func performQueriesNonBlockingChecks(config *Config) {
 			}
 		}
 
+		if pushExport != nil && pushExport.LastTimestampHost != nil && isFile {
+			log.Warnf("Section queries : For query %v push exporter is configured with last-timestamp-host, but history is not processed for the file datasource type", queryName)
+		}
+
 		if pushExport != nil && pushExport.LastTimestampHost != nil {
 			if pushExport.LastTimestampHost.Endpoint == "" && queryConfig.LastTimestampEndpoint == "" {
 				log.Warnf("Section queries : For query %v last-timestamp-endpoint must be set, because push exporter is configured with last-timestamp-host", queryName)
@@ -499,7 +784,22 @@ func performQueriesNonBlockingChecks(config *Config) {
 			}
 		}
 
-		if isNewRelic {
+		if queryConfig.SplitMinTimerange != "" || queryConfig.SplitMaxRecords != 0 {
+			if isNewRelic || isLokiMetrics {
+				log.Warnf("Section queries : For query %v time range splitting is configured, but it is supported only for queries returning log records, splitting is ignored", queryName)
+			} else if isFile || isReceiver {
+				log.Warnf("Section queries : For query %v time range splitting is configured, but the %v datasource type does not query time ranges, splitting is ignored", queryName, dsType)
+			} else if isClickHouse || isVictoriaLogs {
+				log.Warnf("Section queries : For query %v time range splitting is configured, but it is not supported by the %v datasource type, splitting is ignored", queryName, dsType)
+			} else if queryConfig.SplitMinTimerange == "" {
+				log.Warnf("Section queries : For query %v split-max-records is set, but split-min-timerange is empty, splitting is disabled", queryName)
+			}
+			if queryConfig.SplitMaxRecords < 0 {
+				log.Warnf("Section queries : For query %v split-max-records %v is negative, the check of the records count is disabled", queryName, queryConfig.SplitMaxRecords)
+			}
+		}
+
+		if isNewRelic || isLokiMetrics {
 			continue
 		}
 
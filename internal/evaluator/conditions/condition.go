@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package evaluator
+package conditions
 
 import (
 	"log_exporter/internal/utils"
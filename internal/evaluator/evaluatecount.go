@@ -17,6 +17,7 @@ package evaluator
 import (
 	"fmt"
 	"log_exporter/internal/config"
+	"log_exporter/internal/evaluator/conditions"
 	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
 	"strings"
@@ -105,9 +106,9 @@ func (e *Evaluator) evaluateCountMetricSeriesMapByOLV(data [][]string, metric st
 			e.idfcRepo.GetMetricIdFieldCache(metric).IncAge()
 		}
 	}
-	var meCondition *MECondition
+	var meCondition *conditions.MECondition
 	if metricCfg.Cond != nil {
-		meCondition = CreateMECondition(metric, metricCfg.Cond, heading)
+		meCondition = conditions.CreateMECondition(metric, metricCfg.Cond, heading)
 	}
 	log.Debugf("labelIndexes = %+v; heading = %v; idFieldIndex = %v for metric %v", labelIndexes, heading, idFieldIndex, metric)
 
@@ -162,7 +163,7 @@ const (
 	UNIQ_ID_STRATEGY_LABEL       = 2
 )
 
-func (e *Evaluator) evaluateCountMetricSeriesMapByOLVTask(data [][]string, metric string, metricCfg *config.MetricsConfig, labelIndexes []int, idFieldIndex int, meCondition *MECondition, start int, end int) map[string]*MetricSeries {
+func (e *Evaluator) evaluateCountMetricSeriesMapByOLVTask(data [][]string, metric string, metricCfg *config.MetricsConfig, labelIndexes []int, idFieldIndex int, meCondition *conditions.MECondition, start int, end int) map[string]*MetricSeries {
 	log.Debugf("evaluateCountMetricSeriesMapByOLVTask for metric %v ; start = %v , end = %v", metric, start, end)
 	result := make(map[string]*MetricSeries)
 
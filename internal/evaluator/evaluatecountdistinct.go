@@ -0,0 +1,202 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evaluator
+
+import (
+	"log_exporter/internal/config"
+	"log_exporter/internal/evaluator/conditions"
+	"log_exporter/internal/utils"
+	ec "log_exporter/internal/utils/errorcodes"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type DistinctWindowRepozitory struct {
+	sync.RWMutex
+	Metrics map[string]*MetricDistinctWindow // metric-name -> MetricDistinctWindow
+}
+
+// MetricDistinctWindow keeps the sketches of the last window-intervals evaluations of the count-distinct metric
+type MetricDistinctWindow struct {
+	sync.Mutex
+	size      int
+	intervals []map[string]*HyperLogLog // interval -> ordered-label-value -> sketch
+}
+
+func CreateDistinctWindowRepo(appConfig *config.Config) *DistinctWindowRepozitory {
+	repo := DistinctWindowRepozitory{}
+	repo.Metrics = make(map[string]*MetricDistinctWindow)
+	for metric, metricCfg := range appConfig.Metrics {
+		if metricCfg.Operation == "count-distinct" {
+			repo.Metrics[metric] = CreateMetricDistinctWindow(metricCfg.DistinctWindowIntervals)
+		}
+	}
+	return &repo
+}
+
+func CreateMetricDistinctWindow(size int) *MetricDistinctWindow {
+	return &MetricDistinctWindow{size: max(size, 1)}
+}
+
+func (r *DistinctWindowRepozitory) GetMetricDistinctWindow(metric string) *MetricDistinctWindow {
+	r.Lock()
+	defer r.Unlock()
+	result := r.Metrics[metric]
+	if result == nil {
+		result = CreateMetricDistinctWindow(1)
+		r.Metrics[metric] = result
+	}
+	return result
+}
+
+// Slide adds the sketches of the new interval to the window, drops the oldest interval if the window is full
+// and returns the sketches merged over the window. The sketches of the window are not modified
+func (w *MetricDistinctWindow) Slide(sketches map[string]*HyperLogLog) map[string]*HyperLogLog {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.intervals) == w.size {
+		copy(w.intervals, w.intervals[1:])
+		w.intervals = w.intervals[:w.size-1]
+	}
+	w.intervals = append(w.intervals, sketches)
+	if w.size == 1 {
+		return sketches
+	}
+	result := make(map[string]*HyperLogLog)
+	for _, interval := range w.intervals {
+		for olv, sketch := range interval {
+			if result[olv] == nil {
+				result[olv] = sketch.Clone()
+			} else {
+				result[olv].Merge(sketch)
+			}
+		}
+	}
+	return result
+}
+
+func (e *Evaluator) evaluateCountDistinctMetric(data [][]string, metric string, metricCfg *config.MetricsConfig) *MetricEvaluationResult {
+	log.Debugf("evaluateCountDistinctMetric %v", metric)
+	metricState := e.monState.Get(metric)
+	var metricSeriesMap map[string]*MetricSeries
+
+	if metricState == nil {
+		log.Warnf("MetricState is empty for %v", metric)
+		metricState = CreateMetricState()
+		e.monState.Set(metric, metricState)
+	}
+	result := CreateMetricEvaluationResult(metricState.Size())
+
+	defer func() {
+		result = e.performMetricPostEvaluationSteps(result, metricState, metricSeriesMap, metric, metricCfg)
+	}()
+
+	sketches := e.evaluateDistinctSketchesByOLV(data, metric, metricCfg)
+	sketches = e.dwRepo.GetMetricDistinctWindow(metric).Slide(sketches)
+
+	metricSeriesMap = make(map[string]*MetricSeries, len(sketches))
+	for olv, sketch := range sketches {
+		labels := metricState.Get(olv)
+		if labels == nil {
+			labels = generateLabelValueMapFromOLV(olv, metricCfg.Labels)
+			metricState.Set(olv, labels)
+			log.Debugf("Generate new metricstate values %v for metric %v", olv, metric)
+		}
+		ms := CreateMetricSeries(labels)
+		ms.Average = sketch.Estimate()
+		ms.Sum = ms.Average
+		ms.Count = uint64(ms.Average)
+		metricSeriesMap[olv] = &ms
+		result.Series = append(result.Series, ms)
+	}
+
+	return result
+}
+
+func (e *Evaluator) evaluateDistinctSketchesByOLV(data [][]string, metric string, metricCfg *config.MetricsConfig) map[string]*HyperLogLog {
+	log.Debugf("evaluateDistinctSketchesByOLV for metric %v", metric)
+	dataSize := len(data)
+	if dataSize < 2 {
+		log.Debugf("DataSize == %v for metric %v, evaluation result is empty", dataSize, metric)
+		return make(map[string]*HyperLogLog)
+	}
+
+	heading := data[0]
+	labelIndexes, err := evaluateLabelSourceFieldIndexes(metricCfg, heading)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_1020).Errorf("Can not evaluate count-distinct metric %v : %+v", metric, err)
+		return make(map[string]*HyperLogLog)
+	}
+	valueIndex := utils.FindStringIndexInArray(heading, metricCfg.MetricValue)
+	if valueIndex == -1 {
+		log.WithField(ec.FIELD, ec.LME_1020).Errorf("Can not evaluate count-distinct metric %v : field %v not found in the output", metric, metricCfg.MetricValue)
+		return make(map[string]*HyperLogLog)
+	}
+
+	var meCondition *conditions.MECondition
+	if metricCfg.Cond != nil {
+		meCondition = conditions.CreateMECondition(metric, metricCfg.Cond, heading)
+	}
+	threadsNumber := metricCfg.Threads
+	if threadsNumber > dataSize-1 {
+		threadsNumber = dataSize - 1
+	}
+	if threadsNumber <= 1 {
+		return evaluateDistinctSketchesByOLVTask(data, metricCfg, labelIndexes, valueIndex, meCondition, 1, len(data))
+	}
+	sketchesChan := make(chan map[string]*HyperLogLog)
+	for i := 0; i < threadsNumber; i++ {
+		start := 1 + i*(dataSize-1)/threadsNumber
+		end := 1 + (i+1)*(dataSize-1)/threadsNumber
+		go func() {
+			sketchesChan <- evaluateDistinctSketchesByOLVTask(data, metricCfg, labelIndexes, valueIndex, meCondition, start, end)
+		}()
+	}
+
+	result := <-sketchesChan
+	for i := 1; i < threadsNumber; i++ {
+		for olv, sketch := range <-sketchesChan {
+			if result[olv] == nil {
+				result[olv] = sketch
+			} else {
+				result[olv].Merge(sketch)
+			}
+		}
+	}
+
+	return result
+}
+
+func evaluateDistinctSketchesByOLVTask(data [][]string, metricCfg *config.MetricsConfig, labelIndexes []int, valueIndex int, meCondition *conditions.MECondition, start int, end int) map[string]*HyperLogLog {
+	result := make(map[string]*HyperLogLog)
+	for i := start; i < end; i++ {
+		if meCondition != nil && !meCondition.Apply(data[i]) {
+			continue
+		}
+		value := data[i][valueIndex]
+		if value == "" {
+			continue
+		}
+		olv := generateOrderedLabelValuesString(labelIndexes, data[i])
+		sketch := result[olv]
+		if sketch == nil {
+			sketch = CreateHyperLogLog(metricCfg.DistinctPrecision)
+			result[olv] = sketch
+		}
+		sketch.Add(value)
+	}
+	return result
+}
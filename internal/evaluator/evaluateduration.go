@@ -16,6 +16,7 @@ package evaluator
 
 import (
 	"fmt"
+	"log_exporter/internal/collectors"
 	"log_exporter/internal/config"
 	"log_exporter/internal/selfmonitor"
 	"log_exporter/internal/utils"
@@ -32,6 +33,7 @@ func (e *Evaluator) evaluateDurationMetric(data [][]string, metric string, metri
 	seriesMap := make(map[string]*MetricSeries)
 	metricState := e.monState.Get(metric)
 	isHistogram := (metricCfg.Type == "histogram")
+	withSketch := (metricCfg.Operation == "quantile" || metricCfg.Type == "summary")
 
 	if metricState == nil {
 		log.Warnf("MetricState is empty for %v", metric)
@@ -74,7 +76,10 @@ func (e *Evaluator) evaluateDurationMetric(data [][]string, metric string, metri
 		if ms == nil {
 			ms = &MetricSeries{}
 			if isHistogram {
-				ms.HistValue = CreateHistogramMetricValue(metricCfg.Buckets)
+				ms.HistValue = createMetricHistogramValue(metricCfg)
+			}
+			if withSketch {
+				ms.Sketch = collectors.CreateQuantileSketch(metricCfg.QuantileRelativeAccuracy)
 			}
 			seriesMap[olv] = ms
 		}
@@ -83,6 +88,9 @@ func (e *Evaluator) evaluateDurationMetric(data [][]string, metric string, metri
 		if isHistogram {
 			ms.HistValue.Observe(duration)
 		}
+		if withSketch {
+			ms.Sketch.Add(duration)
+		}
 	}
 	if nans != 0 || infs != 0 {
 		log.Warnf("While evaluating duration metric %v some intCalls were skipped : %v nans, %v infs", metric, nans, infs)
@@ -236,24 +244,12 @@ func (e *Evaluator) evaluateDurationIntCalls(data [][]string, metric string, que
 	log.Debugf("For metric %v got labelIndexes = %+v; heading = %v", metric, labelIndexes, heading)
 
 	for i := 1; i < dataSize; i++ {
-		var unixTime int64
-		var err error
-		if timeFormat == "" {
-			unixTime, err = strconv.ParseInt(data[i][timeIndex], 10, 64)
-			if err != nil {
-				log.Debugf("For metric %v : Error parsing value %v : %+v", metric, data[i][timeIndex], err)
-				continue
-			}
-		} else {
-			timestamp, err := time.Parse(timeFormat, data[i][timeIndex])
-			if err != nil {
-				log.Debugf("For metric %v : Error parsing value %v with format %v: %+v", metric, data[i][timeIndex], timeFormat, err)
-				continue
-			} else {
-				unixTime = timestamp.UnixNano() / 1000000
-				log.Tracef("For metric %v : Value %v parsed successfully to %v with format %v", metric, data[i][timeIndex], unixTime, timeFormat)
-			}
+		unixTime, err := parseUnixTime(data[i][timeIndex], timeFormat)
+		if err != nil {
+			log.Debugf("For metric %v : Error parsing value %v with format %v: %+v", metric, data[i][timeIndex], timeFormat, err)
+			continue
 		}
+		log.Tracef("For metric %v : Value %v parsed successfully to %v with format %v", metric, data[i][timeIndex], unixTime, timeFormat)
 		messageType := data[i][messageTypeIndex]
 		correlationId := data[i][correlationIdIndex]
 		switch messageType {
@@ -318,6 +314,18 @@ func (e *Evaluator) evaluateDurationIntCalls(data [][]string, metric string, que
 	return intCalls, nil
 }
 
+// parseUnixTime parses the time in milliseconds, if the format is empty, the value must be the number of milliseconds
+func parseUnixTime(value string, timeFormat string) (int64, error) {
+	if timeFormat == "" {
+		return strconv.ParseInt(value, 10, 64)
+	}
+	timestamp, err := time.Parse(timeFormat, value)
+	if err != nil {
+		return 0, err
+	}
+	return timestamp.UnixMilli(), nil
+}
+
 func selfMonitorUpdateCacheSize(qName string, cacheName string, value float64) {
 	labels := make(map[string]string)
 	labels["query_name"] = qName
@@ -15,7 +15,9 @@
 package evaluator
 
 import (
+	"log_exporter/internal/collectors"
 	"log_exporter/internal/config"
+	"log_exporter/internal/evaluator/conditions"
 	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
 	"math"
@@ -60,6 +62,10 @@ func (e *Evaluator) evaluateValueMetric(data [][]string, metric string, metricCf
 		} else {
 			ms.Average = math.NaN()
 		}
+		if ms.AggValue != nil {
+			ms.Average = ms.AggValue.Get(metricCfg.Parameters["aggregation"])
+			ms.Sum = ms.Average
+		}
 		result.Series = append(result.Series, *ms)
 	}
 
@@ -92,23 +98,32 @@ func (e *Evaluator) evaluateMetricSeriesMapByOLV(data [][]string, metric string,
 		return make(map[string]*MetricSeries)
 	}
 
-	var meCondition *MECondition
+	timeIndex := -1
+	if timeField := metricCfg.Parameters["time_field"]; timeField != "" && isOrderedAggregation(metricCfg) {
+		timeIndex = utils.FindStringIndexInArray(heading, timeField)
+		if timeIndex == -1 {
+			log.WithField(ec.FIELD, ec.LME_1020).Errorf("Can not evaluate value metric %v : time field %v not found in the output", metric, timeField)
+			return make(map[string]*MetricSeries)
+		}
+	}
+
+	var meCondition *conditions.MECondition
 	if metricCfg.Cond != nil {
-		meCondition = CreateMECondition(metric, metricCfg.Cond, heading)
+		meCondition = conditions.CreateMECondition(metric, metricCfg.Cond, heading)
 	}
 	threadsNumber := metricCfg.Threads
 	if threadsNumber > dataSize-1 {
 		threadsNumber = dataSize - 1
 	}
 	if threadsNumber <= 1 {
-		return e.evaluateMetricSeriesMapByOLVTask(data, metric, metricCfg, labelIndexes, valueIndex, meCondition, 1, len(data))
+		return e.evaluateMetricSeriesMapByOLVTask(data, metric, metricCfg, labelIndexes, valueIndex, timeIndex, meCondition, 1, len(data))
 	}
 	msChan := make(chan map[string]*MetricSeries)
 	for i := 0; i < threadsNumber; i++ {
 		start := 1 + i*(dataSize-1)/threadsNumber
 		end := 1 + (i+1)*(dataSize-1)/threadsNumber
 		go func() {
-			msm := e.evaluateMetricSeriesMapByOLVTask(data, metric, metricCfg, labelIndexes, valueIndex, meCondition, start, end)
+			msm := e.evaluateMetricSeriesMapByOLVTask(data, metric, metricCfg, labelIndexes, valueIndex, timeIndex, meCondition, start, end)
 			msChan <- msm
 		}()
 	}
@@ -135,13 +150,21 @@ func (e *Evaluator) evaluateMetricSeriesMapByOLV(data [][]string, metric string,
 					if resultMetricSeries.HistValue == nil {
 						resultMetricSeries.HistValue = metricSeries.HistValue
 					} else {
-						resHistValue := resultMetricSeries.HistValue
-						msHistBalue := metricSeries.HistValue
-						resHistValue.Sum += msHistBalue.Sum
-						resHistValue.Cnt += msHistBalue.Cnt
-						for k, v := range msHistBalue.Buckets {
-							resHistValue.Buckets[k] += v
-						}
+						resultMetricSeries.HistValue.Merge(metricSeries.HistValue)
+					}
+				}
+				if metricSeries.AggValue != nil {
+					if resultMetricSeries.AggValue == nil {
+						resultMetricSeries.AggValue = metricSeries.AggValue
+					} else {
+						resultMetricSeries.AggValue.Merge(metricSeries.AggValue)
+					}
+				}
+				if metricSeries.Sketch != nil {
+					if resultMetricSeries.Sketch == nil {
+						resultMetricSeries.Sketch = metricSeries.Sketch
+					} else {
+						resultMetricSeries.Sketch.Merge(metricSeries.Sketch)
 					}
 				}
 			}
@@ -151,10 +174,13 @@ func (e *Evaluator) evaluateMetricSeriesMapByOLV(data [][]string, metric string,
 	return result
 }
 
-func (e *Evaluator) evaluateMetricSeriesMapByOLVTask(data [][]string, metric string, metricCfg *config.MetricsConfig, labelIndexes []int, valueIndex int, meCondition *MECondition, start int, end int) map[string]*MetricSeries {
+func (e *Evaluator) evaluateMetricSeriesMapByOLVTask(data [][]string, metric string, metricCfg *config.MetricsConfig, labelIndexes []int, valueIndex int, timeIndex int, meCondition *conditions.MECondition, start int, end int) map[string]*MetricSeries {
 	log.Debugf("evaluateMetricSeriesMapByOLVTask %v; start = %v, end = %v", metric, start, end)
 	result := make(map[string]*MetricSeries)
 	isHistogram := (metricCfg.Type == "histogram")
+	withSketch := (metricCfg.Operation == "quantile" || metricCfg.Type == "summary")
+	withAggregation := isAggregation(metricCfg)
+	timeFormat := metricCfg.Parameters["time_format"]
 
 	if start >= end {
 		log.Debugf("start >= end for metric %v; start = %v, end = %v", metric, start, end)
@@ -183,20 +209,42 @@ func (e *Evaluator) evaluateMetricSeriesMapByOLVTask(data [][]string, metric str
 			infs++
 			continue
 		}
+		var unixTime int64
+		if timeIndex >= 0 {
+			unixTime, err = parseUnixTime(data[i][timeIndex], timeFormat)
+			if err != nil {
+				log.Debugf("Error parsing time %v for metric %v : %+v", data[i][timeIndex], metric, err)
+				parsingErrors++
+				continue
+			}
+		}
 
 		ms := result[olv]
 		if ms == nil {
 			ms = &MetricSeries{}
 			if isHistogram {
-				ms.HistValue = CreateHistogramMetricValue(metricCfg.Buckets)
+				ms.HistValue = createMetricHistogramValue(metricCfg)
+			}
+			if withSketch {
+				ms.Sketch = collectors.CreateQuantileSketch(metricCfg.QuantileRelativeAccuracy)
 			}
 			result[olv] = ms
 		}
 		ms.Sum += val
 		ms.Count++
+		if withAggregation {
+			if ms.AggValue == nil {
+				ms.AggValue = CreateAggregatedMetricValue(val, unixTime, i)
+			} else {
+				ms.AggValue.Observe(val, unixTime, i)
+			}
+		}
 		if isHistogram {
 			ms.HistValue.Observe(val)
 		}
+		if withSketch {
+			ms.Sketch.Add(val)
+		}
 	}
 
 	if parsingErrors != 0 || nans != 0 || infs != 0 {
@@ -205,3 +253,21 @@ func (e *Evaluator) evaluateMetricSeriesMapByOLVTask(data [][]string, metric str
 
 	return result
 }
+
+// isAggregation returns true if the value metric is exported as the min, max, first or last value instead of the average.
+// The aggregation is not used for the counters, which are increased by the sum of the values
+func isAggregation(metricCfg *config.MetricsConfig) bool {
+	if metricCfg.Operation != "value" || metricCfg.Type != "gauge" {
+		return false
+	}
+	switch metricCfg.Parameters["aggregation"] {
+	case "min", "max", "first", "last":
+		return true
+	}
+	return false
+}
+
+func isOrderedAggregation(metricCfg *config.MetricsConfig) bool {
+	aggregation := metricCfg.Parameters["aggregation"]
+	return isAggregation(metricCfg) && (aggregation == "first" || aggregation == "last")
+}
@@ -16,6 +16,7 @@ package evaluator
 
 import (
 	"fmt"
+	"log_exporter/internal/collectors"
 	"log_exporter/internal/config"
 	"log_exporter/internal/selfmonitor"
 	"log_exporter/internal/utils"
@@ -32,6 +33,7 @@ type Evaluator struct {
 	rtcRepo                 *RequestTimeCacheRepozitory
 	nrcRepo                 *NoResponseCacheRepozitory
 	idfcRepo                *IdFieldCacheRepozitory
+	dwRepo                  *DistinctWindowRepozitory
 	metricDefaultValuesRepo *MetricDefaultValuesRepository
 }
 
@@ -58,6 +60,7 @@ func CreateEvaluator(appConfig *config.Config) *Evaluator {
 	e.rtcRepo = CreateRequestTimeCacheRepozitory(appConfig)
 	e.nrcRepo = CreateNoResponseCacheRepo(appConfig)
 	e.idfcRepo = CreateIdFieldCacheRepo(appConfig)
+	e.dwRepo = CreateDistinctWindowRepo(appConfig)
 	e.metricDefaultValuesRepo = CreateMetricDefaultValuesRepository(appConfig.Metrics)
 	return &e
 }
@@ -81,6 +84,14 @@ func (e *Evaluator) EvaluateMetric(data [][]string, metric string, metricCfg *co
 		result = e.evaluateDurationMetric(data, metric, metricCfg, query)
 	case "value":
 		result = e.evaluateValueMetric(data, metric, metricCfg)
+	case "quantile":
+		if metricCfg.MetricValue != "" || metricCfg.Parameters["value-field"] != "" {
+			result = e.evaluateValueMetric(data, metric, metricCfg)
+		} else {
+			result = e.evaluateDurationMetric(data, metric, metricCfg, query)
+		}
+	case "count-distinct":
+		result = e.evaluateCountDistinctMetric(data, metric, metricCfg)
 	case "duration-no-response":
 		log.WithField(ec.FIELD, ec.LME_8102).Errorf("Metric %v has duration-no-response operation, which can be evaluated only as a child of the other duration metric", metric)
 		return nil
@@ -143,7 +154,15 @@ func appendZeroValuesForNotUpdatedCounters(result *MetricEvaluationResult, metri
 		for _, key := range metricStateKeys {
 			if metricSeriesMap[key] == nil {
 				ms := CreateMetricSeries(metricState.Get(key))
-				ms.HistValue = CreateHistogramMetricValue(metricCfg.Buckets)
+				ms.HistValue = createMetricHistogramValue(metricCfg)
+				result.Series = append(result.Series, ms)
+			}
+		}
+	case "summary":
+		for _, key := range metricStateKeys {
+			if metricSeriesMap[key] == nil {
+				ms := CreateMetricSeries(metricState.Get(key))
+				ms.Sketch = collectors.CreateQuantileSketch(metricCfg.QuantileRelativeAccuracy)
 				result.Series = append(result.Series, ms)
 			}
 		}
@@ -154,7 +173,7 @@ func appendZeroValuesForNotUpdatedCounters(result *MetricEvaluationResult, metri
 func logMetricEvaluationResult(metric string, result *MetricEvaluationResult) {
 	log.Debugf("For metric %v result is evaluated (len = %v)", metric, len(result.Series))
 	for i, ms := range result.Series {
-		log.Tracef("%d : %+v : sum = %v, cnt = %v, avg = %v , hist = %+v, timestamp = %+v", i, ms.Labels, ms.Sum, ms.Count, ms.Average, ms.HistValue, ms.Timestamp)
+		log.Tracef("%d : %+v : sum = %v, cnt = %v, avg = %v , hist = %+v, sketch = %+v, timestamp = %+v", i, ms.Labels, ms.Sum, ms.Count, ms.Average, ms.HistValue, ms.Sketch, ms.Timestamp)
 	}
 }
 
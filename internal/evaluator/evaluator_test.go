@@ -16,17 +16,21 @@ package evaluator
 
 import (
 	"fmt"
+	"log_exporter/internal/collectors"
 	"log_exporter/internal/config"
 	"log_exporter/internal/evaluator/enrichers"
 	"log_exporter/internal/httpservice"
 	"log_exporter/internal/queues"
 	"log_exporter/internal/registry"
 	"log_exporter/internal/selfmonitor"
+	"math"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -258,3 +262,285 @@ func getMetricAndIndex(testName string) (metricName string, gdIndex int64, err e
 	}
 	return
 }
+
+func TestEvaluateMetric_Quantile(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	data := [][]string{{"path", "duration"}}
+	for i := 1000; i >= 1; i-- {
+		data = append(data, []string{"/api", strconv.Itoa(i)})
+	}
+	data = append(data, []string{"/health", "-7"}, []string{"/health", "0"}, []string{"/health", "not a number"})
+	appConfig := &config.Config{
+		Metrics: map[string]*config.MetricsConfig{
+			"single": {Type: "gauge", Operation: "quantile", Labels: []string{"path"}, MetricValue: "duration"},
+			"threaded": {Type: "gauge", Operation: "quantile", Labels: []string{"path"}, MetricValue: "duration", Threads: 7,
+				QuantileRelativeAccuracy: 0.01},
+		},
+	}
+	e := CreateEvaluator(appConfig)
+	endTime := time.Now()
+	results := make(map[string]map[string]*collectors.QuantileSketch)
+	for metric, metricCfg := range appConfig.Metrics {
+		mer := e.EvaluateMetric(data, metric, metricCfg, "test_query", &endTime)
+		results[metric] = make(map[string]*collectors.QuantileSketch)
+		for _, ms := range mer.Series {
+			if ms.Sketch == nil {
+				t.Fatalf("Expected sketch for metric %v, labels %v", metric, ms.Labels)
+			}
+			results[metric][ms.Labels["path"]] = ms.Sketch
+		}
+	}
+
+	for _, metric := range []string{"single", "threaded"} {
+		sketch := results[metric]["/api"]
+		if sketch == nil || sketch.Count != 1000 || sketch.Sum != 500500 {
+			t.Fatalf("Unexpected sketch for metric %v : %+v", metric, sketch)
+		}
+		for q, expected := range map[float64]float64{0: 1, 0.5: 500, 0.95: 950, 0.99: 990, 1: 1000} {
+			if value := sketch.Quantile(q); math.Abs(value-expected) > expected*0.01+1 {
+				t.Errorf("For metric %v expected quantile %v to be about %v, got: %v", metric, q, expected, value)
+			}
+		}
+		health := results[metric]["/health"]
+		if health == nil || math.Abs(health.Quantile(0.5)+7) > 0.07 || health.Quantile(1) != 0 {
+			t.Errorf("For metric %v expected negative and zero values to be kept, got: %+v", metric, health)
+		}
+	}
+	if single, threaded := results["single"]["/api"], results["threaded"]["/api"]; single.Quantile(0.95) != threaded.Quantile(0.95) {
+		t.Errorf("Expected the merged sketches of threads to give the same quantile as one sketch : %v != %v", threaded.Quantile(0.95), single.Quantile(0.95))
+	}
+}
+
+func TestEvaluateMetric_NativeHistogram(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	values := []float64{-3, 0, 1e-40, 1, 2, 3, 4, 0.001, 1024, 1025}
+	for i := 1; i <= 1000; i++ {
+		values = append(values, float64(i)*1.37, -float64(i)/7)
+	}
+	data := [][]string{{"path", "value"}}
+	clientHistogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "expected", NativeHistogramBucketFactor: 1.1})
+	for _, value := range values {
+		data = append(data, []string{"/api", strconv.FormatFloat(value, 'g', -1, 64)})
+		clientHistogram.Observe(value)
+	}
+	var expected dto.Metric
+	if err := clientHistogram.Write(&expected); err != nil {
+		t.Fatalf("Failed to write metric: %v", err)
+	}
+	nativeCfg := &config.NativeHistogramConfig{SchemaParsed: expected.Histogram.GetSchema(), ZeroThresholdParsed: expected.Histogram.GetZeroThreshold()}
+	appConfig := &config.Config{
+		Metrics: map[string]*config.MetricsConfig{
+			"single":   {Type: "histogram", Operation: "value", Labels: []string{"path"}, MetricValue: "value", NativeHistogram: nativeCfg},
+			"threaded": {Type: "histogram", Operation: "value", Labels: []string{"path"}, MetricValue: "value", NativeHistogram: nativeCfg, Threads: 7},
+		},
+	}
+	spansToBuckets := func(spans []*dto.BucketSpan, deltas []int64) map[int]int64 {
+		buckets := make(map[int]int64)
+		key, count, deltaIndex := 0, int64(0), 0
+		for _, span := range spans {
+			key += int(span.GetOffset())
+			for i := uint32(0); i < span.GetLength(); i++ {
+				count += deltas[deltaIndex]
+				if count != 0 {
+					buckets[key] = count
+				}
+				key++
+				deltaIndex++
+			}
+		}
+		return buckets
+	}
+	expectedPositive := spansToBuckets(expected.Histogram.PositiveSpan, expected.Histogram.PositiveDelta)
+	expectedNegative := spansToBuckets(expected.Histogram.NegativeSpan, expected.Histogram.NegativeDelta)
+
+	e := CreateEvaluator(appConfig)
+	endTime := time.Now()
+	for metric, metricCfg := range appConfig.Metrics {
+		mer := e.EvaluateMetric(data, metric, metricCfg, "test_query", &endTime)
+		if len(mer.Series) != 1 || mer.Series[0].HistValue == nil || !mer.Series[0].HistValue.Native {
+			t.Fatalf("Expected one native histogram series for metric %v, got: %+v", metric, mer.Series)
+		}
+		histValue := mer.Series[0].HistValue
+		if histValue.Cnt != expected.Histogram.GetSampleCount() || histValue.ZeroCount != expected.Histogram.GetZeroCount() {
+			t.Errorf("For metric %v expected count %v and zero count %v, got: %v and %v", metric, expected.Histogram.GetSampleCount(), expected.Histogram.GetZeroCount(), histValue.Cnt, histValue.ZeroCount)
+		}
+		if math.Abs(histValue.Sum-expected.Histogram.GetSampleSum()) > 1e-6 {
+			t.Errorf("For metric %v expected sum %v, got: %v", metric, expected.Histogram.GetSampleSum(), histValue.Sum)
+		}
+		for name, pair := range map[string][2]map[int]int64{"positive": {expectedPositive, histValue.PositiveBuckets}, "negative": {expectedNegative, histValue.NegativeBuckets}} {
+			if len(pair[0]) != len(pair[1]) {
+				t.Errorf("For metric %v expected %v %v buckets, got: %v", metric, len(pair[0]), name, len(pair[1]))
+			}
+			for key, count := range pair[0] {
+				if pair[1][key] != count {
+					t.Errorf("For metric %v expected %v bucket %v to have count %v, got: %v", metric, name, key, count, pair[1][key])
+				}
+			}
+		}
+	}
+}
+
+func TestEvaluateMetric_NativeHistogramBucketLimit(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	data := [][]string{{"path", "value"}}
+	clientHistogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "expected", NativeHistogramBucketFactor: 1.011,
+		NativeHistogramMaxBucketNumber: collectors.NATIVE_HISTOGRAM_MAX_BUCKETS})
+	for i := 1; i <= 5000; i++ {
+		value := math.Pow(1.003, float64(i))
+		if i%3 == 0 {
+			value = -value
+		}
+		data = append(data, []string{"/api", strconv.FormatFloat(value, 'g', -1, 64)})
+		clientHistogram.Observe(value)
+	}
+	var expected dto.Metric
+	if err := clientHistogram.Write(&expected); err != nil {
+		t.Fatalf("Failed to write metric: %v", err)
+	}
+	// the schema of the client is reduced from 6 by the bucket limit
+	nativeCfg := &config.NativeHistogramConfig{SchemaParsed: 6, ZeroThresholdParsed: expected.Histogram.GetZeroThreshold()}
+	appConfig := &config.Config{
+		Metrics: map[string]*config.MetricsConfig{
+			"single":   {Type: "histogram", Operation: "value", Labels: []string{"path"}, MetricValue: "value", NativeHistogram: nativeCfg},
+			"threaded": {Type: "histogram", Operation: "value", Labels: []string{"path"}, MetricValue: "value", NativeHistogram: nativeCfg, Threads: 7},
+		},
+	}
+	var expectedBuckets uint32
+	for _, span := range append(expected.Histogram.PositiveSpan, expected.Histogram.NegativeSpan...) {
+		expectedBuckets += span.GetLength()
+	}
+
+	e := CreateEvaluator(appConfig)
+	endTime := time.Now()
+	for metric, metricCfg := range appConfig.Metrics {
+		mer := e.EvaluateMetric(data, metric, metricCfg, "test_query", &endTime)
+		if len(mer.Series) != 1 || mer.Series[0].HistValue == nil {
+			t.Fatalf("Expected one histogram series for metric %v, got: %+v", metric, mer.Series)
+		}
+		histValue := mer.Series[0].HistValue
+		if histValue.Schema != expected.Histogram.GetSchema() || histValue.Schema >= 6 {
+			t.Errorf("For metric %v expected reduced schema %v, got: %v", metric, expected.Histogram.GetSchema(), histValue.Schema)
+		}
+		if buckets := len(histValue.PositiveBuckets) + len(histValue.NegativeBuckets); buckets > collectors.NATIVE_HISTOGRAM_MAX_BUCKETS || uint32(buckets) != expectedBuckets {
+			t.Errorf("For metric %v expected %v buckets, got: %v", metric, expectedBuckets, buckets)
+		}
+		if histValue.Cnt != expected.Histogram.GetSampleCount() {
+			t.Errorf("For metric %v expected count %v, got: %v", metric, expected.Histogram.GetSampleCount(), histValue.Cnt)
+		}
+	}
+}
+
+func TestEvaluateMetric_Aggregations(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	data := [][]string{{"path", "value", "time"}}
+	for i := 0; i < 100; i++ {
+		// the time goes down with the rows, the values at the same time are ordered by the rows
+		data = append(data, []string{"/api", strconv.Itoa(i%37 - 10), strconv.Itoa(1000 - i/2)})
+	}
+	data = append(data, []string{"/health", "5", "not a time"}, []string{"/health", "7", "2000"})
+	expected := map[string]float64{"min": -10, "max": 26, "first": 98%37 - 10, "last": 1 - 10, "rows-first": -10, "rows-last": 99%37 - 10}
+	metrics := make(map[string]*config.MetricsConfig)
+	for _, threads := range []int{1, 7} {
+		for name := range expected {
+			aggregation, byRows := strings.CutPrefix(name, "rows-")
+			parameters := map[string]string{"aggregation": aggregation}
+			if !byRows {
+				parameters["time_field"] = "time"
+			}
+			metrics[fmt.Sprintf("%v#%v", name, threads)] = &config.MetricsConfig{Type: "gauge", Operation: "value", Labels: []string{"path"}, MetricValue: "value", Parameters: parameters, Threads: threads}
+		}
+		metrics[fmt.Sprintf("counter#%v", threads)] = &config.MetricsConfig{Type: "counter", Operation: "value", Labels: []string{"path"}, MetricValue: "value",
+			Parameters: map[string]string{"aggregation": "max"}, Threads: threads}
+	}
+	// the aggregation is ignored for the counters, they are increased by the sum of the values
+	var expectedCounterSum float64
+	for i := 0; i < 100; i++ {
+		expectedCounterSum += float64(i%37 - 10)
+	}
+	appConfig := &config.Config{Metrics: metrics}
+	e := CreateEvaluator(appConfig)
+	endTime := time.Now()
+	for metric, metricCfg := range appConfig.Metrics {
+		mer := e.EvaluateMetric(data, metric, metricCfg, "test_query", &endTime)
+		if metricCfg.Type == "counter" {
+			for _, ms := range mer.Series {
+				if ms.Labels["path"] == "/api" && ms.Sum != expectedCounterSum {
+					t.Errorf("For metric %v expected the sum %v, got: %v", metric, expectedCounterSum, ms.Sum)
+				}
+			}
+			continue
+		}
+		values := make(map[string]float64)
+		for _, ms := range mer.Series {
+			values[ms.Labels["path"]] = ms.Average
+			if ms.Sum != ms.Average {
+				t.Errorf("For metric %v expected sum to be equal to the aggregated value %v, got: %v", metric, ms.Average, ms.Sum)
+			}
+		}
+		name, _, _ := strings.Cut(metric, "#")
+		if values["/api"] != expected[name] {
+			t.Errorf("For metric %v expected %v, got: %v", metric, expected[name], values["/api"])
+		}
+		if strings.HasPrefix(name, "rows-") && values["/health"] != map[string]float64{"rows-first": 5, "rows-last": 7}[name] {
+			t.Errorf("For metric %v expected the rows order for /health, got: %v", metric, values["/health"])
+		} else if !strings.HasPrefix(name, "rows-") && name != "min" && name != "max" && values["/health"] != 7 {
+			t.Errorf("For metric %v expected the row with not parsed time to be skipped, got: %v", metric, values["/health"])
+		}
+	}
+}
+
+func TestEvaluateMetric_CountDistinct(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	interval := func(from int, to int) [][]string {
+		data := [][]string{{"path", "user"}}
+		for i := from; i < to; i++ {
+			// every user is repeated, /health has few users to check the small numbers
+			data = append(data, []string{"/api", fmt.Sprintf("user-%v", i)}, []string{"/api", fmt.Sprintf("user-%v", i)})
+			data = append(data, []string{"/health", fmt.Sprintf("probe-%v", i%3)}, []string{"/health", ""})
+		}
+		return data
+	}
+	appConfig := &config.Config{
+		Metrics: map[string]*config.MetricsConfig{
+			"single":   {Type: "gauge", Operation: "count-distinct", Labels: []string{"path"}, MetricValue: "user", DistinctPrecision: 14, DistinctWindowIntervals: 1},
+			"threaded": {Type: "gauge", Operation: "count-distinct", Labels: []string{"path"}, MetricValue: "user", DistinctPrecision: 14, DistinctWindowIntervals: 1, Threads: 7},
+			"window":   {Type: "gauge", Operation: "count-distinct", Labels: []string{"path"}, MetricValue: "user", DistinctPrecision: 14, DistinctWindowIntervals: 2, Threads: 3},
+		},
+	}
+	e := CreateEvaluator(appConfig)
+	endTime := time.Now()
+	evaluate := func(metric string, data [][]string) map[string]float64 {
+		values := make(map[string]float64)
+		for _, ms := range e.EvaluateMetric(data, metric, appConfig.Metrics[metric], "test_query", &endTime).Series {
+			values[ms.Labels["path"]] = ms.Average
+		}
+		return values
+	}
+
+	single, threaded := evaluate("single", interval(0, 20000)), evaluate("threaded", interval(0, 20000))
+	if math.Abs(single["/api"]-20000) > 20000*0.03 {
+		t.Errorf("Expected about 20000 distinct values, got: %v", single["/api"])
+	}
+	if single["/api"] != threaded["/api"] {
+		t.Errorf("Expected the merged sketches of threads to give the same estimate as one sketch : %v != %v", threaded["/api"], single["/api"])
+	}
+	if single["/health"] != 3 || threaded["/health"] != 3 {
+		t.Errorf("Expected exactly 3 distinct values for the small number, got: %v and %v", single["/health"], threaded["/health"])
+	}
+
+	// the window of 2 intervals: users 0..9999, then 5000..14999 (15000 distinct), then 20000..29999 (20000 distinct)
+	for i, expected := range []float64{10000, 15000, 20000} {
+		from := []int{0, 5000, 20000}[i]
+		values := evaluate("window", interval(from, from+10000))
+		if math.Abs(values["/api"]-expected) > expected*0.03 {
+			t.Errorf("For interval %v expected about %v distinct values for the window, got: %v", i, expected, values["/api"])
+		}
+	}
+	values := evaluate("window", [][]string{{"path", "user"}})
+	if math.Abs(values["/api"]-10000) > 10000*0.03 {
+		t.Errorf("Expected about 10000 distinct values of the last interval after the empty one, got: %v", values["/api"])
+	}
+	if values = evaluate("window", [][]string{{"path", "user"}}); !math.IsNaN(values["/api"]) {
+		t.Errorf("Expected NaN after the window without records, got: %v", values["/api"])
+	}
+}
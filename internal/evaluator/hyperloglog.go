@@ -0,0 +1,97 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evaluator
+
+import (
+	"hash/maphash"
+	"log_exporter/internal/config"
+	"math"
+	"math/bits"
+)
+
+// hllSeed is shared by all sketches of the process, so the sketches of the threads and of the intervals can be merged
+var hllSeed = maphash.MakeSeed()
+
+// HyperLogLog estimates the number of the distinct values with the standard error about 1.04 / sqrt(2^precision).
+// The sketch takes 2^precision bytes regardless of the number of the values, the sketches with the same precision
+// are merged without the loss of accuracy
+type HyperLogLog struct {
+	Precision uint8
+	registers []uint8
+}
+
+func CreateHyperLogLog(precision int) *HyperLogLog {
+	precision = min(max(precision, config.COUNT_DISTINCT_PRECISION_MIN), config.COUNT_DISTINCT_PRECISION_MAX)
+	return &HyperLogLog{
+		Precision: uint8(precision),
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (h *HyperLogLog) Add(value string) {
+	hash := maphash.String(hllSeed, value)
+	index := hash >> (64 - h.Precision)
+	// the guard bit limits the rank if all bits after the index are zero
+	rank := uint8(bits.LeadingZeros64(hash<<h.Precision|1<<(h.Precision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Merge adds the values of the other sketch, the sketches must have the same precision
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	return &HyperLogLog{
+		Precision: h.Precision,
+		registers: append([]uint8(nil), h.registers...),
+	}
+}
+
+// Estimate returns the estimated number of the distinct values, the linear counting is used for the small numbers
+func (h *HyperLogLog) Estimate() float64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := hllAlpha(len(h.registers)) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		return math.Round(m * math.Log(m/float64(zeros)))
+	}
+	return math.Round(estimate)
+}
+
+func hllAlpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
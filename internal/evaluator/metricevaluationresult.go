@@ -15,6 +15,9 @@
 package evaluator
 
 import (
+	"log_exporter/internal/collectors"
+	"log_exporter/internal/config"
+	"math"
 	"sort"
 	"time"
 )
@@ -37,6 +40,8 @@ type MetricSeries struct {
 	Count     uint64
 	Timestamp *time.Time
 	HistValue *HistogramMetricValue
+	Sketch    *collectors.QuantileSketch
+	AggValue  *AggregatedMetricValue
 }
 
 func CreateMetricSeries(labels map[string]string) MetricSeries {
@@ -45,11 +50,79 @@ func CreateMetricSeries(labels map[string]string) MetricSeries {
 	return ms
 }
 
+// AggregatedMetricValue keeps the min, max, first and last values of the series. The first and the last values are
+// ordered by the time and then by the number of the row, so the result of the threads merge does not depend on their order
+type AggregatedMetricValue struct {
+	Min       float64
+	Max       float64
+	First     float64
+	FirstTime int64
+	FirstRow  int
+	Last      float64
+	LastTime  int64
+	LastRow   int
+}
+
+func CreateAggregatedMetricValue(value float64, unixTime int64, row int) *AggregatedMetricValue {
+	return &AggregatedMetricValue{
+		Min:       value,
+		Max:       value,
+		First:     value,
+		FirstTime: unixTime,
+		FirstRow:  row,
+		Last:      value,
+		LastTime:  unixTime,
+		LastRow:   row,
+	}
+}
+
+func (a *AggregatedMetricValue) Observe(value float64, unixTime int64, row int) {
+	a.Min = math.Min(a.Min, value)
+	a.Max = math.Max(a.Max, value)
+	if unixTime < a.FirstTime || unixTime == a.FirstTime && row < a.FirstRow {
+		a.First, a.FirstTime, a.FirstRow = value, unixTime, row
+	}
+	if unixTime > a.LastTime || unixTime == a.LastTime && row > a.LastRow {
+		a.Last, a.LastTime, a.LastRow = value, unixTime, row
+	}
+}
+
+func (a *AggregatedMetricValue) Merge(other *AggregatedMetricValue) {
+	a.Observe(other.First, other.FirstTime, other.FirstRow)
+	a.Observe(other.Last, other.LastTime, other.LastRow)
+	a.Min = math.Min(a.Min, other.Min)
+	a.Max = math.Max(a.Max, other.Max)
+}
+
+// Get returns the value of the aggregation (min, max, first or last)
+func (a *AggregatedMetricValue) Get(aggregation string) float64 {
+	switch aggregation {
+	case "min":
+		return a.Min
+	case "max":
+		return a.Max
+	case "first":
+		return a.First
+	case "last":
+		return a.Last
+	}
+	return math.NaN()
+}
+
 type HistogramMetricValue struct {
 	Sum         float64
 	Cnt         uint64
 	Buckets     map[float64]uint64
 	BucketsList []float64
+	// Native histogram with the exponential buckets: the bucket with the key k of the schema s has
+	// the upper bound 2^(k*2^-s), the observations with the absolute value up to ZeroThreshold are put to the zero bucket.
+	// The schema is reduced if there are more than collectors.NATIVE_HISTOGRAM_MAX_BUCKETS buckets
+	Native          bool
+	Schema          int32
+	ZeroThreshold   float64
+	ZeroCount       uint64
+	PositiveBuckets map[int]int64
+	NegativeBuckets map[int]int64
 }
 
 func CreateHistogramMetricValue(bucketsList []float64) *HistogramMetricValue {
@@ -65,12 +138,105 @@ func CreateHistogramMetricValue(bucketsList []float64) *HistogramMetricValue {
 	return &histMetricValue
 }
 
+func CreateNativeHistogramMetricValue(schema int32, zeroThreshold float64) *HistogramMetricValue {
+	histMetricValue := HistogramMetricValue{}
+	histMetricValue.Native = true
+	histMetricValue.Schema = schema
+	histMetricValue.ZeroThreshold = zeroThreshold
+	histMetricValue.PositiveBuckets = make(map[int]int64)
+	histMetricValue.NegativeBuckets = make(map[int]int64)
+	return &histMetricValue
+}
+
+// createMetricHistogramValue creates the native or the classic histogram value depending on the metric configuration
+func createMetricHistogramValue(metricCfg *config.MetricsConfig) *HistogramMetricValue {
+	if metricCfg.NativeHistogram != nil {
+		return CreateNativeHistogramMetricValue(metricCfg.NativeHistogram.SchemaParsed, metricCfg.NativeHistogram.ZeroThresholdParsed)
+	}
+	return CreateHistogramMetricValue(metricCfg.Buckets)
+}
+
 func (h *HistogramMetricValue) Observe(value float64) {
 	h.Sum += value
 	h.Cnt++
+	if h.Native {
+		h.observeNative(value)
+		return
+	}
 	for _, v := range h.BucketsList {
 		if value <= v {
 			h.Buckets[v]++
 		}
 	}
 }
+
+func (h *HistogramMetricValue) observeNative(value float64) {
+	switch {
+	case math.IsNaN(value):
+		return
+	case value > h.ZeroThreshold:
+		h.PositiveBuckets[NativeHistogramBucketKey(value, h.Schema)]++
+	case value < -h.ZeroThreshold:
+		h.NegativeBuckets[NativeHistogramBucketKey(-value, h.Schema)]++
+	default:
+		h.ZeroCount++
+		return
+	}
+	if len(h.PositiveBuckets)+len(h.NegativeBuckets) > collectors.NATIVE_HISTOGRAM_MAX_BUCKETS {
+		h.Schema, h.PositiveBuckets, h.NegativeBuckets = collectors.LimitNativeBuckets(h.Schema, h.PositiveBuckets, h.NegativeBuckets)
+	}
+}
+
+// Merge adds the observations of the other histogram value, both values must have the same buckets.
+// The native histogram values are merged in the lower of their schemas
+func (h *HistogramMetricValue) Merge(other *HistogramMetricValue) {
+	h.Sum += other.Sum
+	h.Cnt += other.Cnt
+	for k, v := range other.Buckets {
+		h.Buckets[k] += v
+	}
+	if !h.Native {
+		return
+	}
+	schema := min(h.Schema, other.Schema)
+	h.PositiveBuckets = collectors.ReduceNativeSchema(h.PositiveBuckets, h.Schema, schema)
+	h.NegativeBuckets = collectors.ReduceNativeSchema(h.NegativeBuckets, h.Schema, schema)
+	h.Schema = schema
+	h.ZeroCount += other.ZeroCount
+	for k, v := range collectors.ReduceNativeSchema(other.PositiveBuckets, other.Schema, schema) {
+		h.PositiveBuckets[k] += v
+	}
+	for k, v := range collectors.ReduceNativeSchema(other.NegativeBuckets, other.Schema, schema) {
+		h.NegativeBuckets[k] += v
+	}
+	h.Schema, h.PositiveBuckets, h.NegativeBuckets = collectors.LimitNativeBuckets(h.Schema, h.PositiveBuckets, h.NegativeBuckets)
+}
+
+// NativeHistogramBucketKey returns the key of the exponential bucket of the schema for the positive value,
+// the bucket with the key k contains the values from the range (2^((k-1)*2^-schema) ; 2^(k*2^-schema)].
+// The keys are the same as in the native histograms of the Prometheus client
+func NativeHistogramBucketKey(value float64, schema int32) int {
+	isInf := math.IsInf(value, 1)
+	if isInf {
+		value = math.MaxFloat64
+	}
+	frac, exp := math.Frexp(value)
+	var key int
+	if schema > 0 {
+		boundsNumber := 1 << schema
+		key = sort.Search(boundsNumber, func(i int) bool {
+			return math.Exp2(float64(i)/float64(boundsNumber)-1) >= frac
+		}) + (exp-1)*boundsNumber
+	} else {
+		key = exp
+		if frac == 0.5 {
+			key--
+		}
+		offset := (1 << -schema) - 1
+		key = (key + offset) >> -schema
+	}
+	if isInf {
+		key++
+	}
+	return key
+}
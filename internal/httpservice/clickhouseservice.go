@@ -0,0 +1,153 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log_exporter/internal/config"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CLICKHOUSE_OUTPUT_FORMAT = "CSVWithNames"
+	// CLICKHOUSE_PARAM_TIME_LAYOUT is the text format of DateTime64(3), the time is passed in UTC
+	CLICKHOUSE_PARAM_TIME_LAYOUT = "2006-01-02 15:04:05.000"
+)
+
+type ClickHouseService struct {
+	appConfig *config.Config
+	dsConfig  *config.DatasourceConfig
+	transport *datasourceTransport
+}
+
+func CreateClickHouseService(appConfig *config.Config, dsName string) *ClickHouseService {
+	g := ClickHouseService{}
+	g.appConfig = appConfig
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.transport = newDatasourceTransport(appConfig, dsName)
+	return &g
+}
+
+// Capabilities disables splitting, the SQL queries usually aggregate the rows and their results of the sub-ranges can not be merged
+func (g *ClickHouseService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{Retries: true}
+}
+
+func (g *ClickHouseService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	now := time.Now()
+	var err error
+	defer func() {
+		log.Debugf("ClickHouseService : For query %v request executed and csv processed in %+v", qName, time.Since(now))
+		if err != nil && !errors.Is(err, ErrResponseTruncated) {
+			selfMonitorIncErrorCodeCount(qName, now)
+		} else {
+			selfMonitorRefreshErrorCodeCount(qName, now)
+		}
+	}()
+
+	result, responseSize, errc, err := g.queryClickHouse(qName, startTime, endTime)
+	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
+	selfMonitorObserveQueryResponseSize(float64(responseSize), qName, now)
+	if errors.Is(err, ErrResponseTruncated) {
+		selfMonitorIncResponseTruncatedCount(qName, now)
+	} else if err != nil {
+		return make([][]string, 0), errc, err
+	} else {
+		selfMonitorRefreshResponseTruncatedCount(qName, now)
+	}
+
+	return result, errc, err
+}
+
+// queryClickHouse sends the SQL query to the ClickHouse HTTP interface. The query time range is passed as the query parameters
+// param_start and param_end (and param_start_unix, param_end_unix), so the SQL refers to them as {start:DateTime64(3, 'UTC')}
+// instead of having the time concatenated into it. The output format is CSVWithNames unless the query sets its own FORMAT;
+// the csv is parsed while it is being read with the same limits as the Graylog response
+func (g *ClickHouseService) queryClickHouse(qName string, startTime time.Time, endTime time.Time) ([][]string, int64, string, error) {
+	queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, CLICKHOUSE_TEMPLATE_TIME_LAYOUT)
+	if err != nil {
+		return nil, 0, ec.LME_8102, fmt.Errorf("ClickHouseService : For query %v error creating queryString : %+v", qName, err)
+	}
+	log.Debugf("ClickHouseService : For query %v queryString is %v", qName, queryString)
+
+	params := url.Values{}
+	params.Add("default_format", CLICKHOUSE_OUTPUT_FORMAT)
+	params.Add("param_start", startTime.UTC().Format(CLICKHOUSE_PARAM_TIME_LAYOUT))
+	params.Add("param_end", endTime.UTC().Format(CLICKHOUSE_PARAM_TIME_LAYOUT))
+	params.Add("param_start_unix", strconv.FormatInt(startTime.Unix(), 10))
+	params.Add("param_end_unix", strconv.FormatInt(endTime.Unix(), 10))
+
+	client := GetHTTPClient(&g.dsConfig.TLSHostConfig)
+
+	clickHouseEndpoint := strings.Trim(g.dsConfig.Host, " /") + "/"
+	req, err := http.NewRequest("POST", clickHouseEndpoint, strings.NewReader(queryString))
+	if err != nil {
+		return nil, 0, ec.LME_7190, fmt.Errorf("ClickHouseService : For query %v error creating request to %v : %+v", qName, clickHouseEndpoint, err)
+	}
+	if g.dsConfig.User != "" {
+		req.SetBasicAuth(g.dsConfig.User, g.dsConfig.Password)
+	}
+	req.URL.RawQuery = params.Encode()
+	req.Header.Add("Content-Type", "text/plain; charset=utf-8")
+	log.Debugf("ClickHouseService : For query %v request generated : %+v", qName, req.URL.String())
+
+	resp, err := g.transport.do(qName, client, req)
+	if err != nil {
+		return nil, 0, ec.LME_7190, fmt.Errorf("ClickHouseService : For query %v error accessing %v : %w", qName, clickHouseEndpoint, err)
+	}
+
+	if resp.Body != nil {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.Errorf("ClickHouseService : Error closing response body : %+v", err)
+			}
+		}()
+	}
+
+	log.Debugf("ClickHouseService : For query %v received response : %+v", qName, resp)
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 10000))
+		log.WithField(ec.FIELD, ec.LME_7191).Errorf("ClickHouseService : For query %v received response with status code %v from clickhouse, exception code %v, response body (limited) : %s", qName, resp.StatusCode, resp.Header.Get("X-ClickHouse-Exception-Code"), body)
+		return nil, int64(len(body)), ec.LME_7191, statusCodeError("ClickHouseService", qName, resp.StatusCode)
+	}
+	if resp.StatusCode != 200 {
+		log.WithField(ec.FIELD, ec.LME_7191).Errorf("ClickHouseService : For query %v received response with status code %v from clickhouse", qName, resp.StatusCode)
+	}
+
+	body := &sizeLimitedReader{reader: resp.Body, limit: g.dsConfig.MaxResponseSize}
+	result, _, err := processCsvStream(body, qName, g.dsConfig.MaxRecords)
+	log.Infof("ClickHouseService : For query %v to %v response status is %v, body length is %v, records count is %v", qName, clickHouseEndpoint, resp.Status, body.size, len(result))
+	if errors.Is(err, errResponseSizeLimit) {
+		log.WithField(ec.FIELD, ec.LME_7192).Warnf("ClickHouseService : For query %v response is larger than max-response-size %v bytes, only %v records are read", qName, g.dsConfig.MaxResponseSize, len(result))
+		return result, body.size, ec.LME_7192, fmt.Errorf("ClickHouseService : For query %v max-response-size %v is exceeded : %w", qName, g.dsConfig.MaxResponseSize, ErrResponseTruncated)
+	}
+	if errors.Is(err, ErrResponseTruncated) {
+		log.WithField(ec.FIELD, ec.LME_7192).Warnf("ClickHouseService : For query %v response contains more than max-records %v records, only %v records are read", qName, g.dsConfig.MaxRecords, len(result)-1)
+		return result, body.size, ec.LME_7192, fmt.Errorf("ClickHouseService : %w", err)
+	}
+	if err != nil {
+		return nil, body.size, ec.LME_7193, fmt.Errorf("ClickHouseService : For query %v to %v error reading body : %w", qName, clickHouseEndpoint, err)
+	}
+	return result, body.size, "", nil
+}
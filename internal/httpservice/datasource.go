@@ -0,0 +1,149 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"log_exporter/internal/config"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Datasource is the backend the records for the metric evaluation are taken from. Query returns the records
+// of the query time range as a table with the header row, the error code and the error. The truncated result
+// is returned together with the wrapped ErrResponseTruncated
+type Datasource interface {
+	Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error)
+	Capabilities() DatasourceCapabilities
+}
+
+// DatasourceCapabilities describes how the queries of the datasource are executed
+type DatasourceCapabilities struct {
+	// Retries enables the retry policy and the circuit breaker of the datasource for the failed requests
+	Retries bool
+	// TimerangeSplitting enables splitting of the query time range (split-min-timerange), it requires Retries
+	TimerangeSplitting bool
+}
+
+// DatasourceStarter is implemented by the datasources which have to start listeners or background jobs
+// before the queries are executed, for example the push-based datasources
+type DatasourceStarter interface {
+	Start()
+}
+
+// DatasourceCommitter is implemented by the datasources which keep the read position of the queries, for example
+// the file offsets. Commit is called after the result of the query is put to the gd-queue
+type DatasourceCommitter interface {
+	Commit(qName string)
+}
+
+// DatasourceFactory creates the datasource with the name dsName from the configuration
+type DatasourceFactory func(appConfig *config.Config, dsName string) Datasource
+
+var (
+	datasourceFactoriesMutex sync.RWMutex
+	datasourceFactories      = map[string]DatasourceFactory{
+		"graylog": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateGraylogService(appConfig, dsName)
+		},
+		"newrelic": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateNewRelicService(appConfig, dsName)
+		},
+		"loki": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateLokiService(appConfig, dsName)
+		},
+		"opensearch": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateOpenSearchService(appConfig, dsName)
+		},
+		"elasticsearch": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateOpenSearchService(appConfig, dsName)
+		},
+		"victorialogs": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateVictoriaLogsService(appConfig, dsName)
+		},
+		"clickhouse": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateClickHouseService(appConfig, dsName)
+		},
+		"file": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateFileService(appConfig, dsName)
+		},
+		"receiver": func(appConfig *config.Config, dsName string) Datasource {
+			return CreateReceiverService(appConfig, dsName)
+		},
+	}
+)
+
+// RegisterDatasource registers the factory for the datasource type, the type is case-insensitive.
+// The factory registered earlier for the same type is replaced
+func RegisterDatasource(dsType string, factory DatasourceFactory) {
+	datasourceFactoriesMutex.Lock()
+	defer datasourceFactoriesMutex.Unlock()
+	datasourceFactories[strings.ToLower(dsType)] = factory
+}
+
+// UnregisterDatasource removes the factory of the datasource type, for example the factory of the test datasource
+func UnregisterDatasource(dsType string) {
+	datasourceFactoriesMutex.Lock()
+	defer datasourceFactoriesMutex.Unlock()
+	delete(datasourceFactories, strings.ToLower(dsType))
+}
+
+// CreateDatasource creates the datasource with the factory registered for its type.
+// The datasources without type and with an unknown type are created as Graylog datasources
+func CreateDatasource(appConfig *config.Config, dsName string) Datasource {
+	dsType := strings.ToLower(appConfig.Datasources[dsName].Type)
+	if dsType == "" {
+		dsType = config.DATASOURCE_TYPE_DEFAULT
+	}
+	datasourceFactoriesMutex.RLock()
+	factory, ok := datasourceFactories[dsType]
+	if !ok {
+		log.Warnf("Datasource type %v of datasource %v is not supported, datasource type %v is used", dsType, dsName, config.DATASOURCE_TYPE_DEFAULT)
+		factory = datasourceFactories[config.DATASOURCE_TYPE_DEFAULT]
+	}
+	datasourceFactoriesMutex.RUnlock()
+	return factory(appConfig, dsName)
+}
+
+// datasourceTransport sends the HTTP requests of the datasource queries within the limits of the datasource
+// and records the responses. In the replay mode the recorded responses are served instead of sending the requests
+type datasourceTransport struct {
+	limiter  *datasourceLimiter
+	recorder *ResponseRecorder
+	player   *ResponsePlayer
+}
+
+func newDatasourceTransport(appConfig *config.Config, dsName string) *datasourceTransport {
+	dsConfig := appConfig.Datasources[dsName]
+	return &datasourceTransport{
+		limiter:  newDatasourceLimiter(dsName, dsConfig),
+		recorder: GetResponseRecorder(appConfig, dsName),
+		player:   getResponsePlayer(dsConfig),
+	}
+}
+
+func (t *datasourceTransport) do(qName string, client *http.Client, req *http.Request) (*http.Response, error) {
+	if t.player != nil {
+		return t.player.next(qName, req)
+	}
+	resp, err := t.limiter.do(client, req)
+	if err == nil && t.recorder != nil {
+		t.recorder.recordResponse(qName, resp)
+	}
+	return resp, err
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"io"
+	"log_exporter/internal/config"
+	"log_exporter/internal/selfmonitor"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// datasourceLimiter limits the number of concurrent requests and the request rate of the datasource.
+// One limiter is shared by all queries of the datasource, so the history processing of many queries
+// does not send all requests at once. Every HTTP request is limited, including the pages and the scroll requests
+// of a single query execution
+type datasourceLimiter struct {
+	dsName   string
+	slots    chan struct{}
+	interval time.Duration
+	mutex    sync.Mutex
+	next     time.Time
+	queued   atomic.Int64
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// newDatasourceLimiter returns nil if neither max-concurrent-requests nor requests-per-second is set for the datasource
+func newDatasourceLimiter(dsName string, dsConfig *config.DatasourceConfig) *datasourceLimiter {
+	if dsConfig.MaxConcurrentRequests <= 0 && dsConfig.RequestsPerSecond <= 0 {
+		return nil
+	}
+	result := datasourceLimiter{
+		dsName: dsName,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+	if dsConfig.MaxConcurrentRequests > 0 {
+		result.slots = make(chan struct{}, dsConfig.MaxConcurrentRequests)
+	}
+	if dsConfig.RequestsPerSecond > 0 {
+		result.interval = time.Duration(float64(time.Second) / dsConfig.RequestsPerSecond)
+	}
+	log.Infof("DatasourceLimiter : For datasource %v max concurrent requests is %v, requests per second is %v", dsName, dsConfig.MaxConcurrentRequests, dsConfig.RequestsPerSecond)
+	result.selfMonitorSetQueuedRequests(0)
+	return &result
+}
+
+// acquire waits until the request is allowed by the limits. The returned function must be called when the request is completed
+func (dl *datasourceLimiter) acquire() func() {
+	begin := dl.now()
+	dl.selfMonitorSetQueuedRequests(dl.queued.Add(1))
+	if dl.slots != nil {
+		dl.slots <- struct{}{}
+	}
+	if dl.interval > 0 {
+		dl.mutex.Lock()
+		now := dl.now()
+		allowedAt := dl.next
+		if allowedAt.Before(now) {
+			allowedAt = now
+		}
+		dl.next = allowedAt.Add(dl.interval)
+		dl.mutex.Unlock()
+		if wait := allowedAt.Sub(now); wait > 0 {
+			dl.sleep(wait)
+		}
+	}
+	dl.selfMonitorSetQueuedRequests(dl.queued.Add(-1))
+
+	waitTime := dl.now().Sub(begin)
+	log.Tracef("DatasourceLimiter : For datasource %v request waited %v", dl.dsName, waitTime)
+	dl.selfMonitorObserveRequestWaitTime(waitTime)
+	return func() {
+		if dl.slots != nil {
+			<-dl.slots
+		}
+	}
+}
+
+// do executes the request within the limits, the nil limiter does not limit the request.
+// The concurrent request is completed when the response body is closed
+func (dl *datasourceLimiter) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if dl == nil {
+		return client.Do(req)
+	}
+	release := dl.acquire()
+	resp, err := client.Do(req)
+	if err != nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody completes the limited request when the response body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
+
+func (dl *datasourceLimiter) selfMonitorSetQueuedRequests(value int64) {
+	labels := make(map[string]string)
+	labels["datasource"] = dl.dsName
+	timestamp := time.Now()
+	selfmonitor.SetQueuedRequests(float64(value), labels, &timestamp)
+}
+
+func (dl *datasourceLimiter) selfMonitorObserveRequestWaitTime(waitTime time.Duration) {
+	labels := make(map[string]string)
+	labels["datasource"] = dl.dsName
+	timestamp := time.Now()
+	selfmonitor.ObserveRequestWaitTime(labels, waitTime.Seconds(), &timestamp)
+}
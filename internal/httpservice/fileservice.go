@@ -0,0 +1,544 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log_exporter/internal/config"
+	ec "log_exporter/internal/utils/errorcodes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FILE_FORMAT_RAW    = "raw"
+	FILE_FORMAT_JSON   = "json"
+	FILE_FORMAT_LOGFMT = "logfmt"
+
+	FILE_FIELD_NAME    = "_FILE_"
+	MESSAGE_FIELD_NAME = "message"
+
+	// FILE_FINGERPRINT_SIZE is the number of the first bytes of the file used to detect that the file is replaced
+	FILE_FINGERPRINT_SIZE = 256
+	// FILE_MAX_LINE_SIZE limits the size of the line, the longer lines are skipped
+	FILE_MAX_LINE_SIZE = 1024 * 1024
+)
+
+// FileOffset is the position in the file up to which the lines are handed over to the query
+type FileOffset struct {
+	Offset          int64  `json:"offset"`
+	FingerprintSize int    `json:"fingerprint-size"`
+	Fingerprint     uint32 `json:"fingerprint"`
+}
+
+// FileService tails the local log files matching the glob of the datasource path. Every query execution
+// reads the complete lines appended since the previous execution of the same query
+type FileService struct {
+	appConfig *config.Config
+	dsConfig  *config.DatasourceConfig
+	dsName    string
+	format    string
+	offsets   *fileOffsetStore
+}
+
+func CreateFileService(appConfig *config.Config, dsName string) *FileService {
+	g := FileService{}
+	g.appConfig = appConfig
+	g.dsName = dsName
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.format = strings.ToLower(g.dsConfig.Format)
+	if g.format == "" {
+		g.format = FILE_FORMAT_RAW
+	}
+	g.offsets = newFileOffsetStore(g.dsConfig.OffsetsFile)
+	g.initOffsets()
+	log.Infof("FileService : Service created for path %v with format %v and offsets file %v", g.dsConfig.Path, g.format, g.dsConfig.OffsetsFile)
+	return &g
+}
+
+// Capabilities disables splitting, the lines are read from the stored offsets regardless of the time range
+func (g *FileService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{Retries: true}
+}
+
+func (g *FileService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	now := time.Now()
+	var err error
+	defer func() {
+		log.Debugf("FileService : For query %v files are read and lines are processed in %+v", qName, time.Since(now))
+		if err != nil && !errors.Is(err, ErrResponseTruncated) {
+			selfMonitorIncErrorCodeCount(qName, now)
+		} else {
+			selfMonitorRefreshErrorCodeCount(qName, now)
+		}
+	}()
+
+	records, size, errc, err := g.queryFiles(qName)
+	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
+	selfMonitorObserveQueryResponseSize(float64(size), qName, now)
+	if errors.Is(err, ErrResponseTruncated) {
+		selfMonitorIncResponseTruncatedCount(qName, now)
+	} else if err != nil {
+		return make([][]string, 0), errc, err
+	} else {
+		selfMonitorRefreshResponseTruncatedCount(qName, now)
+	}
+
+	return records, errc, err
+}
+
+// Commit saves the offsets of the lines returned by the last execution of the query. It is called after the result
+// is handed over for the evaluation, so the lines are read again after the restart if the result is lost
+func (g *FileService) Commit(qName string) {
+	if !g.offsets.commit(qName) {
+		return
+	}
+	if err := g.offsets.save(); err != nil {
+		log.WithField(ec.FIELD, ec.LME_7201).Errorf("FileService : For query %v error saving offsets file %v : %+v", qName, g.dsConfig.OffsetsFile, err)
+	}
+}
+
+// initOffsets sets the offsets of the queries which have no stored offsets to the end of the existing files,
+// so on the first start only the lines appended after the start are read. Files which appear later are read from the beginning
+func (g *FileService) initOffsets() {
+	paths, err := filepath.Glob(g.dsConfig.Path)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7200).Errorf("FileService : Path %v of datasource %v is incorrect : %+v", g.dsConfig.Path, g.dsName, err)
+		return
+	}
+	for qName, qConfig := range g.appConfig.Queries {
+		if qConfig == nil || qConfig.Datasource != g.dsName || g.offsets.get(qName) != nil {
+			continue
+		}
+		offsets := make(map[string]*FileOffset)
+		for _, path := range paths {
+			offset, err := getFileEndOffset(path)
+			if err != nil {
+				log.WithField(ec.FIELD, ec.LME_7200).Errorf("FileService : For query %v error reading file %v : %+v", qName, path, err)
+				continue
+			}
+			offsets[path] = offset
+		}
+		g.offsets.set(qName, offsets)
+		g.offsets.commit(qName)
+		log.Infof("FileService : For query %v %v files are tailed from their end", qName, len(offsets))
+	}
+	if err := g.offsets.save(); err != nil {
+		log.WithField(ec.FIELD, ec.LME_7201).Errorf("FileService : Error saving offsets file %v : %+v", g.dsConfig.OffsetsFile, err)
+	}
+}
+
+// queryFiles reads the new complete lines of all files matching the path. If max-records or max-response-size is reached,
+// the lines read so far are returned together with the LME-7202 error code and wrapped ErrResponseTruncated;
+// the rest of the lines are read by the next execution. The offsets are kept only if all files are read successfully,
+// they are saved by Commit
+func (g *FileService) queryFiles(qName string) ([][]string, int64, string, error) {
+	paths, err := filepath.Glob(g.dsConfig.Path)
+	if err != nil {
+		return nil, 0, ec.LME_7200, fmt.Errorf("FileService : For query %v path %v is incorrect : %+v", qName, g.dsConfig.Path, err)
+	}
+	sort.Strings(paths)
+
+	previousOffsets := g.offsets.get(qName)
+	offsets := make(map[string]*FileOffset, len(paths))
+	entries := make([]map[string]string, 0)
+	var size int64
+	truncated := false
+	for _, path := range paths {
+		if truncated {
+			if offset, ok := previousOffsets[path]; ok {
+				offsets[path] = offset
+			}
+			continue
+		}
+		offset, lines, linesSize, fileTruncated, err := g.readFile(qName, path, previousOffsets, len(entries), size)
+		if err != nil {
+			return nil, size, ec.LME_7200, fmt.Errorf("FileService : For query %v error reading file %v : %w", qName, path, err)
+		}
+		offsets[path] = offset
+		size += linesSize
+		truncated = fileTruncated
+		for _, line := range lines {
+			entry := g.parseLine(line)
+			entry[FILE_FIELD_NAME] = path
+			entries = append(entries, entry)
+		}
+	}
+
+	g.offsets.set(qName, offsets)
+
+	records := fieldMapsToRecords(entries, g.appConfig.Queries[qName].FieldsInOrder)
+	log.Infof("FileService : For query %v %v files are read, %v lines (%v bytes) are received", qName, len(paths), len(entries), size)
+	log.Debugf("FileService : For query %v result len = %v, result records = %+v", qName, len(records), records)
+	if truncated {
+		log.WithField(ec.FIELD, ec.LME_7202).Warnf("FileService : For query %v max-records %v or max-response-size %v is reached, the rest of the lines will be read by the next execution", qName, g.dsConfig.MaxRecords, g.dsConfig.MaxResponseSize)
+		return records, size, ec.LME_7202, fmt.Errorf("FileService : For query %v max-records %v or max-response-size %v is reached : %w", qName, g.dsConfig.MaxRecords, g.dsConfig.MaxResponseSize, ErrResponseTruncated)
+	}
+	return records, size, "", nil
+}
+
+// readFile reads the complete lines of the file starting from the offset of its path. If the file is new or replaced
+// (its first bytes do not match the fingerprint), the offset of the file renamed by the log rotation is looked up
+// among the offsets of the other paths by the fingerprint; the file is read from the beginning if it is not found
+// or if the file is truncated. The incomplete last line is left for the next execution.
+// The reading stops when the total number of lines or bytes reaches the datasource limits
+func (g *FileService) readFile(qName string, path string, previousOffsets map[string]*FileOffset, linesCount int, size int64) (*FileOffset, []string, int64, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Errorf("FileService : Error closing file %v : %+v", path, err)
+		}
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+
+	var position int64
+	offset := previousOffsets[path]
+	if offset != nil {
+		position = offset.Offset
+		if info.Size() < position {
+			log.Infof("FileService : For query %v file %v is truncated, it is read from the beginning", qName, path)
+			position = 0
+		} else if fingerprint, err := getFileFingerprint(file, offset.FingerprintSize); err != nil {
+			return nil, nil, 0, false, err
+		} else if fingerprint != offset.Fingerprint {
+			log.Infof("FileService : For query %v file %v is replaced", qName, path)
+			offset = nil
+		}
+	}
+	if offset == nil {
+		renamedPath, renamedOffset, err := findRenamedFileOffset(file, info.Size(), path, previousOffsets)
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+		position = 0
+		if renamedOffset != nil {
+			log.Infof("FileService : For query %v file %v is renamed to %v, it is read from the offset %v", qName, renamedPath, path, renamedOffset.Offset)
+			position = renamedOffset.Offset
+		} else if previousOffsets[path] != nil {
+			log.Infof("FileService : For query %v file %v is read from the beginning", qName, path)
+		}
+	}
+	if _, err = file.Seek(position, io.SeekStart); err != nil {
+		return nil, nil, 0, false, err
+	}
+
+	lines := make([]string, 0)
+	var linesSize int64
+	truncated := false
+	reader := bufio.NewReader(file)
+	for {
+		if (g.dsConfig.MaxRecords > 0 && linesCount+len(lines) >= g.dsConfig.MaxRecords) ||
+			(g.dsConfig.MaxResponseSize > 0 && size+linesSize >= g.dsConfig.MaxResponseSize) {
+			truncated = position+linesSize < info.Size()
+			break
+		}
+		line, lineSize, err := readFileLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+		linesSize += lineSize
+		if line == nil {
+			log.WithField(ec.FIELD, ec.LME_7203).Warnf("FileService : For query %v line of file %v at offset %v exceeds the max line size %v and is skipped", qName, path, position+linesSize-lineSize, FILE_MAX_LINE_SIZE)
+			continue
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+	}
+
+	result := FileOffset{Offset: position + linesSize}
+	result.FingerprintSize = int(min(result.Offset, FILE_FINGERPRINT_SIZE))
+	result.Fingerprint, err = getFileFingerprint(file, result.FingerprintSize)
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+	return &result, lines, linesSize, truncated, nil
+}
+
+// readFileLine reads the complete line and returns it together with the number of the bytes read. The line longer than
+// FILE_MAX_LINE_SIZE is read up to its end without keeping it in memory and nil is returned. If the line is not complete,
+// io.EOF is returned
+func readFileLine(reader *bufio.Reader) ([]byte, int64, error) {
+	var line []byte
+	var size int64
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += int64(len(chunk))
+		if size <= FILE_MAX_LINE_SIZE {
+			line = append(line, chunk...)
+		} else {
+			line = nil
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, size, err
+		}
+		if size > FILE_MAX_LINE_SIZE {
+			return nil, size, nil
+		}
+		return line, size, nil
+	}
+}
+
+// findRenamedFileOffset looks up the offset of the other path whose fingerprint matches the first bytes of the file,
+// so the file renamed by the log rotation is read from the offset it had before the rename
+func findRenamedFileOffset(file *os.File, fileSize int64, path string, previousOffsets map[string]*FileOffset) (string, *FileOffset, error) {
+	paths := make([]string, 0, len(previousOffsets))
+	for previousPath := range previousOffsets {
+		paths = append(paths, previousPath)
+	}
+	sort.Strings(paths)
+	for _, previousPath := range paths {
+		offset := previousOffsets[previousPath]
+		if previousPath == path || offset == nil || offset.FingerprintSize == 0 || fileSize < offset.Offset {
+			continue
+		}
+		fingerprint, err := getFileFingerprint(file, offset.FingerprintSize)
+		if err != nil {
+			return "", nil, err
+		}
+		if fingerprint == offset.Fingerprint {
+			return previousPath, offset, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// parseLine turns the line into the named fields according to the format of the datasource.
+// The lines which can not be parsed are returned as the message field
+func (g *FileService) parseLine(line string) map[string]string {
+	switch g.format {
+	case FILE_FORMAT_JSON:
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		var value map[string]interface{}
+		if err := decoder.Decode(&value); err == nil && value != nil {
+			result := make(map[string]string, len(value))
+			flattenJsonObject("", value, result)
+			return result
+		}
+		log.Debugf("FileService : Line %v is not a JSON object, it is processed as raw line", line)
+	case FILE_FORMAT_LOGFMT:
+		if result := parseLogfmt(line); len(result) > 0 {
+			return result
+		}
+		log.Debugf("FileService : Line %v contains no logfmt pairs, it is processed as raw line", line)
+	}
+	return map[string]string{MESSAGE_FIELD_NAME: line}
+}
+
+// flattenJsonObject puts the fields of the nested objects to the result with the dotted names, for example kubernetes.pod_name
+func flattenJsonObject(prefix string, value map[string]interface{}, result map[string]string) {
+	for k, v := range value {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenJsonObject(prefix+k+".", nested, result)
+			continue
+		}
+		result[prefix+k] = formatJsonValue(v)
+	}
+}
+
+// parseLogfmt parses the key=value pairs of the line, the values may be quoted. Keys without values are set to "true".
+// If the line contains no key=value pairs, nil is returned
+func parseLogfmt(line string) map[string]string {
+	result := make(map[string]string)
+	hasPairs := false
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			result[key] = "true"
+			continue
+		}
+		i++
+		if key == "" {
+			continue
+		}
+		hasPairs = true
+		if i < len(line) && line[i] == '"' {
+			var value strings.Builder
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					default:
+						value.WriteByte(line[i])
+					}
+					continue
+				}
+				value.WriteByte(line[i])
+			}
+			i++
+			result[key] = value.String()
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		result[key] = line[start:i]
+	}
+	if !hasPairs {
+		return nil
+	}
+	return result
+}
+
+func getFileEndOffset(path string) (*FileOffset, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Errorf("FileService : Error closing file %v : %+v", path, err)
+		}
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := FileOffset{Offset: info.Size(), FingerprintSize: int(min(info.Size(), FILE_FINGERPRINT_SIZE))}
+	result.Fingerprint, err = getFileFingerprint(file, result.FingerprintSize)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func getFileFingerprint(file *os.File, size int) (uint32, error) {
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf[:n]), nil
+}
+
+// fileOffsetStore keeps the file offsets of the queries and saves them to the offsets file (if set),
+// so the lines are not read again after the restart. The offsets of the lines read by the query are pending
+// until they are committed, only the committed offsets are saved
+type fileOffsetStore struct {
+	sync.Mutex
+	path    string
+	offsets map[string]map[string]*FileOffset
+	pending map[string]map[string]*FileOffset
+}
+
+func newFileOffsetStore(path string) *fileOffsetStore {
+	result := fileOffsetStore{
+		path:    path,
+		offsets: make(map[string]map[string]*FileOffset),
+		pending: make(map[string]map[string]*FileOffset),
+	}
+	if path == "" {
+		return &result
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Infof("FileService : Offsets file %v does not exist, it will be created", path)
+		return &result
+	}
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7201).Errorf("FileService : Error reading offsets file %v : %+v", path, err)
+		return &result
+	}
+	if err = json.Unmarshal(data, &result.offsets); err != nil {
+		log.WithField(ec.FIELD, ec.LME_7201).Errorf("FileService : Error parsing offsets file %v : %+v", path, err)
+		result.offsets = make(map[string]map[string]*FileOffset)
+	}
+	return &result
+}
+
+func (fos *fileOffsetStore) get(qName string) map[string]*FileOffset {
+	fos.Lock()
+	defer fos.Unlock()
+	if offsets, ok := fos.pending[qName]; ok {
+		return offsets
+	}
+	return fos.offsets[qName]
+}
+
+func (fos *fileOffsetStore) set(qName string, offsets map[string]*FileOffset) {
+	fos.Lock()
+	defer fos.Unlock()
+	fos.pending[qName] = offsets
+}
+
+// commit moves the pending offsets of the query to the offsets to be saved, it returns false if there are no pending offsets
+func (fos *fileOffsetStore) commit(qName string) bool {
+	fos.Lock()
+	defer fos.Unlock()
+	offsets, ok := fos.pending[qName]
+	if !ok {
+		return false
+	}
+	fos.offsets[qName] = offsets
+	delete(fos.pending, qName)
+	return true
+}
+
+// save writes the offsets to the temporary file and renames it, so the offsets file is never left partially written
+func (fos *fileOffsetStore) save() error {
+	if fos.path == "" {
+		return nil
+	}
+	fos.Lock()
+	defer fos.Unlock()
+	data, err := json.MarshalIndent(fos.offsets, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := fos.path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, fos.path)
+}
@@ -16,12 +16,14 @@ package httpservice
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log_exporter/internal/config"
 	"log_exporter/internal/selfmonitor"
-	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
 	"net"
 	"net/http"
@@ -31,6 +33,32 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrResponseTruncated is returned (wrapped) by the services together with the partial result
+// when the datasource response exceeds the configured limits
+var ErrResponseTruncated = errors.New("datasource response is truncated")
+
+// ErrRequestTimeout is returned (wrapped) by the services when the datasource responds with the gateway timeout status
+var ErrRequestTimeout = errors.New("datasource request timed out")
+
+// IsTimeoutError checks whether the error returned by the service is caused by the request timeout
+func IsTimeoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrRequestTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func statusCodeError(serviceName string, qName string, statusCode int) error {
+	if statusCode == http.StatusGatewayTimeout {
+		return fmt.Errorf("%v : For query %v status code is %v : %w", serviceName, qName, statusCode, ErrRequestTimeout)
+	}
+	return fmt.Errorf("%v : For query %v status code is %v", serviceName, qName, statusCode)
+}
+
 var (
 	// editorconfig-checker-disable used because next lines are part of the template
 	graylogJsonTemplate = `{
@@ -53,69 +81,84 @@ var (
 type GraylogService struct {
 	appConfig *config.Config
 	dsConfig  *config.DatasourceConfig
+	transport *datasourceTransport
 }
 
-func CreateGraylogService(appConfig *config.Config) *GraylogService {
+func CreateGraylogService(appConfig *config.Config, dsName string) *GraylogService {
 	g := GraylogService{}
 	g.appConfig = appConfig
-	g.dsConfig = appConfig.Datasources[appConfig.DsName]
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.transport = newDatasourceTransport(appConfig, dsName)
 	return &g
 }
 
+func (g *GraylogService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{Retries: true, TimerangeSplitting: true}
+}
+
 func (g *GraylogService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
 	now := time.Now()
 	var err error
 	defer func() {
 		log.Debugf("GraylogService : For query %v request executed and csv processed in %+v", qName, time.Since(now))
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrResponseTruncated) {
 			selfMonitorIncErrorCodeCount(qName, now)
 		} else {
 			selfMonitorRefreshErrorCodeCount(qName, now)
 		}
 	}()
 
-	stringResult, errc, err := g.queryGraylog(qName, startTime, endTime)
+	result, responseSize, errc, err := g.queryGraylog(qName, startTime, endTime)
 	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
-	selfMonitorObserveQueryResponseSize(float64(len(stringResult)), qName, now)
-	if err != nil {
+	selfMonitorObserveQueryResponseSize(float64(responseSize), qName, now)
+	if errors.Is(err, ErrResponseTruncated) {
+		selfMonitorIncResponseTruncatedCount(qName, now)
+	} else if err != nil {
 		return make([][]string, 0), errc, err
+	} else {
+		selfMonitorRefreshResponseTruncatedCount(qName, now)
 	}
 
-	result, errc, err := ProcessCsv(stringResult, qName)
 	return result, errc, err
 }
 
-func (g *GraylogService) queryGraylog(qName string, startTime time.Time, endTime time.Time) (string, string, error) {
+// queryGraylog parses the csv response while it is being read from the body, so the whole response is never kept in memory.
+// If the response exceeds max-records or max-response-size of the datasource, the records read so far are returned
+// together with the LME-7105 error code and wrapped ErrResponseTruncated
+func (g *GraylogService) queryGraylog(qName string, startTime time.Time, endTime time.Time) ([][]string, int64, string, error) {
 	qCfg := g.appConfig.Queries[qName]
+	queryStringJson := qCfg.QueryStringJson
+	if strings.Contains(qCfg.QueryString, "{{") {
+		queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, GRAYLOG_TEMPLATE_TIME_LAYOUT)
+		if err != nil {
+			return nil, 0, ec.LME_8102, fmt.Errorf("GraylogService : For query %v error creating queryString : %+v", qName, err)
+		}
+		res, err := json.Marshal(queryString)
+		if err != nil {
+			return nil, 0, ec.LME_8102, fmt.Errorf("GraylogService : For query %v error marshalling queryString : %+v", qName, err)
+		}
+		queryStringJson = string(res)
+	}
 	startTimeStr := startTime.Format("2006-01-02T15:04:05Z07:00")
 	endTimeStr := endTime.Format("2006-01-02T15:04:05Z07:00")
-	requestBody := fmt.Sprintf(graylogJsonTemplate, qCfg.StreamsJson, qCfg.QueryStringJson, startTimeStr, endTimeStr, qCfg.FieldsInOrderJson)
+	requestBody := fmt.Sprintf(graylogJsonTemplate, qCfg.StreamsJson, queryStringJson, startTimeStr, endTimeStr, qCfg.FieldsInOrderJson)
 	log.Debugf("GraylogService : For query %v requestBody is %v", qName, requestBody)
 
-	var transport http.RoundTripper = &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: g.dsConfig.ConnectionTimeout,
-		}).DialContext,
-		TLSClientConfig: g.dsConfig.TlsConfig,
-	}
-	client := http.Client{
-		Transport: transport,
-		Timeout:   g.dsConfig.ConnectionTimeout,
-	}
+	client := GetHTTPClient(&g.dsConfig.TLSHostConfig)
 
 	graylogEndpoint := strings.Trim(g.dsConfig.Host, " /") + "/api/views/search/messages"
 	req, err := http.NewRequest("POST", graylogEndpoint, bytes.NewBufferString(requestBody))
 	if err != nil {
-		return "", ec.LME_7100, fmt.Errorf("GraylogService : For query %v error creating request to %v : %+v", qName, graylogEndpoint, err)
+		return nil, 0, ec.LME_7100, fmt.Errorf("GraylogService : For query %v error creating request to %v : %+v", qName, graylogEndpoint, err)
 	}
 	if g.dsConfig.User != "" {
 		req.SetBasicAuth(g.dsConfig.User, g.dsConfig.Password)
 	}
 	req.Header.Add("X-Requested-By", "*")
 	req.Header.Add("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	resp, err := g.transport.do(qName, client, req)
 	if err != nil {
-		return "", ec.LME_7100, fmt.Errorf("GraylogService : For query %v error accessing %v : %+v", qName, graylogEndpoint, err)
+		return nil, 0, ec.LME_7100, fmt.Errorf("GraylogService : For query %v error accessing %v : %w", qName, graylogEndpoint, err)
 	}
 
 	if resp.Body != nil {
@@ -127,35 +170,97 @@ func (g *GraylogService) queryGraylog(qName string, startTime time.Time, endTime
 	}
 
 	log.Debugf("GraylogService : For query %v received response : %+v", qName, resp)
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", ec.LME_7100, fmt.Errorf("GraylogService : For query %v to %v error reading body : %+v", qName, graylogEndpoint, err)
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 10000))
+		log.WithField(ec.FIELD, ec.LME_7102).Errorf("GraylogService : For query %v received response with status code %v from graylog, response body (limited) : %s", qName, resp.StatusCode, body)
+		return nil, int64(len(body)), ec.LME_7101, statusCodeError("GraylogService", qName, resp.StatusCode)
 	}
-	result := string(body)
-	log.Infof("GraylogService : For query %v to %v response status is %v, body length is %v", qName, graylogEndpoint, resp.Status, len(result))
 	if resp.StatusCode != 200 {
-		log.WithField(ec.FIELD, ec.LME_7102).Errorf("GraylogService : For query %v received response with status code %v from graylog, response body (limited) : %v", qName, resp.StatusCode, utils.GetLimitedPrefix(result, 10000))
-		if resp.StatusCode >= 400 {
-			return "", ec.LME_7101, fmt.Errorf("GraylogService : For query %v status code is %v", qName, resp.StatusCode)
-		}
+		log.WithField(ec.FIELD, ec.LME_7102).Errorf("GraylogService : For query %v received response with status code %v from graylog", qName, resp.StatusCode)
 	}
-	log.Tracef("GraylogService : For query %v received response body : %v", qName, result)
 
-	return result, "", nil
+	body := &sizeLimitedReader{reader: resp.Body, limit: g.dsConfig.MaxResponseSize}
+	result, errc, err := processCsvStream(body, qName, g.dsConfig.MaxRecords)
+	log.Infof("GraylogService : For query %v to %v response status is %v, body length is %v, records count is %v", qName, graylogEndpoint, resp.Status, body.size, len(result))
+	if errors.Is(err, errResponseSizeLimit) {
+		log.WithField(ec.FIELD, ec.LME_7105).Warnf("GraylogService : For query %v response is larger than max-response-size %v bytes, only %v records are read", qName, g.dsConfig.MaxResponseSize, len(result))
+		return result, body.size, ec.LME_7105, fmt.Errorf("GraylogService : For query %v max-response-size %v is exceeded : %w", qName, g.dsConfig.MaxResponseSize, ErrResponseTruncated)
+	}
+	if errors.Is(err, ErrResponseTruncated) {
+		log.WithField(ec.FIELD, ec.LME_7105).Warnf("GraylogService : For query %v response contains more than max-records %v records, only %v records are read", qName, g.dsConfig.MaxRecords, len(result)-1)
+		err = fmt.Errorf("GraylogService : %w", err)
+	} else if err != nil {
+		err = fmt.Errorf("GraylogService : For query %v to %v error reading body : %w", qName, graylogEndpoint, err)
+	}
+	return result, body.size, errc, err
 }
 
+// ProcessCsv parses the csv table kept in the string
 func ProcessCsv(stringData string, qName string) ([][]string, string, error) {
-	r := csv.NewReader(strings.NewReader(stringData))
+	return processCsvStream(strings.NewReader(stringData), qName, 0)
+}
 
-	records, err := r.ReadAll()
-	if err != nil {
-		resError := fmt.Errorf("GraylogService : For query %v got error reading csv : %+v", qName, err)
-		return make([][]string, 0), ec.LME_7103, resError
+// processCsvStream parses the csv table record by record. It is shared by the services which receive csv responses,
+// the returned error codes are the Graylog ones. If maxRecords is positive and the table contains more records
+// (not counting the header), the first maxRecords records are returned with the LME-7105 error code and wrapped ErrResponseTruncated
+func processCsvStream(reader io.Reader, qName string, maxRecords int) ([][]string, string, error) {
+	r := csv.NewReader(reader)
+
+	records := make([][]string, 0)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				resError := fmt.Errorf("For query %v got error reading csv : %+v", qName, err)
+				return make([][]string, 0), ec.LME_7103, resError
+			}
+			if errors.Is(err, errResponseSizeLimit) {
+				return records, ec.LME_7105, err
+			}
+			return make([][]string, 0), ec.LME_7100, err
+		}
+		if maxRecords > 0 && len(records) > maxRecords {
+			return records, ec.LME_7105, fmt.Errorf("For query %v max-records %v is exceeded, the rest of the response is skipped : %w", qName, maxRecords, ErrResponseTruncated)
+		}
+		records = append(records, record)
 	}
 	log.Tracef("GraylogService : For query %v got records : %v", qName, records)
 	return records, "", nil
 }
 
+var errResponseSizeLimit = errors.New("response size limit is exceeded")
+
+// sizeLimitedReader counts the bytes read and returns errResponseSizeLimit
+// if the positive limit is reached and the underlying reader still has data
+type sizeLimitedReader struct {
+	reader io.Reader
+	limit  int64
+	size   int64
+}
+
+func (slr *sizeLimitedReader) Read(p []byte) (int, error) {
+	if slr.limit > 0 {
+		if slr.size >= slr.limit {
+			var probe [1]byte
+			n, err := io.ReadFull(slr.reader, probe[:])
+			if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+				return 0, io.EOF
+			}
+			return 0, errResponseSizeLimit
+		}
+		if int64(len(p)) > slr.limit-slr.size {
+			p = p[:slr.limit-slr.size]
+		}
+	}
+	n, err := slr.reader.Read(p)
+	slr.size += int64(n)
+	return n, err
+}
+
 func selfMonitorObserveQueryResponseSize(value float64, qName string, timestamp time.Time) {
 	labels := make(map[string]string)
 	labels["query_name"] = qName
@@ -179,3 +284,15 @@ func selfMonitorObserveQueryLatency(value float64, qName string, timestamp time.
 	labels["query_name"] = qName
 	selfmonitor.ObserveQueryLatency(labels, value, &timestamp)
 }
+
+func selfMonitorIncResponseTruncatedCount(qName string, timestamp time.Time) {
+	labels := make(map[string]string)
+	labels["query_name"] = qName
+	selfmonitor.IncResponseTruncatedCount(labels, &timestamp)
+}
+
+func selfMonitorRefreshResponseTruncatedCount(qName string, timestamp time.Time) {
+	labels := make(map[string]string)
+	labels["query_name"] = qName
+	selfmonitor.RefreshResponseTruncatedCount(labels, &timestamp)
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log_exporter/internal/config"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// OAUTH2_EXPIRY_DELTA is the time before the token expiration when the token is refreshed
+	OAUTH2_EXPIRY_DELTA = 10 * time.Second
+)
+
+// tokenSource returns the bearer token for the request. Invalidate is called when the host responded with 401,
+// so the next request gets a new token
+type tokenSource interface {
+	Token() (string, error)
+	Invalidate()
+}
+
+// authRoundTripper adds the static headers and the bearer token from the auth configuration to the requests.
+// The Authorization header set by the token replaces the Basic auth of the request
+type authRoundTripper struct {
+	next    http.RoundTripper
+	host    string
+	headers map[string]string
+	token   tokenSource
+}
+
+// newAuthRoundTripper wraps the transport if the auth is configured, otherwise the transport is returned as is
+func newAuthRoundTripper(next http.RoundTripper, hostConfig *config.TLSHostConfig) http.RoundTripper {
+	auth := hostConfig.Auth
+	if auth == nil {
+		return next
+	}
+	rt := &authRoundTripper{
+		next:    next,
+		host:    hostConfig.Host,
+		headers: auth.Headers,
+	}
+	switch {
+	case auth.BearerToken != "":
+		rt.token = staticTokenSource(auth.BearerToken)
+	case auth.BearerTokenFile != "":
+		rt.token = &fileTokenSource{path: auth.BearerTokenFile}
+	case auth.OAuth2 != nil:
+		// the token endpoint is usually another host, so the TLS settings and the client certificate of the host
+		// are not used for it and its connections are not counted for the host
+		rt.token = &oauth2TokenSource{
+			config: auth.OAuth2,
+			client: &http.Client{Timeout: hostConfig.ConnectionTimeout},
+			now:    time.Now,
+		}
+	}
+	return rt
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range rt.headers {
+		req.Header.Set(name, value)
+	}
+	if rt.token == nil {
+		return rt.next.RoundTrip(req)
+	}
+	token, err := rt.token.Token()
+	if err != nil {
+		return nil, fmt.Errorf("error getting token for host %v : %w", rt.host, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := rt.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		log.Warnf("Host %v responded with status code 401, the token is invalidated", rt.host)
+		rt.token.Invalidate()
+	}
+	return resp, err
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+func (s staticTokenSource) Invalidate() {}
+
+// fileTokenSource reads the token from the file and re-reads it when the modification time or the size of the file changes,
+// so the rotated tokens (for example, the projected service account tokens) are picked up without restart
+type fileTokenSource struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	token   string
+}
+
+func (f *fileTokenSource) Token() (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_1650).Errorf("Error reading bearer token file %v : %+v", f.path, err)
+		if f.token != "" {
+			return f.token, nil
+		}
+		return "", err
+	}
+	if f.token != "" && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, nil
+	}
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_1650).Errorf("Error reading bearer token file %v : %+v", f.path, err)
+		return "", err
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("bearer token file %v is empty", f.path)
+	}
+	f.token, f.modTime, f.size = token, info.ModTime(), info.Size()
+	log.Infof("Bearer token is read from file %v", f.path)
+	return f.token, nil
+}
+
+func (f *fileTokenSource) Invalidate() {
+	f.Lock()
+	defer f.Unlock()
+	f.modTime = time.Time{}
+}
+
+// oauth2TokenSource gets the token with the OAuth2 client credentials grant and caches it until it expires
+type oauth2TokenSource struct {
+	sync.Mutex
+	config *config.OAuth2Config
+	client *http.Client
+	now    func() time.Time
+	token  string
+	expiry time.Time
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (o *oauth2TokenSource) Token() (string, error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.token != "" && (o.expiry.IsZero() || o.now().Before(o.expiry)) {
+		return o.token, nil
+	}
+	token, expiresIn, err := o.requestToken()
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_1651).Errorf("Error requesting OAuth2 token from %v : %+v", o.config.TokenUrl, err)
+		return "", err
+	}
+	o.token = token
+	o.expiry = time.Time{}
+	if expiresIn > 0 {
+		o.expiry = o.now().Add(expiresIn - min(OAUTH2_EXPIRY_DELTA, expiresIn/2))
+	}
+	log.Infof("OAuth2 token is received from %v, expires in %v", o.config.TokenUrl, expiresIn)
+	return o.token, nil
+}
+
+func (o *oauth2TokenSource) Invalidate() {
+	o.Lock()
+	defer o.Unlock()
+	o.token = ""
+}
+
+func (o *oauth2TokenSource) requestToken() (string, time.Duration, error) {
+	clientSecret := o.config.ClientSecret
+	if o.config.ClientSecretFile != "" {
+		content, err := os.ReadFile(o.config.ClientSecretFile)
+		if err != nil {
+			return "", 0, fmt.Errorf("error reading client-secret-file : %w", err)
+		}
+		clientSecret = strings.TrimSpace(string(content))
+	}
+	params := url.Values{}
+	params.Set("grant_type", "client_credentials")
+	if len(o.config.Scopes) > 0 {
+		params.Set("scope", strings.Join(o.config.Scopes, " "))
+	}
+	for name, value := range o.config.EndpointParams {
+		params.Set(name, value)
+	}
+	req, err := http.NewRequest("POST", o.config.TokenUrl, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientId), url.QueryEscape(clientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Errorf("Error closing OAuth2 token response body : %+v", err)
+		}
+	}()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint responded with status code %v, response body (limited) : %.1000s", resp.StatusCode, body)
+	}
+	var tokenResponse oauth2TokenResponse
+	if err = json.Unmarshal(body, &tokenResponse); err != nil {
+		return "", 0, fmt.Errorf("error parsing token response : %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", 0, fmt.Errorf("token response contains no access_token")
+	}
+	if tokenResponse.TokenType != "" && !strings.EqualFold(tokenResponse.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("token type %v is not supported", tokenResponse.TokenType)
+	}
+	return tokenResponse.AccessToken, time.Duration(tokenResponse.ExpiresIn) * time.Second, nil
+}
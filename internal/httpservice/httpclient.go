@@ -0,0 +1,101 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"log_exporter/internal/config"
+	"log_exporter/internal/selfmonitor"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	httpClientsMutex sync.Mutex
+	httpClients      = make(map[*config.TLSHostConfig]*http.Client)
+)
+
+// GetHTTPClient returns the long-lived client for the host configuration, so keep-alive connections
+// (and TLS sessions) are reused between the calls. The client is created on the first call.
+// The auth of the host configuration is added to every request made with the client.
+func GetHTTPClient(hostConfig *config.TLSHostConfig) *http.Client {
+	httpClientsMutex.Lock()
+	defer httpClientsMutex.Unlock()
+	if client, ok := httpClients[hostConfig]; ok {
+		return client
+	}
+
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   hostConfig.ConnectionTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     hostConfig.TlsConfig,
+		TLSHandshakeTimeout: hostConfig.ConnectionTimeout,
+		ForceAttemptHTTP2:   !hostConfig.DisableHTTP2,
+		MaxIdleConns:        hostConfig.MaxIdleConns,
+		MaxIdleConnsPerHost: hostConfig.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     hostConfig.MaxConnsPerHost,
+		IdleConnTimeout:     hostConfig.IdleConnTimeout,
+	}
+	client := &http.Client{
+		Transport: newAuthRoundTripper(&connectionsTracingRoundTripper{
+			next: transport,
+			host: getHostLabel(hostConfig.Host),
+		}, hostConfig),
+		Timeout: hostConfig.ConnectionTimeout,
+	}
+	httpClients[hostConfig] = client
+	log.Infof("HTTP client for host %v is created : maxIdleConns = %v, maxIdleConnsPerHost = %v, maxConnsPerHost = %v, idleConnTimeout = %v, http2 = %v",
+		hostConfig.Host, hostConfig.MaxIdleConns, hostConfig.MaxIdleConnsPerHost, hostConfig.MaxConnsPerHost, hostConfig.IdleConnTimeout, !hostConfig.DisableHTTP2)
+	return client
+}
+
+// connectionsTracingRoundTripper counts the connections used for the requests and whether they were reused
+type connectionsTracingRoundTripper struct {
+	next http.RoundTripper
+	host string
+}
+
+func (rt *connectionsTracingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			log.Tracef("HTTP client for host %v got connection, reused = %v, idle time = %v", rt.host, info.Reused, info.IdleTime)
+			selfMonitorIncHttpConnectionsCount(rt.host, info.Reused, time.Now())
+		},
+	}
+	return rt.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func getHostLabel(host string) string {
+	u, err := url.Parse(host)
+	if err != nil || u.Host == "" {
+		return host
+	}
+	return u.Host
+}
+
+func selfMonitorIncHttpConnectionsCount(host string, reused bool, timestamp time.Time) {
+	labels := make(map[string]string)
+	labels["host"] = host
+	labels["reused"] = strconv.FormatBool(reused)
+	selfmonitor.IncHttpConnectionsCount(labels, &timestamp)
+}
@@ -15,9 +15,37 @@
 package httpservice
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log_exporter/internal/config"
+	"log_exporter/internal/registry"
+	"log_exporter/internal/selfmonitor"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+func TestMain(m *testing.M) {
+	testCfg := &config.Config{}
+	selfmonitor.InitSelfMonitoring(testCfg, nil, registry.NewDERegistry(testCfg))
+	os.Exit(m.Run())
+}
+
 func TestProcessCsv_ValidData(t *testing.T) {
 	csvData := `field1,field2,field3
 value1,value2,value3
@@ -42,6 +70,233 @@ value4,value5,value6`
 	}
 }
 
+func TestQueryGraylog_StreamingLimits(t *testing.T) {
+	csvData := "field1,field2\nvalue1,value2\nvalue3,value4\nvalue5,value6\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(csvData))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name            string
+		maxRecords      int
+		maxResponseSize int64
+		expectedRows    int
+		truncated       bool
+	}{
+		{"no limits", 0, 0, 4, false},
+		{"max-records equal to records count", 3, 0, 4, false},
+		{"max-records exceeded", 2, 0, 3, true},
+		{"max-response-size equal to body size", 0, int64(len(csvData)), 4, false},
+		{"max-response-size exceeded", 0, 30, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appConfig := &config.Config{
+				Datasources: map[string]*config.DatasourceConfig{
+					"graylog": {
+						TLSHostConfig:   config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5},
+						Type:            "graylog",
+						MaxRecords:      tt.maxRecords,
+						MaxResponseSize: tt.maxResponseSize,
+					},
+				},
+				Queries: map[string]*config.QueryConfig{"test_query": {}},
+			}
+			service := CreateGraylogService(appConfig, "graylog")
+			result, _, errc, err := service.queryGraylog("test_query", time.Now().Add(-time.Minute), time.Now())
+			if tt.truncated {
+				if !errors.Is(err, ErrResponseTruncated) || errc != "LME-7105" {
+					t.Errorf("Expected truncated response error with LME-7105, got: %v (%v)", err, errc)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, got: %v (%v)", err, errc)
+			}
+			if len(result) != tt.expectedRows {
+				t.Errorf("Expected %d rows, got: %d (%+v)", tt.expectedRows, len(result), result)
+			}
+		})
+	}
+}
+
+func TestRenderQueryString(t *testing.T) {
+	t.Setenv("LME_TEST_NAMESPACE", "monitoring")
+	t.Setenv("LME_TEST_SECRET", "secret")
+	appConfig := &config.Config{
+		General: &config.GeneralConfig{QueryTemplateEnv: []string{"LME_TEST_NAMESPACE"}},
+		Datasources: map[string]*config.DatasourceConfig{
+			"loki": {Type: "loki", Labels: map[string]string{"cluster": "dev"}},
+		},
+		Queries: map[string]*config.QueryConfig{
+			"templated": {
+				Datasource:  "loki",
+				QueryString: `count_over_time({namespace="{{.Env.LME_TEST_NAMESPACE}}",cluster="{{.Labels.cluster}}",query="{{.QueryName}}"}[{{.Interval}}]) {{.StartTimeUnix}} {{.EndTime}}`,
+			},
+			"secret":  {Datasource: "loki", QueryString: `{{.Env.LME_TEST_SECRET}}`},
+			"plain":   {Datasource: "loki", QueryString: `{app="test"}`},
+			"invalid": {Datasource: "loki", QueryString: `{{.Unknown`},
+		},
+	}
+	startTime := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	endTime := startTime.Add(time.Minute)
+
+	result, err := RenderQueryString(appConfig, "templated", startTime, endTime, LOKI_TEMPLATE_TIME_LAYOUT)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	expected := `count_over_time({namespace="monitoring",cluster="dev",query="templated"}[60s]) 1704164640 2024-01-02T03:05:00Z`
+	if result != expected {
+		t.Errorf("Expected %q, got: %q", expected, result)
+	}
+
+	result, err = RenderQueryString(appConfig, "secret", startTime, endTime, LOKI_TEMPLATE_TIME_LAYOUT)
+	if err != nil || result != "<no value>" {
+		t.Errorf("Expected environment variable not listed in query-template-env to be unavailable, got: %q (%v)", result, err)
+	}
+
+	result, err = RenderQueryString(appConfig, "plain", startTime, endTime, LOKI_TEMPLATE_TIME_LAYOUT)
+	if err != nil || result != `{app="test"}` {
+		t.Errorf("Expected query string without actions to be returned as is, got: %q (%v)", result, err)
+	}
+
+	if _, err = RenderQueryString(appConfig, "invalid", startTime, endTime, LOKI_TEMPLATE_TIME_LAYOUT); err == nil {
+		t.Error("Expected error for invalid template")
+	}
+}
+
+func TestQueryNerdGraph_AsyncFacets(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != "POST" || r.URL.Path != "/graphql" || r.Header.Get("API-Key") != "key" {
+			t.Errorf("Unexpected request %v %v", r.Method, r.URL.Path)
+		}
+		var body struct {
+			Query     string
+			Variables map[string]interface{}
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Variables["accountId"] != float64(12345) {
+			t.Errorf("Unexpected accountId %v", body.Variables["accountId"])
+		}
+		if strings.Contains(body.Query, "nrqlQueryProgress") {
+			if body.Variables["queryId"] != "q-1" {
+				t.Errorf("Unexpected queryId %v", body.Variables["queryId"])
+			}
+			_, _ = w.Write([]byte(`{"data":{"actor":{"account":{"nrqlQueryProgress":{
+				"rawResponse":{"facets":[{"name":["web","GET"],"results":[{"count":10}]},{"name":["api","POST"],"results":[{"count":3}]}],
+					"metadata":{"facet":["appName","method"],"contents":{"contents":[{"function":"count","simple":true}]}}},
+				"queryProgress":{"queryId":"q-1","completed":true}}}}}}`))
+			return
+		}
+		if body.Variables["nrql"] != "SELECT count(*) FROM Transaction FACET appName, method" {
+			t.Errorf("Unexpected nrql %v", body.Variables["nrql"])
+		}
+		deadline := time.Now().Add(time.Minute).UnixMilli()
+		_, _ = w.Write([]byte(`{"data":{"actor":{"account":{"nrql":{"rawResponse":null,
+			"queryProgress":{"queryId":"q-1","completed":false,"retryAfter":0,"retryDeadline":` + strconv.FormatInt(deadline, 10) + `}}}}}}`))
+	}))
+	defer server.Close()
+
+	appConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{
+			"newrelic": {
+				TLSHostConfig: config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5, User: "12345", Password: "key"},
+				Type:          "newrelic",
+				Api:           "nerdgraph",
+			},
+		},
+		Queries: map[string]*config.QueryConfig{
+			"test_query": {QueryString: "SELECT count(*) FROM Transaction FACET appName, method"},
+		},
+	}
+
+	service := CreateNewRelicService(appConfig, "newrelic")
+	result, _, errc, err := service.queryNerdGraph("test_query", time.Now().Add(-time.Minute), time.Now())
+	if err != nil || errc != "" {
+		t.Fatalf("Expected no error, got: %v (%v)", err, errc)
+	}
+	expected := [][]string{
+		{"appName", "method", RESULT_FIELD_NAME},
+		{"web", "GET", "10"},
+		{"api", "POST", "3"},
+	}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got: %v", expected, result)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got: %d", requests)
+	}
+}
+
+func TestProcessNerdGraphResult(t *testing.T) {
+	service := &NewRelicService{}
+	uniqueCount := service.processNerdGraphResult(&NerdGraphNrqlResult{
+		RawResponse: []byte(`{"results":[{"uniqueCount":7}],"metadata":{"contents":[{"function":"uniqueCount","attribute":"userId"}]}}`),
+	}, "test_query")
+	if fmt.Sprint(uniqueCount) != fmt.Sprint([][]string{{RESULT_FIELD_NAME}, {"7"}}) {
+		t.Errorf("Unexpected uniqueCount records %v", uniqueCount)
+	}
+
+	events := service.processNerdGraphResult(&NerdGraphNrqlResult{
+		RawResponse: []byte(`{"results":[{"events":[{"name":"a"},{"name":"b"}]}]}`),
+	}, "test_query")
+	if fmt.Sprint(events) != fmt.Sprint([][]string{{"name"}, {"a"}, {"b"}}) {
+		t.Errorf("Unexpected events records %v", events)
+	}
+}
+
+func TestProcessJson_NewRelicResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected [][]string
+	}{
+		{
+			name: "multi-aggregate facets",
+			response: `{"facets":[{"name":"web","results":[{"count":10},{"average":1.5},{"percentiles":{"95":3,"99":4}}]}],
+				"metadata":{"facet":"appName","contents":{"contents":[{"function":"count"},
+					{"function":"alias","alias":"avgDuration","contents":{"function":"average","attribute":"duration"}},
+					{"function":"percentile","attribute":"duration","thresholds":[95,99]}]}}}`,
+			expected: [][]string{
+				{"appName", "count", "avgDuration", "percentile.duration.95", "percentile.duration.99"},
+				{"web", "10", "1.5", "3", "4"},
+			},
+		},
+		{
+			name: "timeseries",
+			response: `{"timeSeries":[{"results":[{"sum":5},{"max":2}],"beginTimeSeconds":1700000000,"endTimeSeconds":1700000060},
+				{"results":[{"sum":7},{"max":3}],"beginTimeSeconds":1700000060,"endTimeSeconds":1700000120}],
+				"metadata":{"timeSeries":{"contents":[{"function":"sum","attribute":"bytes"},{"function":"max","attribute":"duration"}]}}}`,
+			expected: [][]string{
+				{TIMESTAMP_FIELD_NAME, "sum.bytes", "max.duration"},
+				{"1700000000", "5", "2"},
+				{"1700000060", "7", "3"},
+			},
+		},
+		{
+			name: "faceted timeseries",
+			response: `{"facets":[{"name":"web","timeSeries":[{"results":[{"count":1}],"beginTimeSeconds":1700000000}]},
+				{"name":"api","timeSeries":[{"results":[{"count":2}],"beginTimeSeconds":1700000000}]}],
+				"metadata":{"facet":"appName"}}`,
+			expected: [][]string{
+				{"appName", TIMESTAMP_FIELD_NAME, RESULT_FIELD_NAME},
+				{"web", "1700000000", "1"},
+				{"api", "1700000000", "2"},
+			},
+		},
+	}
+	service := &NewRelicService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := service.processJson(tt.response, "test_query")
+			if fmt.Sprint(result) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected %v, got: %v", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestProcessCsv_EmptyData(t *testing.T) {
 	csvData := ""
 
@@ -90,3 +345,974 @@ func TestCreateGraylogService(t *testing.T) {
 	// For a proper test, we'd need to mock the config
 	t.Skip("Skipping CreateGraylogService test - requires complex config setup")
 }
+
+func TestQueryOpenSearch_Scroll(t *testing.T) {
+	pages := []string{
+		`{"_scroll_id":"s1","hits":{"hits":[{"_id":"1","_source":{"level":"ERROR","http":{"status":500},"duration":12.5}},{"_id":"2","_source":{"level":"INFO","http.status":200,"duration":3}}]}}`,
+		`{"_scroll_id":"s2","hits":{"hits":[{"_id":"3","_source":{"level":"WARN","tags":["a","b"]}}]}}`,
+	}
+	requests := 0
+	scrollCleared := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/logs-*/_search":
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Error decoding search body: %v", err)
+			}
+			if body["size"].(float64) != 2 {
+				t.Errorf("Expected page size 2, got: %v", body["size"])
+			}
+			_, _ = w.Write([]byte(pages[0]))
+		case r.Method == "POST" && r.URL.Path == "/_search/scroll":
+			_, _ = w.Write([]byte(pages[1]))
+		case r.Method == "DELETE" && r.URL.Path == "/_search/scroll":
+			var body map[string][]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			scrollCleared = body["scroll_id"][0]
+		default:
+			t.Errorf("Unexpected request %v %v", r.Method, r.URL.Path)
+		}
+		requests++
+	}))
+	defer server.Close()
+
+	appConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{
+			"opensearch": {
+				TLSHostConfig: config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5},
+				Type:          "opensearch",
+				Index:         "logs-*",
+				PageSize:      2,
+			},
+		},
+		Queries: map[string]*config.QueryConfig{
+			"test_query": {
+				QueryString:   "level:*",
+				FieldsInOrder: []string{"_id", "level", "http.status", "duration", "tags"},
+			},
+		},
+	}
+
+	service := CreateOpenSearchService(appConfig, "opensearch")
+	result, _, errc, err := service.queryOpenSearch("test_query", time.Now().Add(-time.Minute), time.Now())
+	if err != nil || errc != "" {
+		t.Fatalf("Expected no error, got: %v (%v)", err, errc)
+	}
+	expected := [][]string{
+		{"_id", "level", "http.status", "duration", "tags"},
+		{"1", "ERROR", "500", "12.5", ""},
+		{"2", "INFO", "200", "3", ""},
+		{"3", "WARN", "", "", `["a","b"]`},
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d rows, got: %d (%+v)", len(expected), len(result), result)
+	}
+	for i := range expected {
+		for j := range expected[i] {
+			if result[i][j] != expected[i][j] {
+				t.Errorf("Expected value %q at [%d][%d], got: %q", expected[i][j], i, j, result[i][j])
+			}
+		}
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got: %d", requests)
+	}
+	if scrollCleared != "s2" {
+		t.Errorf("Expected scroll s2 to be cleared, got: %q", scrollCleared)
+	}
+}
+
+func newLokiPagingServer(t *testing.T, timestamps []int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("direction") != "forward" {
+			t.Errorf("Expected forward direction, got: %v", q.Get("direction"))
+		}
+		start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		values := make([][]string, 0)
+		for i, ts := range timestamps {
+			if ts >= start && ts < end && len(values) < limit {
+				values = append(values, []string{strconv.FormatInt(ts, 10), "line" + strconv.Itoa(i)})
+			}
+		}
+		response := LokiResponse{Status: "success", Data: LokiResponseData{ResultType: "streams"}}
+		if len(values) > 0 {
+			response.Data.Result, _ = json.Marshal([]LokiResponseDataResult{{Stream: map[string]string{"app": "test"}, Values: values}})
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestQueryLoki_Pagination(t *testing.T) {
+	server := newLokiPagingServer(t, []int64{1, 2, 3, 3, 3, 4, 5})
+	defer server.Close()
+
+	for _, tc := range []struct {
+		maxRecords int
+		expected   int
+		truncated  bool
+	}{
+		{maxRecords: 0, expected: 7, truncated: false},
+		{maxRecords: 4, expected: 4, truncated: true},
+	} {
+		appConfig := &config.Config{
+			Datasources: map[string]*config.DatasourceConfig{
+				"loki": {
+					TLSHostConfig: config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5},
+					Type:          "loki",
+					PageSize:      3,
+					MaxRecords:    tc.maxRecords,
+				},
+			},
+			Queries: map[string]*config.QueryConfig{
+				"test_query": {QueryString: `{app="test"}`},
+			},
+		}
+		service := CreateLokiService(appConfig, "loki")
+		records, _, errc, err := service.queryLoki("test_query", time.Unix(0, 0), time.Unix(0, 100))
+		if tc.truncated {
+			if !errors.Is(err, ErrResponseTruncated) || errc == "" {
+				t.Errorf("Expected truncation error with error code, got: %v (%v)", err, errc)
+			}
+		} else if err != nil {
+			t.Errorf("Expected no error, got: %v", err)
+		}
+		if len(records) != tc.expected+1 {
+			t.Fatalf("Expected %d records, got: %d (%+v)", tc.expected+1, len(records), records)
+		}
+		for i := 1; i < len(records); i++ {
+			if records[i][0] != "line"+strconv.Itoa(i-1) {
+				t.Errorf("Expected record %d to be line%d, got: %v", i, i-1, records[i][0])
+			}
+		}
+	}
+}
+
+func TestQueryLoki_MetricResults(t *testing.T) {
+	responses := map[string]string{
+		"/loki/api/v1/query":       `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"app":"a","level":"error"},"value":[1700000060.5,"12"]},{"metric":{"app":"b"},"value":[1700000060.5,"3"]}]}}`,
+		"/loki/api/v1/query_range": `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"app":"a"},"values":[[1700000000,"1"],[1700000030,"2"]]}]}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(responses[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		mode     string
+		expected [][]string
+	}{
+		{mode: "metrics", expected: [][]string{
+			{"app", "level", "_RESULT_"},
+			{"a", "error", "12"},
+			{"b", "", "3"},
+		}},
+		{mode: "", expected: [][]string{
+			{"app", "_TIMESTAMP_", "_RESULT_"},
+			{"a", "1700000000", "1"},
+			{"a", "1700000030", "2"},
+		}},
+	} {
+		appConfig := &config.Config{
+			Datasources: map[string]*config.DatasourceConfig{
+				"loki": {
+					TLSHostConfig: config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5},
+					Type:          "loki",
+					Mode:          tc.mode,
+				},
+			},
+			Queries: map[string]*config.QueryConfig{
+				"test_query": {QueryString: `sum by (app, level) (count_over_time({app=~".+"}[1m]))`},
+			},
+		}
+		service := CreateLokiService(appConfig, "loki")
+		records, _, errc, err := service.queryLoki("test_query", time.Unix(1700000000, 0), time.Unix(1700000060, 0))
+		if err != nil || errc != "" {
+			t.Fatalf("Expected no error for mode %q, got: %v (%v)", tc.mode, err, errc)
+		}
+		if len(records) != len(tc.expected) {
+			t.Fatalf("Expected %d records for mode %q, got: %d (%+v)", len(tc.expected), tc.mode, len(records), records)
+		}
+		for i := range tc.expected {
+			for j := range tc.expected[i] {
+				if records[i][j] != tc.expected[i][j] {
+					t.Errorf("Expected value %q at [%d][%d] for mode %q, got: %q", tc.expected[i][j], i, j, tc.mode, records[i][j])
+				}
+			}
+		}
+	}
+}
+
+func TestQueryVictoriaLogs_JsonLines(t *testing.T) {
+	jsonLines := `{"_msg":"request done","_time":"2024-01-01T00:00:01Z","level":"info","duration":"15"}
+{"_msg":"request failed","_time":"2024-01-01T00:00:02Z","level":"error"}
+{"_msg":"request done","_time":"2024-01-01T00:00:03Z","level":"info","duration":"20"}
+`
+	var receivedQuery, receivedLimit string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/select/logsql/query" {
+			t.Errorf("Unexpected path %v", r.URL.Path)
+		}
+		receivedQuery = r.FormValue("query")
+		receivedLimit = r.FormValue("limit")
+		_, _ = w.Write([]byte(jsonLines))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		maxRecords int
+		expected   [][]string
+		truncated  bool
+	}{
+		{
+			name: "all entries",
+			expected: [][]string{
+				{"level", "_msg", "_time", "duration"},
+				{"info", "request done", "2024-01-01T00:00:01Z", "15"},
+				{"error", "request failed", "2024-01-01T00:00:02Z", ""},
+				{"info", "request done", "2024-01-01T00:00:03Z", "20"},
+			},
+		},
+		{
+			name:       "max-records exceeded",
+			maxRecords: 1,
+			expected: [][]string{
+				{"level", "_msg", "_time", "duration"},
+				{"info", "request done", "2024-01-01T00:00:01Z", "15"},
+			},
+			truncated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appConfig := &config.Config{
+				Datasources: map[string]*config.DatasourceConfig{
+					"victorialogs": {
+						TLSHostConfig: config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5},
+						Type:          "victorialogs",
+						MaxRecords:    tt.maxRecords,
+					},
+				},
+				Queries: map[string]*config.QueryConfig{"test_query": {
+					Datasource:    "victorialogs",
+					QueryString:   `_stream:{app="{{.QueryName}}"}`,
+					FieldsInOrder: []string{"level"},
+				}},
+			}
+			service := CreateVictoriaLogsService(appConfig, "victorialogs")
+			result, _, errc, err := service.queryVictoriaLogs("test_query", time.Now().Add(-time.Minute), time.Now())
+			if tt.truncated {
+				if !errors.Is(err, ErrResponseTruncated) || errc != "LME-7182" {
+					t.Errorf("Expected truncated response error with LME-7182, got: %v (%v)", err, errc)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, got: %v (%v)", err, errc)
+			}
+			if receivedQuery != `_stream:{app="test_query"}` {
+				t.Errorf("Unexpected query %v", receivedQuery)
+			}
+			if receivedLimit != strconv.Itoa(service.maxRecords+1) {
+				t.Errorf("Unexpected limit %v", receivedLimit)
+			}
+			if fmt.Sprint(result) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected %v, got: %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestQueryClickHouse_QueryParameters(t *testing.T) {
+	csvData := "level,cnt\ninfo,10\nerror,2\n"
+	var receivedQuery string
+	var receivedParams url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receivedQuery = string(body)
+		receivedParams = r.URL.Query()
+		user, password, _ := r.BasicAuth()
+		if user != "default" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(csvData))
+	}))
+	defer server.Close()
+
+	queryString := "SELECT level, count() AS cnt FROM logs WHERE timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp < {end:DateTime64(3, 'UTC')} GROUP BY level"
+	appConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{
+			"clickhouse": {
+				TLSHostConfig: config.TLSHostConfig{Host: server.URL, User: "default", Password: "secret", ConnectionTimeout: time.Second * 5},
+				Type:          "clickhouse",
+			},
+		},
+		Queries: map[string]*config.QueryConfig{"test_query": {Datasource: "clickhouse", QueryString: queryString}},
+	}
+	startTime := time.Date(2024, 1, 1, 3, 0, 0, 0, time.FixedZone("UTC+3", 3*3600))
+	endTime := startTime.Add(time.Minute)
+
+	service := CreateClickHouseService(appConfig, "clickhouse")
+	result, _, errc, err := service.queryClickHouse("test_query", startTime, endTime)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v (%v)", err, errc)
+	}
+	if receivedQuery != queryString {
+		t.Errorf("Expected the query to be sent as is, got: %v", receivedQuery)
+	}
+	expectedParams := map[string]string{
+		"default_format":   "CSVWithNames",
+		"param_start":      "2024-01-01 00:00:00.000",
+		"param_end":        "2024-01-01 00:01:00.000",
+		"param_start_unix": "1704067200",
+		"param_end_unix":   "1704067260",
+	}
+	for name, value := range expectedParams {
+		if receivedParams.Get(name) != value {
+			t.Errorf("Expected parameter %v to be %v, got: %v", name, value, receivedParams.Get(name))
+		}
+	}
+	expected := [][]string{{"level", "cnt"}, {"info", "10"}, {"error", "2"}}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got: %v", expected, result)
+	}
+}
+
+func TestFileService_TailOffsets(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	offsetsPath := filepath.Join(dir, "offsets.json")
+	appendLines := func(path string, lines string) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatalf("Error opening file %v : %+v", path, err)
+		}
+		defer file.Close()
+		if _, err = file.WriteString(lines); err != nil {
+			t.Fatalf("Error writing file %v : %+v", path, err)
+		}
+	}
+	appendLines(logPath, "{\"level\":\"info\",\"msg\":\"old line\"}\n")
+
+	appConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{
+			"files": {Type: "file", Path: filepath.Join(dir, "*.log"), Format: "json", OffsetsFile: offsetsPath},
+		},
+		Queries: map[string]*config.QueryConfig{"test_query": {Datasource: "files", FieldsInOrder: []string{"level"}}},
+	}
+	query := func(service *FileService) [][]string {
+		result, _, errc, err := service.queryFiles("test_query")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v (%v)", err, errc)
+		}
+		service.Commit("test_query")
+		return result
+	}
+
+	service := CreateFileService(appConfig, "files")
+	if result := query(service); len(result) != 0 {
+		t.Errorf("Expected existing lines to be skipped on the first start, got: %v", result)
+	}
+
+	appendLines(logPath, "{\"level\":\"error\",\"http\":{\"status\":500}}\nnot a json\n{\"level\":\"partial\"")
+	expected := [][]string{
+		{"level", FILE_FIELD_NAME, "http.status", "message"},
+		{"error", logPath, "500", ""},
+		{"", logPath, "", "not a json"},
+	}
+	if result := query(service); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got: %v", expected, result)
+	}
+
+	// the restarted service continues from the stored offsets, the incomplete line is read once it is completed
+	appendLines(logPath, "}\n")
+	service = CreateFileService(appConfig, "files")
+	expected = [][]string{{"level", FILE_FIELD_NAME}, {"partial", logPath}}
+	if result := query(service); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v after restart, got: %v", expected, result)
+	}
+
+	// the lines of the result which is not committed are read again after the restart
+	appendLines(logPath, "{\"level\":\"lost\"}\n")
+	if _, _, errc, err := service.queryFiles("test_query"); err != nil {
+		t.Fatalf("Expected no error, got: %v (%v)", err, errc)
+	}
+	service = CreateFileService(appConfig, "files")
+	expected = [][]string{{"level", FILE_FIELD_NAME}, {"lost", logPath}}
+	if result := query(service); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v after restart without commit, got: %v", expected, result)
+	}
+
+	// the truncated file and the new file are read from the beginning
+	if err := os.WriteFile(logPath, []byte("{\"level\":\"rotated\"}\n"), 0600); err != nil {
+		t.Fatalf("Error truncating file : %+v", err)
+	}
+	newPath := filepath.Join(dir, "new.log")
+	appendLines(newPath, "{\"level\":\"new\"}\n")
+	expected = [][]string{{"level", FILE_FIELD_NAME}, {"rotated", logPath}, {"new", newPath}}
+	if result := query(service); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v after rotation, got: %v", expected, result)
+	}
+
+	// the file renamed by the rotation is read further from its offset, the line over the max size is skipped
+	appendLines(logPath, "{\"level\":\"before rename\"}\n")
+	rotatedPath := filepath.Join(dir, "app-1.log")
+	if err := os.Rename(logPath, rotatedPath); err != nil {
+		t.Fatalf("Error renaming file : %+v", err)
+	}
+	appendLines(logPath, "{\"level\":\"after rename\"}\n"+strings.Repeat("x", FILE_MAX_LINE_SIZE+1)+"\n{\"level\":\"after long line\"}\n")
+	expected = [][]string{{"level", FILE_FIELD_NAME}, {"before rename", rotatedPath}, {"after rename", logPath}, {"after long line", logPath}}
+	if result := query(service); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v after rename, got: %v", expected, result)
+	}
+}
+
+func TestResponseRecorder_RecordAndReplay(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = fmt.Fprintf(w, "level,cnt\ninfo,%d\n", requests)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	queries := map[string]*config.QueryConfig{"test_query": {Datasource: "clickhouse", QueryString: "SELECT level, count() AS cnt FROM logs GROUP BY level"}}
+	appConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{"clickhouse": {
+			TLSHostConfig: config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5},
+			Type:          "clickhouse",
+			Record:        &config.RecordConfig{Path: dir, MaxFiles: 2},
+		}},
+		Queries: queries,
+	}
+	service := CreateClickHouseService(appConfig, "clickhouse")
+	recorder := GetResponseRecorder(appConfig, "clickhouse")
+	if GetResponseRecorder(appConfig, "clickhouse") != recorder {
+		t.Errorf("Expected the recorder to be shared by the datasource and the calls processor")
+	}
+	call := func(startTime time.Time, endTime time.Time, succeeded bool) [][]string {
+		recorder.BeginCall("test_query", startTime, endTime)
+		result, errc, err := service.Query("test_query", startTime, endTime)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v (%v)", err, errc)
+		}
+		recorder.EndCall("test_query", succeeded)
+		return result
+	}
+
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	expected := make([][][][]string, 0)
+	for i := 0; i < 3; i++ {
+		windowStart := start.Add(time.Duration(i) * time.Minute)
+		windowEnd := windowStart.Add(time.Minute)
+		middle := windowStart.Add(30 * time.Second)
+		recorder.BeginExecution("test_query")
+		// the failed call and the call of the split time range are not used by the execution
+		call(windowStart, windowEnd, false)
+		call(windowStart, windowEnd, true)
+		expected = append(expected, [][][]string{call(windowStart, middle, true), call(middle, windowEnd, true)})
+		recorder.Record("test_query", windowStart, windowEnd)
+	}
+
+	windows, err := ListRecordedWindows(dir, "test_query")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(windows) != 2 || !windows[0].StartTime.Equal(start.Add(time.Minute)) || !windows[1].EndTime.Equal(start.Add(3*time.Minute)) {
+		t.Fatalf("Expected the two latest windows, got: %+v", windows)
+	}
+
+	replayConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{"clickhouse": {
+			TLSHostConfig: config.TLSHostConfig{Host: "http://127.0.0.1:1", ConnectionTimeout: time.Second * 5},
+			Type:          "clickhouse",
+		}},
+		Queries: queries,
+	}
+	player := NewResponsePlayer(replayConfig, "clickhouse")
+	replayService := CreateClickHouseService(replayConfig, "clickhouse")
+	for i, window := range windows {
+		calls, err := ReadRecordedWindow(window)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(calls) != 2 || !calls[0].StartTime.Equal(window.StartTime) || !calls[1].EndTime.Equal(window.EndTime) {
+			t.Fatalf("Expected the calls of the two halves of the window, got: %+v", calls)
+		}
+		for j, recordedCall := range calls {
+			player.Play("test_query", recordedCall.Responses)
+			result, errc, err := replayService.Query("test_query", recordedCall.StartTime, recordedCall.EndTime)
+			if err != nil || fmt.Sprint(result) != fmt.Sprint(expected[i+1][j]) {
+				t.Errorf("Expected %v, got: %v (%v, %v)", expected[i+1][j], result, errc, err)
+			}
+		}
+	}
+	if _, _, err = replayService.Query("test_query", start, start.Add(time.Minute)); err == nil {
+		t.Errorf("Expected error when no recorded responses are left")
+	}
+
+	if windows, err = ListRecordedWindows(dir, "unknown_query"); err != nil || len(windows) != 0 {
+		t.Errorf("Expected no windows for the query without archive, got: %v, %v", windows, err)
+	}
+	if recorder = GetResponseRecorder(&config.Config{Datasources: map[string]*config.DatasourceConfig{"graylog": {}}}, "graylog"); recorder != nil {
+		t.Errorf("Expected no recorder if record is not configured")
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected map[string]string
+	}{
+		{`level=info msg="request done" duration=15 cached`, map[string]string{"level": "info", "msg": "request done", "duration": "15", "cached": "true"}},
+		{`msg="quoted \"value\"" empty=`, map[string]string{"msg": `quoted "value"`, "empty": ""}},
+		{`plain text line`, nil},
+	}
+	for _, tt := range tests {
+		if result := parseLogfmt(tt.line); fmt.Sprint(result) != fmt.Sprint(tt.expected) {
+			t.Errorf("For line %v expected %v, got: %v", tt.line, tt.expected, result)
+		}
+	}
+}
+
+func TestReceiverService_JsonLinesConditions(t *testing.T) {
+	appConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{
+			"shippers": {Type: "receiver", HttpAddress: ":0", TimestampField: "time"},
+		},
+		Queries: map[string]*config.QueryConfig{
+			"errors": {Datasource: "shippers", FieldsInOrder: []string{"level"}, Cond: []map[string]map[string]string{{"equ": {"level": "error"}}}},
+			"all":    {Datasource: "shippers"},
+		},
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC)
+	service := CreateReceiverService(appConfig, "shippers")
+	service.now = func() time.Time { return now }
+
+	body := "{\"level\":\"error\",\"time\":\"2024-05-01T09:59:50Z\",\"k8s\":{\"pod\":\"a\"}}\n" +
+		"not a json\n" +
+		"{\"level\":\"info\",\"time\":1714557650000}\n" +
+		"{\"level\":\"error\"}\n"
+	recorder := httptest.NewRecorder()
+	service.jsonLinesHandler(recorder, httptest.NewRequest(http.MethodPost, RECEIVER_JSON_LINES_ENDPOINT, strings.NewReader(body)))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %v, got: %v", http.StatusNoContent, recorder.Code)
+	}
+
+	// the record without timestamp gets the receive time, which is after the end of the first window
+	windowEnd := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	expected := [][]string{{"level", "k8s.pod", "time"}, {"error", "a", "2024-05-01T09:59:50Z"}}
+	result, errc, err := service.takeRecords("errors", windowEnd)
+	if err != nil || fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got: %v (%v, %v)", expected, result, errc, err)
+	}
+	expected = [][]string{{"level"}, {"error"}}
+	result, _, _ = service.takeRecords("errors", windowEnd.Add(time.Minute))
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v for the next window, got: %v", expected, result)
+	}
+	result, _, _ = service.takeRecords("all", windowEnd.Add(time.Minute))
+	if len(result) != 4 {
+		t.Errorf("Expected 3 records for the query without conditions, got: %v", result)
+	}
+
+	// the request which can not be read completely is rejected as a whole
+	body = "{\"level\":\"error\"}\n" + strings.Repeat("x", RECEIVER_MAX_LINE_SIZE+1) + "\n"
+	recorder = httptest.NewRecorder()
+	service.jsonLinesHandler(recorder, httptest.NewRequest(http.MethodPost, RECEIVER_JSON_LINES_ENDPOINT, strings.NewReader(body)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %v for the oversized line, got: %v", http.StatusBadRequest, recorder.Code)
+	}
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, _ = gzipWriter.Write([]byte("{\"level\":\"error\"}\n"))
+	line := append(bytes.Repeat([]byte(" "), 64*1024), '\n')
+	for written := 0; written <= RECEIVER_MAX_REQUEST_SIZE; written += len(line) {
+		_, _ = gzipWriter.Write(line)
+	}
+	_ = gzipWriter.Close()
+	request := httptest.NewRequest(http.MethodPost, RECEIVER_JSON_LINES_ENDPOINT, &compressed)
+	request.Header.Set("Content-Encoding", "gzip")
+	recorder = httptest.NewRecorder()
+	service.jsonLinesHandler(recorder, request)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %v for the decompressed body over the limit, got: %v", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+	if result, _, _ = service.takeRecords("errors", windowEnd.Add(time.Hour)); len(result) != 0 {
+		t.Errorf("Expected no records from the rejected requests, got: %v rows", len(result))
+	}
+
+	service.maxRecords = 1
+	service.addRecord(now, map[string]string{"level": "info"})
+	service.addRecord(now, map[string]string{"level": "info"})
+	result, errc, err = service.takeRecords("all", windowEnd.Add(time.Hour))
+	if len(result) != 2 || errc != "LME-7212" || !errors.Is(err, ErrResponseTruncated) {
+		t.Errorf("Expected 1 record and the dropped records error, got: %v (%v, %v)", result, errc, err)
+	}
+}
+
+func TestParseSyslogMessage(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		message   string
+		timestamp time.Time
+		expected  map[string]string
+	}{
+		{
+			`<165>1 2024-01-02T09:59:58.003Z host1 app 1234 ID47 [origin@123 ip="10.0.0.1" note="a \"b\""][meta x="1"] ` + "\ufeff" + `request failed`,
+			time.Date(2024, 1, 2, 9, 59, 58, 3000000, time.UTC),
+			map[string]string{"facility": "local4", "severity": "notice", "hostname": "host1", "appname": "app", "procid": "1234", "msgid": "ID47", "timestamp": "2024-01-02T09:59:58.003Z",
+				"origin@123.ip": "10.0.0.1", "origin@123.note": `a "b"`, "meta.x": "1", "message": "request failed"},
+		},
+		{
+			`<11>1 - - - - - - started`,
+			now,
+			map[string]string{"facility": "user", "severity": "err", "message": "started"},
+		},
+		{
+			`<34>Dec 31 23:59:59 host2 sshd[42]: login failed`,
+			time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC),
+			map[string]string{"facility": "auth", "severity": "crit", "hostname": "host2", "appname": "sshd", "procid": "42", "message": "login failed"},
+		},
+	}
+	for _, tt := range tests {
+		timestamp, fields, err := parseSyslogMessage(tt.message, now)
+		if err != nil {
+			t.Errorf("For message %v expected no error, got: %v", tt.message, err)
+			continue
+		}
+		if !timestamp.Equal(tt.timestamp) || fmt.Sprint(fields) != fmt.Sprint(tt.expected) {
+			t.Errorf("For message %v expected %v %v, got: %v %v", tt.message, tt.timestamp, tt.expected, timestamp, fields)
+		}
+	}
+	for _, message := range []string{"no pri", "<-1>x", "<+1>x", "<192>x"} {
+		if _, _, err := parseSyslogMessage(message, now); err == nil {
+			t.Errorf("Expected error for the message with incorrect PRI %v", message)
+		}
+	}
+}
+
+func TestReadSyslogFrames(t *testing.T) {
+	stream := "11 <13>1 - - -" + "<14>legacy line\n" + "\n" + "9 <13>first"
+	messages := make([]string, 0)
+	err := readSyslogFrames(strings.NewReader(stream), func(message string) {
+		messages = append(messages, strings.TrimRight(message, "\n"))
+	})
+	expected := []string{"<13>1 - - -", "<14>legacy line", "<13>first"}
+	if err != nil || fmt.Sprint(messages) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got: %q (%v)", expected, messages, err)
+	}
+
+	for _, stream := range []string{"99999999999999999999 <13>x", "0 <13>x", "12a <13>x", "<14>" + strings.Repeat("x", SYSLOG_MAX_MESSAGE_SIZE) + "\n"} {
+		if err := readSyslogFrames(strings.NewReader(stream), func(message string) {}); err == nil {
+			t.Errorf("Expected error for the stream %.40q", stream)
+		}
+	}
+}
+
+func TestReceiverService_OtlpLogs(t *testing.T) {
+	appConfig := &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{"otel": {Type: "receiver", HttpAddress: ":0"}},
+		Queries: map[string]*config.QueryConfig{
+			"checkout": {Datasource: "otel", FieldsInOrder: []string{"severity_text"}, Cond: []map[string]map[string]string{{"equ": {"resource.service.name": "checkout"}}}},
+		},
+	}
+	service := CreateReceiverService(appConfig, "otel")
+	send := func(contentType string, body []byte) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, RECEIVER_OTLP_LOGS_ENDPOINT, strings.NewReader(string(body)))
+		request.Header.Set("Content-Type", contentType)
+		service.otlpLogsHandler(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status %v for %v, got: %v %v", http.StatusOK, contentType, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	message := func(fields ...[]byte) []byte {
+		var result []byte
+		for _, field := range fields {
+			result = append(result, field...)
+		}
+		return result
+	}
+	bytesField := func(num protowire.Number, value []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), value)
+	}
+	stringKeyValue := func(key string, value string) []byte {
+		return message(bytesField(1, []byte(key)), bytesField(2, bytesField(1, []byte(value))))
+	}
+	intAnyValue := protowire.AppendVarint(protowire.AppendTag(nil, 3, protowire.VarintType), 42)
+	logRecord := message(
+		protowire.AppendFixed64(protowire.AppendTag(nil, 1, protowire.Fixed64Type), uint64(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).UnixNano())),
+		protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 17),
+		bytesField(3, []byte("ERROR")),
+		bytesField(5, bytesField(6, bytesField(1, stringKeyValue("reason", "timeout")))),
+		bytesField(6, message(bytesField(1, []byte("retries")), bytesField(2, intAnyValue))),
+		bytesField(9, []byte{0x01, 0xab}),
+		protowire.AppendVarint(protowire.AppendTag(nil, 7, protowire.VarintType), 0),
+	)
+	scopeLogs := message(bytesField(1, message(bytesField(1, []byte("app.logger")), bytesField(2, []byte("1.0")))), bytesField(2, logRecord))
+	request := bytesField(1, message(bytesField(1, bytesField(1, stringKeyValue("service.name", "checkout"))), bytesField(2, scopeLogs)))
+	send("application/x-protobuf", request)
+
+	jsonRequest := `{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeLogs":[{"logRecords":[{"observedTimeUnixNano":"1714557601000000000","severityText":"INFO","body":{"stringValue":"paid"},
+		"attributes":[{"key":"amount","value":{"doubleValue":9.5}},{"key":"tags","value":{"arrayValue":{"values":[{"intValue":"1"},{"boolValue":true}]}}}]}]}]},
+		{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"cart"}}]},"scopeLogs":[{"logRecords":[{"severityText":"INFO"}]}]}]}`
+	send("application/json", []byte(jsonRequest))
+
+	expected := [][]string{
+		{"severity_text", "amount", "body", "body.reason", "resource.service.name", "retries", "scope.name", "scope.version", "severity_number", "tags", "timestamp", "trace_id"},
+		{"ERROR", "", "", "timeout", "checkout", "42", "app.logger", "1.0", "17", "", "2024-05-01T10:00:00Z", "01ab"},
+		{"INFO", "9.5", "paid", "", "checkout", "", "", "", "", "[1,true]", "2024-05-01T10:00:01Z", ""},
+	}
+	result, errc, err := service.takeRecords("checkout", time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC))
+	if err != nil || fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got: %v (%v, %v)", expected, result, errc, err)
+	}
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, _ = gzipWriter.Write(make([]byte, RECEIVER_MAX_REQUEST_SIZE+1))
+	_ = gzipWriter.Close()
+	httpRequest := httptest.NewRequest(http.MethodPost, "/v1/logs", &compressed)
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	httpRequest.Header.Set("Content-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	service.otlpLogsHandler(recorder, httpRequest)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %v for the decompressed body over the limit, got: %v", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+}
+
+func TestParseOtlpProtoAnyValue_Depth(t *testing.T) {
+	nested := func(depth int) []byte {
+		value := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), []byte("leaf"))
+		for i := 0; i < depth; i++ {
+			item := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), value)
+			value = protowire.AppendBytes(protowire.AppendTag(nil, 5, protowire.BytesType), item)
+		}
+		return value
+	}
+	if _, err := parseOtlpProtoAnyValue(nested(OTLP_MAX_VALUE_DEPTH), 0); err != nil {
+		t.Errorf("Expected no error for the value nested %v levels, got: %v", OTLP_MAX_VALUE_DEPTH, err)
+	}
+	if _, err := parseOtlpProtoAnyValue(nested(OTLP_MAX_VALUE_DEPTH+1), 0); err == nil {
+		t.Errorf("Expected error for the value nested %v levels", OTLP_MAX_VALUE_DEPTH+1)
+	}
+}
+
+func TestDatasourceLimiter(t *testing.T) {
+	if newDatasourceLimiter("ds", &config.DatasourceConfig{}) != nil {
+		t.Error("Expected no limiter without limits")
+	}
+
+	limiter := newDatasourceLimiter("ds", &config.DatasourceConfig{RequestsPerSecond: 2})
+	now := time.Unix(1700000000, 0)
+	sleeps := []time.Duration{}
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+	}
+	for i := 0; i < 3; i++ {
+		limiter.acquire()()
+	}
+	now = now.Add(5 * time.Second)
+	limiter.acquire()()
+	if fmt.Sprint(sleeps) != "[500ms 500ms]" {
+		t.Errorf("Expected requests to be spread by 500ms, got sleeps %v", sleeps)
+	}
+
+	limiter = newDatasourceLimiter("ds", &config.DatasourceConfig{MaxConcurrentRequests: 1})
+	release := limiter.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		limiter.acquire()()
+		close(acquired)
+	}()
+	for limiter.queued.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second request to wait for the first one")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second request to be allowed after the first one is completed")
+	}
+}
+
+func TestDatasourceLimiter_Do(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	limiter := newDatasourceLimiter("ds", &config.DatasourceConfig{MaxConcurrentRequests: 1})
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := limiter.do(server.Client(), req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(limiter.slots) != 1 {
+			t.Errorf("Expected the request to hold the slot until the body is closed, got %v slots", len(limiter.slots))
+		}
+		_ = resp.Body.Close()
+		_ = resp.Body.Close()
+		if len(limiter.slots) != 0 {
+			t.Errorf("Expected the slot to be released once the body is closed, got %v slots", len(limiter.slots))
+		}
+	}
+}
+
+func TestGetHTTPClient_ReusesConnections(t *testing.T) {
+	var newConnections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConnections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	hostConfig := &config.TLSHostConfig{Host: server.URL, ConnectionTimeout: time.Second * 5, MaxIdleConnsPerHost: 1}
+	client := GetHTTPClient(hostConfig)
+	if GetHTTPClient(hostConfig) != client {
+		t.Error("Expected the same client to be returned for the same host config")
+	}
+	if GetHTTPClient(&config.TLSHostConfig{Host: server.URL}) == client {
+		t.Error("Expected different clients to be returned for different host configs")
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+	if newConnections.Load() != 1 {
+		t.Errorf("Expected 1 connection to be opened, got: %d", newConnections.Load())
+	}
+}
+
+func TestGetHTTPClient_Auth(t *testing.T) {
+	var tokenRequests atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "read write" || user != "lme" || password != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%v","token_type":"Bearer","expires_in":3600}`, tokenRequests.Add(1))
+	}))
+	defer tokenServer.Close()
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("X-Scope-OrgID")+" "+r.Header.Get("Authorization"))
+		if len(authorizations) == 2 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	get := func(client *http.Client) {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.SetBasicAuth("user", "password")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	client := GetHTTPClient(&config.TLSHostConfig{Host: server.URL, Auth: &config.AuthConfig{
+		Headers: map[string]string{"X-Scope-OrgID": "tenant"},
+		OAuth2:  &config.OAuth2Config{TokenUrl: tokenServer.URL, ClientId: "lme", ClientSecret: "secret", Scopes: []string{"read", "write"}},
+	}})
+	for i := 0; i < 3; i++ {
+		get(client)
+	}
+	// the cached token is used until the host responds with 401
+	expected := "[tenant Bearer token-1 tenant Bearer token-1 tenant Bearer token-2]"
+	if fmt.Sprint(authorizations) != expected {
+		t.Errorf("Expected %v, got: %v", expected, authorizations)
+	}
+	// the token endpoint does not use the transport of the host with its TLS settings and connections tracing
+	if tokenClient := client.Transport.(*authRoundTripper).token.(*oauth2TokenSource).client; tokenClient.Transport != nil {
+		t.Errorf("Expected the token client to use the default transport, got: %T", tokenClient.Transport)
+	}
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("file-token-1\n"), 0600); err != nil {
+		t.Fatalf("Error writing token file : %+v", err)
+	}
+	authorizations = nil
+	client = GetHTTPClient(&config.TLSHostConfig{Host: server.URL, Auth: &config.AuthConfig{BearerTokenFile: tokenPath}})
+	get(client)
+	if err := os.WriteFile(tokenPath, []byte("file-token-22\n"), 0600); err != nil {
+		t.Fatalf("Error writing token file : %+v", err)
+	}
+	get(client)
+	expected = "[ Bearer file-token-1  Bearer file-token-22]"
+	if fmt.Sprint(authorizations) != expected {
+		t.Errorf("Expected %v, got: %v", expected, authorizations)
+	}
+}
+
+func TestPromRWService_NativeHistogram(t *testing.T) {
+	schema, zeroThreshold, count, zeroCount, sum, timestamp := int32(3), 0.001, uint64(4), uint64(1), 7.5, int64(1700000000000)
+	offset, length := int32(2), uint32(2)
+	name, labelName, labelValue := "native_histogram", "path", "/api"
+	metricFamily := &dto.MetricFamily{
+		Name: &name,
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{{Name: &labelName, Value: &labelValue}},
+			Histogram: &dto.Histogram{
+				SampleCount:   &count,
+				SampleSum:     &sum,
+				Schema:        &schema,
+				ZeroThreshold: &zeroThreshold,
+				ZeroCount:     &zeroCount,
+				PositiveSpan:  []*dto.BucketSpan{{Offset: &offset, Length: &length}},
+				PositiveDelta: []int64{1, 1},
+			},
+			TimestampMs: &timestamp,
+		}},
+	}
+
+	p := NewPromWRService(&config.ExportConfig{})
+	timeSeries := p.getProtoData([]*dto.MetricFamily{metricFamily})
+	if len(timeSeries) != 1 || len(timeSeries[0].Samples) != 0 || len(timeSeries[0].Histograms) != 1 {
+		t.Fatalf("Expected one time series with one histogram sample, got: %+v", timeSeries)
+	}
+	if len(timeSeries[0].Labels) != 2 || timeSeries[0].Labels[0].Value != name || timeSeries[0].Labels[1].Value != labelValue {
+		t.Errorf("Unexpected labels: %+v", timeSeries[0].Labels)
+	}
+	histogram := timeSeries[0].Histograms[0]
+	if histogram.GetCountInt() != count || histogram.GetZeroCountInt() != zeroCount || histogram.Sum != sum || histogram.Timestamp != timestamp {
+		t.Errorf("Unexpected histogram sample: %+v", histogram)
+	}
+	if histogram.Schema != schema || histogram.ZeroThreshold != zeroThreshold {
+		t.Errorf("Expected schema %v and zero threshold %v, got: %v and %v", schema, zeroThreshold, histogram.Schema, histogram.ZeroThreshold)
+	}
+	if len(histogram.PositiveSpans) != 1 || histogram.PositiveSpans[0].Offset != offset || histogram.PositiveSpans[0].Length != length || len(histogram.PositiveDeltas) != 2 {
+		t.Errorf("Unexpected positive buckets: %+v, %+v", histogram.PositiveSpans, histogram.PositiveDeltas)
+	}
+	if len(histogram.NegativeSpans) != 0 {
+		t.Errorf("Unexpected negative spans: %+v", histogram.NegativeSpans)
+	}
+}
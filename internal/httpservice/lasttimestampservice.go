@@ -21,7 +21,6 @@ import (
 	"log_exporter/internal/config"
 	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
-	"net"
 	"net/http"
 	"reflect"
 	"strconv"
@@ -68,16 +67,7 @@ func (l *LastTimestampService) GetLastTimestampUnixTime(qName string, queryConfi
 	}
 	log.Infof("LastTimestampService : For query %v lastTimestampURL : %v , jsonPath : %v ", qName, lastTimestampURL, jsonPath)
 
-	var transport http.RoundTripper = &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: l.LastTimestampHost.ConnectionTimeout,
-		}).DialContext,
-		TLSClientConfig: l.LastTimestampHost.TlsConfig,
-	}
-	client := http.Client{
-		Transport: transport,
-		Timeout:   l.LastTimestampHost.ConnectionTimeout,
-	}
+	client := GetHTTPClient(&l.LastTimestampHost.TLSHostConfig)
 
 	req, err := http.NewRequest("GET", lastTimestampURL, nil)
 	if err != nil {
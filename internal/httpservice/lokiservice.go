@@ -16,22 +16,37 @@ package httpservice
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log_exporter/internal/config"
 	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
-	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	LOKI_PAGE_SIZE_DEFAULT    = 5000
+	LOKI_MAX_RECORDS_DEFAULT  = 100000
+	LOKI_MODE_LOGS            = "logs"
+	LOKI_MODE_METRICS         = "metrics"
+	LOKI_TIMESTAMP_FIELD_NAME = "_TIMESTAMP_"
+)
+
 type LokiService struct {
-	appConfig *config.Config
-	dsConfig  *config.DatasourceConfig
+	appConfig   *config.Config
+	dsConfig    *config.DatasourceConfig
+	pageSize    int
+	maxRecords  int
+	metricsMode bool
+	transport   *datasourceTransport
 }
 
 type LokiResponse struct {
@@ -41,78 +56,232 @@ type LokiResponse struct {
 
 type LokiResponseData struct {
 	ResultType string
-	Result     []LokiResponseDataResult
+	Result     json.RawMessage
 }
 
+// LokiResponseDataResult is the item of the "streams" result
 type LokiResponseDataResult struct {
 	Stream map[string]string
 	Values [][]string
 }
 
-func CreateLokiService(appConfig *config.Config) *LokiService {
+// LokiMetricResult is the item of the "vector" (Value is set) or "matrix" (Values are set) result
+type LokiMetricResult struct {
+	Metric map[string]string
+	Value  []interface{}
+	Values [][]interface{}
+}
+
+func CreateLokiService(appConfig *config.Config, dsName string) *LokiService {
 	g := LokiService{}
 	g.appConfig = appConfig
-	g.dsConfig = appConfig.Datasources[appConfig.DsName]
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.transport = newDatasourceTransport(appConfig, dsName)
+	g.pageSize = g.dsConfig.PageSize
+	if g.pageSize <= 0 {
+		g.pageSize = LOKI_PAGE_SIZE_DEFAULT
+	}
+	g.maxRecords = g.dsConfig.MaxRecords
+	if g.maxRecords <= 0 {
+		g.maxRecords = LOKI_MAX_RECORDS_DEFAULT
+	}
+	g.metricsMode = strings.ToLower(g.dsConfig.Mode) == LOKI_MODE_METRICS
+	log.Infof("LokiService : Service created with page size %v, max records %v and metrics mode %v", g.pageSize, g.maxRecords, g.metricsMode)
 	return &g
 }
 
+// Capabilities disables splitting in the metrics mode, the metric query results of the sub-ranges can not be merged
+func (g *LokiService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{Retries: true, TimerangeSplitting: !g.metricsMode}
+}
+
 func (g *LokiService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
 	now := time.Now()
 	var err error
 	defer func() {
 		log.Debugf("LokiService : For query %v request executed and json is processed in %+v", qName, time.Since(now))
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrResponseTruncated) {
 			selfMonitorIncErrorCodeCount(qName, now)
 		} else {
 			selfMonitorRefreshErrorCodeCount(qName, now)
 		}
 	}()
 
-	stringResult, errc, err := g.queryLoki(qName, startTime, endTime)
+	records, responseSize, errc, err := g.queryLoki(qName, startTime, endTime)
 	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
-	selfMonitorObserveQueryResponseSize(float64(len(stringResult)), qName, now)
-	if err != nil {
+	selfMonitorObserveQueryResponseSize(float64(responseSize), qName, now)
+	if errors.Is(err, ErrResponseTruncated) {
+		selfMonitorIncResponseTruncatedCount(qName, now)
+	} else if err != nil {
 		return make([][]string, 0), errc, err
+	} else {
+		selfMonitorRefreshResponseTruncatedCount(qName, now)
 	}
 
-	result, errc, err := g.processJson(stringResult, qName)
-	return result, errc, err
+	return records, errc, err
 }
 
-func (g *LokiService) queryLoki(qName string, startTime time.Time, endTime time.Time) (string, string, error) {
-	qCfg := g.appConfig.Queries[qName]
+func (g *LokiService) queryLoki(qName string, startTime time.Time, endTime time.Time) ([][]string, int, string, error) {
+	client := GetHTTPClient(&g.dsConfig.TLSHostConfig)
 
-	var transport http.RoundTripper = &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: g.dsConfig.ConnectionTimeout,
-		}).DialContext,
-		TLSClientConfig: g.dsConfig.TlsConfig,
+	if g.metricsMode {
+		return g.queryLokiInstant(client, qName, startTime, endTime)
 	}
-	client := http.Client{
-		Transport: transport,
-		Timeout:   g.dsConfig.ConnectionTimeout,
+	return g.queryLokiRange(client, qName, startTime, endTime)
+}
+
+// queryLokiInstant executes the metric query at the end of the time range, so the range of the LogQL range aggregation
+// (for example, count_over_time({app="test"}[1m])) is expected to be equal to the timerange of the query
+func (g *LokiService) queryLokiInstant(client *http.Client, qName string, startTime time.Time, endTime time.Time) ([][]string, int, string, error) {
+	queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, LOKI_TEMPLATE_TIME_LAYOUT)
+	if err != nil {
+		return nil, 0, ec.LME_8102, fmt.Errorf("LokiService : For query %v error creating queryString : %+v", qName, err)
+	}
+	lokiEndpoint := strings.Trim(g.dsConfig.Host, " /") + "/loki/api/v1/query"
+	params := url.Values{}
+	params.Add("query", queryString)
+	params.Add("time", strconv.FormatInt(endTime.UnixNano(), 10))
+
+	lokiResponse, size, errc, err := g.queryLokiPage(client, lokiEndpoint, qName, params)
+	if err != nil {
+		return nil, size, errc, err
+	}
+	if lokiResponse.Data.ResultType != "vector" && lokiResponse.Data.ResultType != "matrix" {
+		return nil, size, ec.LME_7163, fmt.Errorf("LokiService : For query %v result type %v is received, but vector or matrix is expected in the metrics mode", qName, lokiResponse.Data.ResultType)
 	}
+	records, errc, err := g.processMetricResults(lokiResponse, qName)
+	return records, size, errc, err
+}
 
+// queryLokiRange reads the log entries of the time range page by page in the forward direction. Every next page starts
+// from the last timestamp of the previous one, entries with this timestamp which are already received are skipped.
+// If the max-records limit is reached, the entries received so far are returned together with ErrResponseTruncated.
+// If the query turns out to be a metric query, its matrix result is returned as is without paging.
+func (g *LokiService) queryLokiRange(client *http.Client, qName string, startTime time.Time, endTime time.Time) ([][]string, int, string, error) {
+	queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, LOKI_TEMPLATE_TIME_LAYOUT)
+	if err != nil {
+		return nil, 0, ec.LME_8102, fmt.Errorf("LokiService : For query %v error creating queryString : %+v", qName, err)
+	}
 	lokiEndpoint := strings.Trim(g.dsConfig.Host, " /") + "/loki/api/v1/query_range"
+	results := make([]LokiResponseDataResult, 0)
+	streamIndexes := make(map[string]int)
+	boundaryEntries := make(map[string]bool)
+	start := startTime.UnixNano()
+	end := endTime.UnixNano()
+	responseSize := 0
+	recordsCount := 0
+	truncated := false
+
+	for page := 1; ; page++ {
+		params := url.Values{}
+		params.Add("query", queryString)
+		params.Add("limit", strconv.Itoa(g.pageSize))
+		params.Add("start", strconv.FormatInt(start, 10))
+		params.Add("end", strconv.FormatInt(end, 10))
+		params.Add("direction", "forward")
+		lokiResponse, size, errc, err := g.queryLokiPage(client, lokiEndpoint, qName, params)
+		responseSize += size
+		if err != nil {
+			return nil, responseSize, errc, err
+		}
+		if lokiResponse.Data.ResultType == "vector" || lokiResponse.Data.ResultType == "matrix" {
+			records, errc, err := g.processMetricResults(lokiResponse, qName)
+			return records, responseSize, errc, err
+		}
+		var streams []LokiResponseDataResult
+		if len(lokiResponse.Data.Result) > 0 {
+			if err = json.Unmarshal(lokiResponse.Data.Result, &streams); err != nil {
+				return nil, responseSize, ec.LME_7143, fmt.Errorf("LokiService : Unmarshalling error of streams for query %v : %+v", qName, err)
+			}
+		}
+
+		entriesCount := 0
+		newEntriesCount := 0
+		lastTs := start
+		lastEntries := make(map[string]bool)
+		for _, result := range streams {
+			streamKey := utils.MapToString(result.Stream)
+			index, ok := streamIndexes[streamKey]
+			if !ok {
+				index = len(results)
+				streamIndexes[streamKey] = index
+				results = append(results, LokiResponseDataResult{Stream: result.Stream, Values: make([][]string, 0, len(result.Values))})
+			}
+			for _, v := range result.Values {
+				if len(v) < 2 {
+					continue
+				}
+				entriesCount++
+				ts, err := strconv.ParseInt(v[0], 10, 64)
+				if err != nil {
+					return nil, responseSize, ec.LME_7143, fmt.Errorf("LokiService : For query %v error parsing timestamp %v of the entry : %+v", qName, v[0], err)
+				}
+				entryKey := streamKey + "\x00" + v[0] + "\x00" + v[1]
+				if ts == start && boundaryEntries[entryKey] {
+					continue
+				}
+				if recordsCount >= g.maxRecords {
+					truncated = true
+					continue
+				}
+				results[index].Values = append(results[index].Values, v)
+				recordsCount++
+				newEntriesCount++
+				if ts > lastTs {
+					lastTs = ts
+					lastEntries = make(map[string]bool)
+				}
+				if ts == lastTs {
+					lastEntries[entryKey] = true
+				}
+			}
+		}
+		log.Debugf("LokiService : For query %v page %v contains %v entries, %v of them are new, %v records are received in total", qName, page, entriesCount, newEntriesCount, recordsCount)
+
+		if entriesCount < g.pageSize {
+			break
+		}
+		if truncated || recordsCount >= g.maxRecords {
+			truncated = true
+			break
+		}
+		if newEntriesCount == 0 {
+			log.WithField(ec.FIELD, ec.LME_7162).Warnf("LokiService : For query %v more than %v entries have the same timestamp %v, some of them may be skipped", qName, g.pageSize, start)
+			start++
+			boundaryEntries = make(map[string]bool)
+			continue
+		}
+		if lastTs == start {
+			for k := range boundaryEntries {
+				lastEntries[k] = true
+			}
+		}
+		start = lastTs
+		boundaryEntries = lastEntries
+	}
+
+	if truncated {
+		log.WithField(ec.FIELD, ec.LME_7162).Warnf("LokiService : For query %v the number of records exceeds max-records %v, the result is truncated", qName, g.maxRecords)
+		return g.processResults(results, qName), responseSize, ec.LME_7162, fmt.Errorf("LokiService : For query %v max-records %v is reached : %w", qName, g.maxRecords, ErrResponseTruncated)
+	}
+	return g.processResults(results, qName), responseSize, "", nil
+}
+
+func (g *LokiService) queryLokiPage(client *http.Client, lokiEndpoint string, qName string, params url.Values) (*LokiResponse, int, string, error) {
 	req, err := http.NewRequest("GET", lokiEndpoint, nil)
 	if err != nil {
-		return "", ec.LME_7160, fmt.Errorf("LokiService : For query %v error creating request to %v : %+v", qName, lokiEndpoint, err)
+		return nil, 0, ec.LME_7160, fmt.Errorf("LokiService : For query %v error creating request to %v : %+v", qName, lokiEndpoint, err)
 	}
 	if g.dsConfig.User != "" {
 		req.SetBasicAuth(g.dsConfig.User, g.dsConfig.Password)
 	}
-	q := req.URL.Query()
-	q.Add("query", qCfg.QueryString)
-	q.Add("limit", "5000")
-	q.Add("start", startTime.Format(time.RFC3339))
-	q.Add("end", endTime.Format(time.RFC3339))
-	req.URL.RawQuery = q.Encode()
+	req.URL.RawQuery = params.Encode()
 
 	log.Debugf("LokiService : For query %v request generated : %+v", qName, req.URL.String())
 
-	resp, err := client.Do(req)
+	resp, err := g.transport.do(qName, client, req)
 	if err != nil {
-		return "", ec.LME_7100, fmt.Errorf("LokiService : For query %v error acessing %v : %+v", qName, lokiEndpoint, err)
+		return nil, 0, ec.LME_7100, fmt.Errorf("LokiService : For query %v error acessing %v : %w", qName, lokiEndpoint, err)
 	}
 
 	if resp.Body != nil {
@@ -126,31 +295,29 @@ func (g *LokiService) queryLoki(qName string, startTime time.Time, endTime time.
 	log.Debugf("LokiService : For query %v received response : %+v", qName, resp)
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", ec.LME_7100, fmt.Errorf("LokiService : For query %v to %v error reading body : %+v", qName, lokiEndpoint, err)
+		return nil, len(body), ec.LME_7100, fmt.Errorf("LokiService : For query %v to %v error reading body : %w", qName, lokiEndpoint, err)
 	}
-	result := string(body)
-	log.Infof("LokiService : For query %v to %v response status is %v, body length is %v", qName, lokiEndpoint, resp.Status, len(result))
+	log.Infof("LokiService : For query %v to %v response status is %v, body length is %v", qName, lokiEndpoint, resp.Status, len(body))
 	if resp.StatusCode != 200 {
-		log.WithField(ec.FIELD, ec.LME_7102).Errorf("LokiService : For query %v received response with status code %v from loki, response body (limited) : %v", qName, resp.StatusCode, utils.GetLimitedPrefix(result, 10000))
+		log.WithField(ec.FIELD, ec.LME_7102).Errorf("LokiService : For query %v received response with status code %v from loki, response body (limited) : %v", qName, resp.StatusCode, utils.GetLimitedPrefix(string(body), 10000))
 		if resp.StatusCode >= 400 {
-			return "", ec.LME_7101, fmt.Errorf("LokiService : For query %v status code is %v", qName, resp.StatusCode)
+			return nil, len(body), ec.LME_7101, statusCodeError("LokiService", qName, resp.StatusCode)
 		}
 	}
-	log.Debugf("LokiService : For query %v received response body : %v", qName, result)
+	log.Debugf("LokiService : For query %v received response body : %s", qName, body)
 
-	return result, "", nil
-}
-
-func (g *LokiService) processJson(stringData string, qName string) ([][]string, string, error) {
-	log.Debugf("LokiService : Json processing : For query %v lokiResponse = %v", qName, stringData)
 	var lokiResponse LokiResponse
-	err := json.Unmarshal([]byte(stringData), &lokiResponse)
+	err = json.Unmarshal(body, &lokiResponse)
 	if err != nil {
 		log.WithField(ec.FIELD, ec.LME_7143).Errorf("LokiService : Unmarshalling error for query %v : %+v", qName, err)
-		return nil, ec.LME_7143, fmt.Errorf("LokiService : Unmarshalling error for query %v : %+v", qName, err)
+		return nil, len(body), ec.LME_7143, fmt.Errorf("LokiService : Unmarshalling error for query %v : %+v", qName, err)
 	}
-	if len(lokiResponse.Data.Result) == 0 {
-		return nil, "", nil
+	return &lokiResponse, len(body), "", nil
+}
+
+func (g *LokiService) processResults(results []LokiResponseDataResult, qName string) [][]string {
+	if len(results) == 0 {
+		return nil
 	}
 
 	totalLen := 0
@@ -167,7 +334,7 @@ func (g *LokiService) processJson(stringData string, qName string) ([][]string,
 		keyList = append(keyList, field)
 	}
 
-	for _, result := range lokiResponse.Data.Result {
+	for _, result := range results {
 		labelsMap := result.Stream
 		for k := range labelsMap {
 			if _, ok := keyListSet[k]; !ok {
@@ -183,7 +350,7 @@ func (g *LokiService) processJson(stringData string, qName string) ([][]string,
 	records = append(records, keyList)
 	rowlen := len(keyList)
 
-	for _, result := range lokiResponse.Data.Result {
+	for _, result := range results {
 		labelsMap := result.Stream
 		rowTemplate := make([]string, rowlen)
 		for k, v := range labelsMap {
@@ -206,5 +373,97 @@ func (g *LokiService) processJson(stringData string, qName string) ([][]string,
 
 	log.Debugf("LokiService : Json processing : For query %v result len = %v, result records = %+v", qName, len(records), records)
 
+	return records
+}
+
+// processMetricResults turns the vector or matrix result into rows, the header contains the labels
+// (fields_in_order go first), the _TIMESTAMP_ column (for matrix only) and the _RESULT_ column with the sample value
+func (g *LokiService) processMetricResults(lokiResponse *LokiResponse, qName string) ([][]string, string, error) {
+	var metricResults []LokiMetricResult
+	if len(lokiResponse.Data.Result) > 0 {
+		if err := json.Unmarshal(lokiResponse.Data.Result, &metricResults); err != nil {
+			return nil, ec.LME_7143, fmt.Errorf("LokiService : Unmarshalling error of %v for query %v : %+v", lokiResponse.Data.ResultType, qName, err)
+		}
+	}
+	if len(metricResults) == 0 {
+		return nil, "", nil
+	}
+	isMatrix := lokiResponse.Data.ResultType == "matrix"
+
+	keyList := make([]string, 0)
+	keyListSet := make(map[string]int)
+	for _, field := range g.appConfig.Queries[qName].FieldsInOrder {
+		if _, ok := keyListSet[field]; ok || field == RESULT_FIELD_NAME || field == LOKI_TIMESTAMP_FIELD_NAME {
+			continue
+		}
+		keyListSet[field] = len(keyList)
+		keyList = append(keyList, field)
+	}
+	labelNames := make([]string, 0)
+	totalLen := 0
+	for _, result := range metricResults {
+		for k := range result.Metric {
+			if _, ok := keyListSet[k]; !ok {
+				keyListSet[k] = -1
+				labelNames = append(labelNames, k)
+			}
+		}
+		totalLen += len(result.Values) + 1
+	}
+	sort.Strings(labelNames)
+	for _, k := range labelNames {
+		keyListSet[k] = len(keyList)
+		keyList = append(keyList, k)
+	}
+	if isMatrix {
+		keyList = append(keyList, LOKI_TIMESTAMP_FIELD_NAME)
+	}
+	keyList = append(keyList, RESULT_FIELD_NAME)
+	rowlen := len(keyList)
+
+	records := make([][]string, 0, totalLen+1)
+	records = append(records, keyList)
+	for _, result := range metricResults {
+		rowTemplate := make([]string, rowlen)
+		for k, v := range result.Metric {
+			rowTemplate[keyListSet[k]] = v
+		}
+		samples := result.Values
+		if !isMatrix {
+			samples = [][]interface{}{result.Value}
+		}
+		for _, sample := range samples {
+			ts, value, ok := lokiSampleToStrings(sample)
+			if !ok {
+				log.WithField(ec.FIELD, ec.LME_7143).Warnf("LokiService : For query %v sample %+v of the series %+v has unexpected format and is skipped", qName, sample, result.Metric)
+				continue
+			}
+			row := make([]string, rowlen)
+			copy(row, rowTemplate)
+			if isMatrix {
+				row[rowlen-2] = ts
+			}
+			row[rowlen-1] = value
+			records = append(records, row)
+		}
+	}
+
+	log.Debugf("LokiService : Json processing : For query %v result len = %v, result records = %+v", qName, len(records), records)
 	return records, "", nil
 }
+
+// lokiSampleToStrings converts the sample [<unix time in seconds>, "<value>"] to strings
+func lokiSampleToStrings(sample []interface{}) (string, string, bool) {
+	if len(sample) < 2 {
+		return "", "", false
+	}
+	ts, ok := sample[0].(float64)
+	if !ok {
+		return "", "", false
+	}
+	value, ok := sample[1].(string)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatFloat(ts, 'f', -1, 64), value, true
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log_exporter/internal/utils"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	NEWRELIC_API_INSIGHTS  = "insights"
+	NEWRELIC_API_NERDGRAPH = "nerdgraph"
+
+	NERDGRAPH_NRQL_TIMEOUT_MIN = 5
+	NERDGRAPH_NRQL_TIMEOUT_MAX = 120
+	NERDGRAPH_POLLING_TIME_MAX = time.Minute * 10
+)
+
+var (
+	// editorconfig-checker-disable used because next lines are part of the template
+	nerdGraphNrqlQuery = `query($accountId: Int!, $nrql: Nrql!, $timeout: Seconds) {
+  actor {
+    account(id: $accountId) {
+      nrql(query: $nrql, timeout: $timeout, async: true) {
+        rawResponse
+        queryProgress { queryId completed retryAfter retryDeadline }
+      }
+    }
+  }
+}`
+
+	nerdGraphQueryProgressQuery = `query($accountId: Int!, $queryId: ID!) {
+  actor {
+    account(id: $accountId) {
+      nrqlQueryProgress(queryId: $queryId) {
+        rawResponse
+        queryProgress { queryId completed retryAfter retryDeadline }
+      }
+    }
+  }
+}`
+
+// editorconfig-checker-enable
+)
+
+type NerdGraphResponse struct {
+	Data struct {
+		Actor struct {
+			Account struct {
+				Nrql              *NerdGraphNrqlResult `json:"nrql"`
+				NrqlQueryProgress *NerdGraphNrqlResult `json:"nrqlQueryProgress"`
+			} `json:"account"`
+		} `json:"actor"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// NerdGraphNrqlResult contains the raw NRDB response, which has the same format as the Insights API response
+type NerdGraphNrqlResult struct {
+	RawResponse   json.RawMessage         `json:"rawResponse"`
+	QueryProgress *NerdGraphQueryProgress `json:"queryProgress"`
+}
+
+type NerdGraphQueryProgress struct {
+	QueryId       string  `json:"queryId"`
+	Completed     bool    `json:"completed"`
+	RetryAfter    float64 `json:"retryAfter"`
+	RetryDeadline float64 `json:"retryDeadline"`
+}
+
+// queryNerdGraph executes NRQL with the NerdGraph API. Long queries are executed asynchronously:
+// if the query is not completed within the timeout, its progress is polled until the retry deadline
+func (g *NewRelicService) queryNerdGraph(qName string, startTime time.Time, endTime time.Time) ([][]string, int, string, error) {
+	queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, NEWRELIC_TEMPLATE_TIME_LAYOUT)
+	if err != nil {
+		return nil, 0, ec.LME_8102, fmt.Errorf("NewRelicService : For query %v error creating queryString : %+v", qName, err)
+	}
+	log.Debugf("NewRelicService : For query %v queryString is %v", qName, queryString)
+	accountId, err := strconv.Atoi(strings.TrimSpace(g.dsConfig.User))
+	if err != nil {
+		return nil, 0, ec.LME_7140, fmt.Errorf("NewRelicService : For query %v account id %v is not a number : %+v", qName, g.dsConfig.User, err)
+	}
+
+	variables := map[string]interface{}{
+		"accountId": accountId,
+		"nrql":      queryString,
+		"timeout":   g.getNrqlTimeout(),
+	}
+	nrqlResult, responseSize, errc, err := g.postNerdGraph(qName, nerdGraphNrqlQuery, variables)
+	if err != nil {
+		return nil, responseSize, errc, err
+	}
+
+	pollingDeadline := time.Now().Add(NERDGRAPH_POLLING_TIME_MAX)
+	for nrqlResult.QueryProgress != nil && !nrqlResult.QueryProgress.Completed {
+		progress := nrqlResult.QueryProgress
+		deadline := pollingDeadline
+		if progress.RetryDeadline > 0 {
+			deadline = time.UnixMilli(int64(progress.RetryDeadline))
+		}
+		retryAfter := time.Duration(progress.RetryAfter * float64(time.Second))
+		if retryAfter < time.Second {
+			retryAfter = time.Second
+		}
+		if time.Now().Add(retryAfter).After(deadline) {
+			return nil, responseSize, ec.LME_7146, fmt.Errorf("NewRelicService : For query %v asynchronous query %v is not completed before the retry deadline %v", qName, progress.QueryId, deadline)
+		}
+		log.Infof("NewRelicService : For query %v asynchronous query %v is not completed yet, polling in %v", qName, progress.QueryId, retryAfter)
+		time.Sleep(retryAfter)
+
+		variables = map[string]interface{}{
+			"accountId": accountId,
+			"queryId":   progress.QueryId,
+		}
+		var size int
+		nrqlResult, size, errc, err = g.postNerdGraph(qName, nerdGraphQueryProgressQuery, variables)
+		responseSize += size
+		if err != nil {
+			return nil, responseSize, errc, err
+		}
+	}
+
+	return g.processNerdGraphResult(nrqlResult, qName), responseSize, "", nil
+}
+
+func (g *NewRelicService) postNerdGraph(qName string, query string, variables map[string]interface{}) (*NerdGraphNrqlResult, int, string, error) {
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, 0, ec.LME_7140, fmt.Errorf("NewRelicService : For query %v error marshalling NerdGraph request : %+v", qName, err)
+	}
+
+	client := GetHTTPClient(&g.dsConfig.TLSHostConfig)
+
+	nerdGraphEndpoint := strings.Trim(g.dsConfig.Host, " /") + "/graphql"
+	req, err := http.NewRequest("POST", nerdGraphEndpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, 0, ec.LME_7140, fmt.Errorf("NewRelicService : For query %v error creating HTTP request: %+v", qName, err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("API-Key", g.dsConfig.Password)
+	log.Debugf("NewRelicService: For query %v request to NerdGraph is %s", qName, requestBody)
+	resp, err := g.transport.do(qName, client, req)
+	if err != nil {
+		return nil, 0, ec.LME_7140, fmt.Errorf("NewRelicService : For query %v error accessing %v : %w", qName, nerdGraphEndpoint, err)
+	}
+
+	if resp.Body != nil {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.Errorf("NewRelicService : Error closing response body : %+v", err)
+			}
+		}()
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, len(body), ec.LME_7140, fmt.Errorf("NewRelicService : For query %v to %v error reading body : %w", qName, nerdGraphEndpoint, err)
+	}
+	log.Infof("NewRelicService : For query %v to %v response status is %v, body length is %v", qName, nerdGraphEndpoint, resp.Status, len(body))
+	if resp.StatusCode != 200 {
+		log.WithField(ec.FIELD, ec.LME_7142).Errorf("NewRelicService : For query %v received response with status code %v from NerdGraph, response body (limited) : %v", qName, resp.StatusCode, utils.GetLimitedPrefix(string(body), 10000))
+		if resp.StatusCode >= 400 {
+			return nil, len(body), ec.LME_7141, statusCodeError("NewRelicService", qName, resp.StatusCode)
+		}
+	}
+	log.Debugf("NewRelicService : For query %v received response body : %s", qName, body)
+
+	var nerdGraphResponse NerdGraphResponse
+	if err = json.Unmarshal(body, &nerdGraphResponse); err != nil {
+		return nil, len(body), ec.LME_7143, fmt.Errorf("NewRelicService : Unmarshalling error for query %v : %+v", qName, err)
+	}
+	if len(nerdGraphResponse.Errors) > 0 {
+		messages := make([]string, 0, len(nerdGraphResponse.Errors))
+		for _, e := range nerdGraphResponse.Errors {
+			messages = append(messages, e.Message)
+		}
+		return nil, len(body), ec.LME_7145, fmt.Errorf("NewRelicService : For query %v NerdGraph returned errors : %v", qName, strings.Join(messages, "; "))
+	}
+	account := nerdGraphResponse.Data.Actor.Account
+	result := account.Nrql
+	if result == nil {
+		result = account.NrqlQueryProgress
+	}
+	if result == nil {
+		return nil, len(body), ec.LME_7144, fmt.Errorf("NewRelicService : For query %v NerdGraph response does not contain nrql result", qName)
+	}
+	return result, len(body), "", nil
+}
+
+// processNerdGraphResult turns the raw NRDB response into the same records the Insights API responses are turned into
+func (g *NewRelicService) processNerdGraphResult(nrqlResult *NerdGraphNrqlResult, qName string) [][]string {
+	if len(nrqlResult.RawResponse) == 0 || string(nrqlResult.RawResponse) == "null" {
+		log.Warnf("NewRelicService : Json processing : For query %v, results list is empty", qName)
+		return nil
+	}
+	return g.processJson(string(nrqlResult.RawResponse), qName)
+}
+
+// getNrqlTimeout returns the NRQL timeout in seconds, which is less than the timeout of the HTTP client,
+// so the long query is turned into the asynchronous one instead of failing with the client timeout
+func (g *NewRelicService) getNrqlTimeout() int {
+	timeout := int(g.dsConfig.ConnectionTimeout.Seconds()) - 5
+	if timeout < NERDGRAPH_NRQL_TIMEOUT_MIN {
+		timeout = NERDGRAPH_NRQL_TIMEOUT_MIN
+	}
+	if timeout > NERDGRAPH_NRQL_TIMEOUT_MAX {
+		timeout = NERDGRAPH_NRQL_TIMEOUT_MAX
+	}
+	return timeout
+}
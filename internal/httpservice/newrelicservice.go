@@ -15,19 +15,17 @@
 package httpservice
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log_exporter/internal/config"
 	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
-	"net"
 	"net/http"
 	"net/url"
-	"reflect"
+	"sort"
+	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -36,41 +34,61 @@ import (
 type NewRelicService struct {
 	appConfig *config.Config
 	dsConfig  *config.DatasourceConfig
+	nerdGraph bool
+	transport *datasourceTransport
 }
 
 type NRResponse struct {
-	Results  *[]NRResult
-	Facets   []Facet
-	Metadata NRMetadata
+	Results    []map[string]interface{}
+	Facets     []NRFacet
+	TimeSeries []NRTimeSeriesBucket
+	Metadata   map[string]interface{}
 }
 
-type NRResult struct {
-	Events      *[]map[string]interface{}
-	UniqueCount *float64
+type NRFacet struct {
+	Name       interface{}
+	Results    []map[string]interface{}
+	TimeSeries []NRTimeSeriesBucket
 }
 
-type NRMetadata struct {
-	Facet *interface{}
+type NRTimeSeriesBucket struct {
+	Results          []map[string]interface{}
+	BeginTimeSeconds float64
+	EndTimeSeconds   float64
 }
 
-type Facet struct {
-	Name    interface{}
-	Results []FacetResult
+type nrAggregateRow struct {
+	facets    []string
+	timestamp string
+	values    []nrAggregateValue
 }
 
-type FacetResult struct {
-	Count float64
+type nrAggregateValue struct {
+	name  string
+	value string
 }
 
-const RESULT_FIELD_NAME string = "_RESULT_"
+const (
+	RESULT_FIELD_NAME    string = "_RESULT_"
+	TIMESTAMP_FIELD_NAME string = "_TIMESTAMP_"
+)
 
-func CreateNewRelicService(appConfig *config.Config) *NewRelicService {
+func CreateNewRelicService(appConfig *config.Config, dsName string) *NewRelicService {
 	g := NewRelicService{}
 	g.appConfig = appConfig
-	g.dsConfig = appConfig.Datasources[appConfig.DsName]
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.transport = newDatasourceTransport(appConfig, dsName)
+	g.nerdGraph = strings.ToLower(g.dsConfig.Api) == NEWRELIC_API_NERDGRAPH
+	if g.nerdGraph {
+		log.Infof("NewRelicService : Datasource %v uses the NerdGraph API", dsName)
+	}
 	return &g
 }
 
+func (g *NewRelicService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{Retries: true}
+}
+
 func (g *NewRelicService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
 	now := time.Now()
 	var err error
@@ -83,6 +101,19 @@ func (g *NewRelicService) Query(qName string, startTime time.Time, endTime time.
 		}
 	}()
 
+	if g.nerdGraph {
+		var result [][]string
+		var responseSize int
+		var errc string
+		result, responseSize, errc, err = g.queryNerdGraph(qName, startTime, endTime)
+		selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
+		selfMonitorObserveQueryResponseSize(float64(responseSize), qName, now)
+		if err != nil {
+			return make([][]string, 0), errc, err
+		}
+		return result, "", nil
+	}
+
 	stringResult, errc, err := g.queryNewRelic(qName, startTime, endTime)
 	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
 	selfMonitorObserveQueryResponseSize(float64(len(stringResult)), qName, now)
@@ -95,22 +126,13 @@ func (g *NewRelicService) Query(qName string, startTime time.Time, endTime time.
 }
 
 func (g *NewRelicService) queryNewRelic(qName string, startTime time.Time, endTime time.Time) (string, string, error) {
-	queryString, err := g.getQueryString(qName, startTime, endTime)
+	queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, NEWRELIC_TEMPLATE_TIME_LAYOUT)
 	if err != nil {
 		return "", ec.LME_8102, fmt.Errorf("NewRelicService : For query %v error creating queryString : %+v", qName, err)
 	}
 	log.Debugf("NewRelicService : For query %v queryString is %v", qName, queryString)
 
-	var transport http.RoundTripper = &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: g.dsConfig.ConnectionTimeout,
-		}).DialContext,
-		TLSClientConfig: g.dsConfig.TlsConfig,
-	}
-	client := http.Client{
-		Transport: transport,
-		Timeout:   g.dsConfig.ConnectionTimeout,
-	}
+	client := GetHTTPClient(&g.dsConfig.TLSHostConfig)
 
 	newRelicEndpoint := strings.Trim(g.dsConfig.Host, " /") + "/v1/accounts/" + g.dsConfig.User + "/query?nrql=" + url.QueryEscape(queryString)
 	req, err := http.NewRequest("GET", newRelicEndpoint, nil)
@@ -120,7 +142,7 @@ func (g *NewRelicService) queryNewRelic(qName string, startTime time.Time, endTi
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("X-Query-Key", g.dsConfig.Password)
 	log.Debugf("NewRelicService: For query %v request to NewRelic is %+v", qName, req)
-	resp, err := client.Do(req)
+	resp, err := g.transport.do(qName, client, req)
 	if err != nil {
 		return "", ec.LME_7140, fmt.Errorf("NewRelicService : For query %v error accessing %v : %+v", qName, newRelicEndpoint, err)
 	}
@@ -151,6 +173,11 @@ func (g *NewRelicService) queryNewRelic(qName string, startTime time.Time, endTi
 	return result, "", nil
 }
 
+// processJson turns the NRQL result into records. Events are returned as is. Aggregates of facets, timeseries buckets and
+// plain aggregate queries get one record per facet and bucket with the facet fields, the _TIMESTAMP_ field (the bucket
+// begin time in Unix seconds, only for timeseries) and the _RESULT_ field with the value of the aggregate. If there are
+// several aggregates, each of them gets the field named after the aggregate function and attribute or its alias
+// (for example, "average.duration") instead of _RESULT_
 func (g *NewRelicService) processJson(stringData string, qName string) [][]string {
 	log.Debugf("NewRelicService : Json processing : For query %v nrResponse = %v", qName, stringData)
 	var nrResponse NRResponse
@@ -160,21 +187,50 @@ func (g *NewRelicService) processJson(stringData string, qName string) [][]strin
 		return nil
 	}
 
-	if nrResponse.Metadata.Facet != nil {
-		return g.processFacets(nrResponse, qName)
-	} else if nrResponse.Results != nil {
-		if len(*nrResponse.Results) == 0 {
+	contentNames := getNRContentNames(nrResponse.Metadata)
+	log.Debugf("NewRelicService : Json processing : For query %v aggregate names from metadata : %+v", qName, contentNames)
+
+	if facetNames := getNRFacetNames(nrResponse.Metadata["facet"]); facetNames != nil {
+		timeseries := false
+		rows := make([]nrAggregateRow, 0, len(nrResponse.Facets))
+		for _, facet := range nrResponse.Facets {
+			facetValues := getNRFacetValues(facet.Name)
+			if len(facet.TimeSeries) > 0 {
+				timeseries = true
+				for _, bucket := range facet.TimeSeries {
+					rows = append(rows, nrAggregateRow{facets: facetValues, timestamp: formatNRTimestamp(bucket.BeginTimeSeconds), values: getNRAggregateValues(bucket.Results, contentNames)})
+				}
+			} else {
+				rows = append(rows, nrAggregateRow{facets: facetValues, values: getNRAggregateValues(facet.Results, contentNames)})
+			}
+		}
+		return g.processAggregates(facetNames, timeseries, rows, qName)
+	}
+
+	if len(nrResponse.TimeSeries) > 0 {
+		rows := make([]nrAggregateRow, 0, len(nrResponse.TimeSeries))
+		for _, bucket := range nrResponse.TimeSeries {
+			rows = append(rows, nrAggregateRow{timestamp: formatNRTimestamp(bucket.BeginTimeSeconds), values: getNRAggregateValues(bucket.Results, contentNames)})
+		}
+		return g.processAggregates([]string{}, true, rows, qName)
+	}
+
+	if nrResponse.Results != nil {
+		if len(nrResponse.Results) == 0 {
 			log.Warnf("NewRelicService : Json processing : For query %v, results list is empty", qName)
 			return nil
 		}
-		events := (*nrResponse.Results)[0].Events
-		if events != nil {
-			return g.processEvents(*events, qName)
-		}
-		uniqueCount := (*nrResponse.Results)[0].UniqueCount
-		if uniqueCount != nil {
-			return g.processUniqueCounts(*uniqueCount, qName)
+		if events, ok := nrResponse.Results[0]["events"].([]interface{}); ok {
+			eventMaps := make([]map[string]interface{}, 0, len(events))
+			for _, event := range events {
+				if eventMap, ok := event.(map[string]interface{}); ok {
+					eventMaps = append(eventMaps, eventMap)
+				}
+			}
+			return g.processEvents(eventMaps, qName)
 		}
+		rows := []nrAggregateRow{{values: getNRAggregateValues(nrResponse.Results, contentNames)}}
+		return g.processAggregates([]string{}, false, rows, qName)
 	}
 
 	log.WithField(ec.FIELD, ec.LME_7144).Errorf("NewRelicService : Json processing : For query %v got Unknown JSON output case, processing will be skipped", qName)
@@ -215,89 +271,181 @@ func (g *NewRelicService) processEvents(events []map[string]interface{}, qName s
 	return records
 }
 
-func (g *NewRelicService) processFacets(nrResponse NRResponse, qName string) [][]string {
-	log.Debugf("NewRelicService : Facets processing : For query %v processFacets called", qName)
-	labelNames := make([]string, 0)
-	switch facet := (*nrResponse.Metadata.Facet).(type) {
+// processAggregates puts the only aggregate into the _RESULT_ field, like the Insights API facets and unique counts
+// were always processed; several aggregates get a field per aggregate instead
+func (g *NewRelicService) processAggregates(facetNames []string, timeseries bool, rows []nrAggregateRow, qName string) [][]string {
+	log.Debugf("NewRelicService : Aggregates processing : For query %v got facet names : %+v, timeseries : %v", qName, facetNames, timeseries)
+	heading := make([]string, 0, len(facetNames)+2)
+	heading = append(heading, facetNames...)
+	if timeseries {
+		heading = append(heading, TIMESTAMP_FIELD_NAME)
+	}
+	valuesStart := len(heading)
+	columns := make(map[string]int)
+	for _, row := range rows {
+		for _, value := range row.values {
+			if _, ok := columns[value.name]; !ok {
+				columns[value.name] = len(heading)
+				heading = append(heading, value.name)
+			}
+		}
+	}
+	if len(columns) == 1 {
+		heading[valuesStart] = RESULT_FIELD_NAME
+	}
+
+	records := make([][]string, 0, len(rows)+1)
+	records = append(records, heading)
+	for _, row := range rows {
+		if len(row.values) == 0 {
+			log.Warnf("NewRelicService : Aggregates processing : For query %v results are empty for facet %v", qName, row.facets)
+			continue
+		}
+		if len(row.facets) != len(facetNames) {
+			log.Warnf("NewRelicService : Aggregates processing : For query %v facet %v has %v values, but %v facets are expected", qName, row.facets, len(row.facets), len(facetNames))
+		}
+		record := make([]string, len(heading))
+		copy(record, row.facets[:min(len(row.facets), len(facetNames))])
+		if timeseries {
+			record[valuesStart-1] = row.timestamp
+		}
+		for _, value := range row.values {
+			record[columns[value.name]] = value.value
+		}
+		records = append(records, record)
+	}
+	log.Debugf("NewRelicService : Aggregates processing : For query %v the following records were calculated : %+v", qName, records)
+
+	return records
+}
+
+func getNRFacetNames(facet interface{}) []string {
+	switch f := facet.(type) {
 	case string:
-		labelNames = append(labelNames, facet)
-	case []string:
-		labelNames = append(labelNames, facet...)
+		return []string{f}
 	case []interface{}:
-		for _, v := range facet {
-			labelNames = append(labelNames, fmt.Sprintf("%v", v))
+		facetNames := make([]string, 0, len(f))
+		for _, v := range f {
+			facetNames = append(facetNames, fmt.Sprintf("%v", v))
 		}
-	case interface{}:
-		labelNames = append(labelNames, fmt.Sprintf("%v", facet))
+		return facetNames
+	case nil:
+		return nil
 	default:
-		log.WithField(ec.FIELD, ec.LME_7144).Errorf("NewRelicService : Facets processing : For query %v got unknown type for Metadata.Facet in JSON : %+v", qName, reflect.TypeOf(facet))
+		return []string{fmt.Sprintf("%v", f)}
 	}
-	log.Debugf("NewRelicService : Facets processing : For query %v got labelNames : %+v", qName, labelNames)
-
-	columnNumber := len(labelNames) + 1
+}
 
-	records := make([][]string, 0)
-	heading := make([]string, 0, columnNumber)
-	heading = append(heading, labelNames...)
-	heading = append(heading, RESULT_FIELD_NAME)
-	records = append(records, heading)
+func getNRFacetValues(name interface{}) []string {
+	if values, ok := name.([]interface{}); ok {
+		result := make([]string, 0, len(values))
+		for _, v := range values {
+			result = append(result, formatNRValue(v))
+		}
+		return result
+	}
+	return []string{formatNRValue(name)}
+}
 
-	for _, facetItem := range nrResponse.Facets {
-		row := make([]string, 0, columnNumber)
-		switch f := facetItem.Name.(type) {
-		case string:
-			row = append(row, f)
-		case []string:
-			row = append(row, f...)
-		case []interface{}:
-			for _, v := range f {
-				row = append(row, fmt.Sprintf("%v", v))
-			}
-		case interface{}:
-			row = append(row, fmt.Sprintf("%v", f))
-		default:
-			log.WithField(ec.FIELD, ec.LME_7144).Errorf("NewRelicService : Facets processing : For query %v got unknown type for Facets.Name in JSON : %+v", qName, reflect.TypeOf(facetItem.Name))
+// getNRAggregateValues flattens the results list, in which every element contains the value of one aggregate function.
+// The names are taken from the metadata if they match the results, otherwise the keys of the results are used.
+// Map values (for example, percentiles) get a field per key, like "percentile.duration.95"
+func getNRAggregateValues(results []map[string]interface{}, contentNames []string) []nrAggregateValue {
+	values := make([]nrAggregateValue, 0, len(results))
+	names := make(map[string]bool)
+	for i, result := range results {
+		base := ""
+		if len(contentNames) == len(results) {
+			base = contentNames[i]
 		}
-		if len(facetItem.Results) != 1 {
-			if len(facetItem.Results) == 0 {
-				log.Warnf("NewRelicService : Facets processing : For query %v len(facetItem.Results) = 0", qName)
+		keys := make([]string, 0, len(result))
+		for key := range result {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := base
+			if name == "" {
+				name = key
+			} else if len(keys) > 1 {
+				name = base + "." + key
+			}
+			if names[name] {
+				name = name + "." + strconv.Itoa(i)
+			}
+			names[name] = true
+			if nested, ok := result[key].(map[string]interface{}); ok {
+				nestedKeys := make([]string, 0, len(nested))
+				for nestedKey := range nested {
+					nestedKeys = append(nestedKeys, nestedKey)
+				}
+				sort.Strings(nestedKeys)
+				for _, nestedKey := range nestedKeys {
+					values = append(values, nrAggregateValue{name: name + "." + nestedKey, value: formatNRValue(nested[nestedKey])})
+				}
 				continue
 			}
-			log.Debugf("NewRelicService : Facets processing : For query %v len(facetItem.Results) = %v", qName, len(facetItem.Results))
-		}
-		row = append(row, fmt.Sprintf("%v", facetItem.Results[0].Count))
-		if len(row) != columnNumber {
-			log.Warnf("NewRelicService : Facets processing : For query %v len(row) = %v; columnNumber = %v", qName, len(row), columnNumber)
+			values = append(values, nrAggregateValue{name: name, value: formatNRValue(result[key])})
 		}
-		records = append(records, row)
 	}
-	log.Debugf("NewRelicService : Facets processing : For query %v the following records were calculated : %+v", qName, records)
+	return values
+}
 
-	return records
+// getNRContentNames returns the names of the aggregate functions from the "contents" list of the metadata,
+// which may be nested into the "contents" object (for facet queries) or into the "timeSeries" object
+func getNRContentNames(metadata map[string]interface{}) []string {
+	contents := findNRContents(metadata)
+	if contents == nil {
+		return nil
+	}
+	names := make([]string, 0, len(contents))
+	for _, content := range contents {
+		contentMap, ok := content.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		names = append(names, getNRContentName(contentMap))
+	}
+	return names
 }
 
-func (g *NewRelicService) processUniqueCounts(uniqueCount float64, qName string) [][]string {
-	log.Debugf("NewRelicService : UniqueCount processing : For query %v processUniqueCounts called", qName)
-	records := make([][]string, 0)
-	records = append(records, []string{RESULT_FIELD_NAME})
-	records = append(records, []string{fmt.Sprintf("%v", uniqueCount)})
-	log.Debugf("NewRelicService : UniqueCount processing : For query %v the following records were calculated : %+v", qName, records)
-	return records
+func findNRContents(value interface{}) []interface{} {
+	valueMap, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if contents, ok := valueMap["contents"]; ok {
+		if contentsList, ok := contents.([]interface{}); ok {
+			return contentsList
+		}
+		return findNRContents(contents)
+	}
+	return findNRContents(valueMap["timeSeries"])
 }
 
-func (g *NewRelicService) getQueryString(qName string, startTime time.Time, endTime time.Time) (string, error) {
-	qCfg := g.appConfig.Queries[qName]
-	templateCtx := make(map[string]string)
-	templateCtx["StartTime"] = startTime.Format("2006-01-02 15:04:05 MST")
-	templateCtx["EndTime"] = endTime.Format("2006-01-02 15:04:05 MST")
-	tmpl, err := template.New("query_template").Parse(qCfg.QueryString)
-	if err != nil {
-		return "", fmt.Errorf("error creating template for query %v : %+v", qName, err)
+func getNRContentName(content map[string]interface{}) string {
+	function, _ := content["function"].(string)
+	if function == "alias" {
+		if alias, ok := content["alias"].(string); ok {
+			return alias
+		}
 	}
-	buf := new(bytes.Buffer)
-	err = tmpl.Execute(buf, templateCtx)
-	if err != nil {
-		return "", fmt.Errorf("error executing template for query %v : %+v", qName, err)
+	if nested, ok := content["contents"].(map[string]interface{}); ok {
+		return getNRContentName(nested)
+	}
+	if attribute, ok := content["attribute"].(string); ok && attribute != "" {
+		return function + "." + attribute
+	}
+	return function
+}
+
+func formatNRValue(value interface{}) string {
+	if value == nil {
+		return ""
 	}
-	return buf.String(), nil
+	return fmt.Sprintf("%v", value)
+}
+
+func formatNRTimestamp(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
 }
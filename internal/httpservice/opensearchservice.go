@@ -0,0 +1,359 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log_exporter/internal/config"
+	"log_exporter/internal/utils"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	OPENSEARCH_TIMESTAMP_FIELD_DEFAULT = "@timestamp"
+	OPENSEARCH_PAGE_SIZE_DEFAULT       = 1000
+	OPENSEARCH_SCROLL_KEEP_ALIVE       = "1m"
+)
+
+type OpenSearchService struct {
+	appConfig      *config.Config
+	dsConfig       *config.DatasourceConfig
+	timestampField string
+	pageSize       int
+	transport      *datasourceTransport
+}
+
+type OpenSearchResponse struct {
+	ScrollId string `json:"_scroll_id"`
+	TimedOut bool   `json:"timed_out"`
+	Hits     OpenSearchResponseHits
+}
+
+type OpenSearchResponseHits struct {
+	Hits []OpenSearchResponseHit
+}
+
+type OpenSearchResponseHit struct {
+	Index  string                 `json:"_index"`
+	Id     string                 `json:"_id"`
+	Source map[string]interface{} `json:"_source"`
+}
+
+func CreateOpenSearchService(appConfig *config.Config, dsName string) *OpenSearchService {
+	g := OpenSearchService{}
+	g.appConfig = appConfig
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.transport = newDatasourceTransport(appConfig, dsName)
+	g.timestampField = g.dsConfig.TimestampField
+	if g.timestampField == "" {
+		g.timestampField = OPENSEARCH_TIMESTAMP_FIELD_DEFAULT
+	}
+	g.pageSize = g.dsConfig.PageSize
+	if g.pageSize <= 0 {
+		g.pageSize = OPENSEARCH_PAGE_SIZE_DEFAULT
+	}
+	log.Infof("OpenSearchService : Service created for index '%v', timestamp field %v and page size %v", g.dsConfig.Index, g.timestampField, g.pageSize)
+	return &g
+}
+
+func (g *OpenSearchService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{Retries: true, TimerangeSplitting: true}
+}
+
+func (g *OpenSearchService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	now := time.Now()
+	var err error
+	defer func() {
+		log.Debugf("OpenSearchService : For query %v request executed and json is processed in %+v", qName, time.Since(now))
+		if err != nil {
+			selfMonitorIncErrorCodeCount(qName, now)
+		} else {
+			selfMonitorRefreshErrorCodeCount(qName, now)
+		}
+	}()
+
+	result, responseSize, errc, err := g.queryOpenSearch(qName, startTime, endTime)
+	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
+	selfMonitorObserveQueryResponseSize(float64(responseSize), qName, now)
+	if err != nil {
+		return make([][]string, 0), errc, err
+	}
+	return result, "", nil
+}
+
+// queryOpenSearch reads all the documents matching the query in the time range page by page using the scroll API
+func (g *OpenSearchService) queryOpenSearch(qName string, startTime time.Time, endTime time.Time) ([][]string, int, string, error) {
+	client := GetHTTPClient(&g.dsConfig.TLSHostConfig)
+
+	host := strings.Trim(g.dsConfig.Host, " /")
+	searchEndpoint := host + "/_search"
+	if index := strings.Trim(g.dsConfig.Index, " /"); index != "" {
+		searchEndpoint = host + "/" + index + "/_search"
+	}
+	scrollEndpoint := host + "/_search/scroll"
+
+	queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, OPENSEARCH_TEMPLATE_TIME_LAYOUT)
+	if err != nil {
+		return nil, 0, ec.LME_8102, fmt.Errorf("OpenSearchService : For query %v error creating queryString : %+v", qName, err)
+	}
+	body, err := g.getSearchBody(qName, queryString, startTime, endTime)
+	if err != nil {
+		return nil, 0, ec.LME_7170, fmt.Errorf("OpenSearchService : For query %v error creating request body : %+v", qName, err)
+	}
+
+	responseSize := 0
+	osResponse, size, errc, err := g.doRequest(client, qName, "POST", searchEndpoint+"?scroll="+OPENSEARCH_SCROLL_KEEP_ALIVE, body)
+	responseSize += size
+	if err != nil {
+		return nil, responseSize, errc, err
+	}
+
+	header := g.getHeader(qName, osResponse.Hits.Hits)
+	records := make([][]string, 0, len(osResponse.Hits.Hits)+1)
+	records = append(records, header)
+
+	scrollId := ""
+	defer func() {
+		g.clearScroll(client, qName, scrollEndpoint, scrollId)
+	}()
+
+	for {
+		if osResponse.ScrollId != "" {
+			scrollId = osResponse.ScrollId
+		}
+		if osResponse.TimedOut {
+			log.WithField(ec.FIELD, ec.LME_7175).Warnf("OpenSearchService : For query %v search timed out on the OpenSearch side, the result may be incomplete", qName)
+		}
+		for _, hit := range osResponse.Hits.Hits {
+			records = append(records, g.hitToRow(hit, header))
+		}
+		if len(osResponse.Hits.Hits) < g.pageSize || osResponse.ScrollId == "" {
+			break
+		}
+		scrollBody, err := json.Marshal(map[string]string{"scroll": OPENSEARCH_SCROLL_KEEP_ALIVE, "scroll_id": scrollId})
+		if err != nil {
+			return nil, responseSize, ec.LME_7170, fmt.Errorf("OpenSearchService : For query %v error creating scroll request body : %+v", qName, err)
+		}
+		osResponse, size, errc, err = g.doRequest(client, qName, "POST", scrollEndpoint, scrollBody)
+		responseSize += size
+		if err != nil {
+			return nil, responseSize, errc, err
+		}
+	}
+
+	log.Debugf("OpenSearchService : For query %v result len = %v, result records = %+v", qName, len(records), records)
+	return records, responseSize, "", nil
+}
+
+func (g *OpenSearchService) getSearchBody(qName string, queryString string, startTime time.Time, endTime time.Time) ([]byte, error) {
+	qCfg := g.appConfig.Queries[qName]
+	queryString = strings.TrimSpace(queryString)
+	if queryString == "" {
+		queryString = "*"
+	}
+	search := map[string]interface{}{
+		"size": g.pageSize,
+		"sort": []string{"_doc"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": []interface{}{
+					map[string]interface{}{
+						"query_string": map[string]interface{}{
+							"query": queryString,
+						},
+					},
+				},
+				"filter": []interface{}{
+					map[string]interface{}{
+						"range": map[string]interface{}{
+							g.timestampField: map[string]interface{}{
+								"gte":    startTime.UTC().Format(time.RFC3339Nano),
+								"lt":     endTime.UTC().Format(time.RFC3339Nano),
+								"format": "strict_date_optional_time",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if len(qCfg.FieldsInOrder) > 0 {
+		search["_source"] = qCfg.FieldsInOrder
+	}
+	return json.Marshal(search)
+}
+
+func (g *OpenSearchService) doRequest(client *http.Client, qName string, method string, endpoint string, body []byte) (*OpenSearchResponse, int, string, error) {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, ec.LME_7170, fmt.Errorf("OpenSearchService : For query %v error creating request to %v : %+v", qName, endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.dsConfig.User != "" {
+		req.SetBasicAuth(g.dsConfig.User, g.dsConfig.Password)
+	}
+	log.Debugf("OpenSearchService : For query %v request generated : %v %v %s", qName, method, endpoint, body)
+
+	resp, err := g.transport.do(qName, client, req)
+	if err != nil {
+		return nil, 0, ec.LME_7170, fmt.Errorf("OpenSearchService : For query %v error accessing %v : %w", qName, endpoint, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Errorf("OpenSearchService : Error closing response body : %+v", err)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, len(respBody), ec.LME_7170, fmt.Errorf("OpenSearchService : For query %v to %v error reading body : %w", qName, endpoint, err)
+	}
+	log.Infof("OpenSearchService : For query %v to %v response status is %v, body length is %v", qName, endpoint, resp.Status, len(respBody))
+	if resp.StatusCode != http.StatusOK {
+		log.WithField(ec.FIELD, ec.LME_7172).Errorf("OpenSearchService : For query %v received response with status code %v from opensearch, response body (limited) : %v", qName, resp.StatusCode, utils.GetLimitedPrefix(string(respBody), 10000))
+		if resp.StatusCode >= 400 {
+			return nil, len(respBody), ec.LME_7171, statusCodeError("OpenSearchService", qName, resp.StatusCode)
+		}
+		if resp.StatusCode >= 300 {
+			return nil, len(respBody), ec.LME_7172, statusCodeError("OpenSearchService", qName, resp.StatusCode)
+		}
+	}
+
+	var osResponse OpenSearchResponse
+	decoder := json.NewDecoder(bytes.NewReader(respBody))
+	decoder.UseNumber()
+	if err = decoder.Decode(&osResponse); err != nil {
+		return nil, len(respBody), ec.LME_7173, fmt.Errorf("OpenSearchService : Unmarshalling error for query %v : %+v", qName, err)
+	}
+	return &osResponse, len(respBody), "", nil
+}
+
+func (g *OpenSearchService) clearScroll(client *http.Client, qName string, scrollEndpoint string, scrollId string) {
+	if scrollId == "" {
+		return
+	}
+	body, err := json.Marshal(map[string][]string{"scroll_id": {scrollId}})
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7170).Errorf("OpenSearchService : For query %v error creating clear scroll request body : %+v", qName, err)
+		return
+	}
+	req, err := http.NewRequest("DELETE", scrollEndpoint, bytes.NewReader(body))
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7170).Errorf("OpenSearchService : For query %v error creating clear scroll request : %+v", qName, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.dsConfig.User != "" {
+		req.SetBasicAuth(g.dsConfig.User, g.dsConfig.Password)
+	}
+	resp, err := g.transport.do(qName, client, req)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7170).Warnf("OpenSearchService : For query %v error clearing scroll context : %+v", qName, err)
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		log.Errorf("OpenSearchService : Error closing response body : %+v", err)
+	}
+	log.Debugf("OpenSearchService : For query %v scroll context cleared with status %v", qName, resp.Status)
+}
+
+// getHeader returns fields_in_order as the header if it is configured, otherwise the union of the top-level keys of the first page
+func (g *OpenSearchService) getHeader(qName string, hits []OpenSearchResponseHit) []string {
+	fieldsInOrder := g.appConfig.Queries[qName].FieldsInOrder
+	header := make([]string, 0, len(fieldsInOrder))
+	keySet := make(map[string]bool)
+	for _, field := range fieldsInOrder {
+		if !keySet[field] {
+			keySet[field] = true
+			header = append(header, field)
+		}
+	}
+	if len(header) > 0 {
+		return header
+	}
+	for _, hit := range hits {
+		for k := range hit.Source {
+			if !keySet[k] {
+				keySet[k] = true
+				header = append(header, k)
+			}
+		}
+	}
+	log.Warnf("OpenSearchService : fields_in_order is not defined for query %v, header %+v is built from the first page", qName, header)
+	return header
+}
+
+func (g *OpenSearchService) hitToRow(hit OpenSearchResponseHit, header []string) []string {
+	row := make([]string, len(header))
+	for i, field := range header {
+		switch field {
+		case "_id":
+			row[i] = hit.Id
+		case "_index":
+			row[i] = hit.Index
+		default:
+			row[i] = formatJsonValue(lookupSourceField(hit.Source, field))
+		}
+	}
+	return row
+}
+
+// lookupSourceField looks the field up by its exact name first, then as a dotted path through nested objects
+func lookupSourceField(source map[string]interface{}, field string) interface{} {
+	if v, ok := source[field]; ok {
+		return v
+	}
+	var current interface{} = source
+	for _, part := range strings.Split(field, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if current, ok = m[part]; !ok {
+			return nil
+		}
+	}
+	return current
+}
+
+// formatJsonValue converts the JSON field value to string. Strings and numbers are returned as is,
+// objects and arrays are kept in their JSON representation
+func formatJsonValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
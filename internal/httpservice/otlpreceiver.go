@@ -0,0 +1,533 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	ec "log_exporter/internal/utils/errorcodes"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	RECEIVER_OTLP_LOGS_ENDPOINT = "/v1/logs"
+
+	OTLP_RESOURCE_PREFIX = "resource."
+	OTLP_SCOPE_PREFIX    = "scope."
+	OTLP_BODY_FIELD_NAME = "body"
+	// OTLP_MAX_VALUE_DEPTH limits the nesting of the array and key-value list values
+	OTLP_MAX_VALUE_DEPTH = 100
+)
+
+// otlpLogRecord is the log record of the OTLP request, decoded either from protobuf or from JSON
+type otlpLogRecord struct {
+	timeUnixNano         uint64
+	observedTimeUnixNano uint64
+	severityNumber       int64
+	severityText         string
+	body                 interface{}
+	attributes           map[string]interface{}
+	traceId              string
+	spanId               string
+	eventName            string
+}
+
+// otlpLogsHandler receives the OTLP/HTTP logs export requests (ExportLogsServiceRequest) in the binary protobuf
+// or in the JSON encoding. Every log record becomes a record with the flattened resource attributes ("resource." prefix),
+// instrumentation scope name, version and attributes ("scope." prefix), log record attributes (without prefix),
+// severity, body, trace and span IDs. The record timestamp is the log record time, or the observed time if it is not set
+func (g *ReceiverService) otlpLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/x-protobuf" && contentType != "application/protobuf" && contentType != "application/json" {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, RECEIVER_MAX_REQUEST_SIZE)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = &decompressedBodyReader{reader: io.LimitReader(gzipReader, RECEIVER_MAX_REQUEST_SIZE+1)}
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7211).Errorf("ReceiverService : For datasource %v error reading OTLP logs request from %v : %+v", g.dsName, r.RemoteAddr, err)
+		if isReceiverRequestTooLarge(err) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	var records []receivedRecord
+	if contentType == "application/json" {
+		records, err = parseOtlpLogsJson(data, g.now())
+	} else {
+		records, err = parseOtlpLogsProto(data, g.now())
+	}
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7211).Warnf("ReceiverService : For datasource %v OTLP logs request from %v can not be parsed and is skipped : %+v", g.dsName, r.RemoteAddr, err)
+		http.Error(w, fmt.Sprintf("Bad Request : %v", err), http.StatusBadRequest)
+		return
+	}
+	for _, record := range records {
+		g.addRecord(record.timestamp, record.fields)
+	}
+	log.Debugf("ReceiverService : For datasource %v %v OTLP log records are received from %v", g.dsName, len(records), r.RemoteAddr)
+
+	// the ExportLogsServiceResponse without partial_success is empty in both encodings
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if contentType == "application/json" {
+		_, _ = w.Write([]byte("{}"))
+	}
+}
+
+// newOtlpRecord makes the record fields from the fields of the resource and the scope and the log record
+func newOtlpRecord(scopeFields map[string]string, logRecord *otlpLogRecord, now time.Time) receivedRecord {
+	fields := make(map[string]string, len(scopeFields)+len(logRecord.attributes)+4)
+	for k, v := range scopeFields {
+		fields[k] = v
+	}
+	flattenJsonObject("", logRecord.attributes, fields)
+	if bodyObject, ok := logRecord.body.(map[string]interface{}); ok {
+		flattenJsonObject(OTLP_BODY_FIELD_NAME+".", bodyObject, fields)
+	} else if logRecord.body != nil {
+		fields[OTLP_BODY_FIELD_NAME] = formatJsonValue(logRecord.body)
+	}
+	if logRecord.severityText != "" {
+		fields["severity_text"] = logRecord.severityText
+	}
+	if logRecord.severityNumber != 0 {
+		fields["severity_number"] = strconv.FormatInt(logRecord.severityNumber, 10)
+	}
+	if logRecord.traceId != "" {
+		fields["trace_id"] = logRecord.traceId
+	}
+	if logRecord.spanId != "" {
+		fields["span_id"] = logRecord.spanId
+	}
+	if logRecord.eventName != "" {
+		fields["event_name"] = logRecord.eventName
+	}
+
+	timestamp := now
+	if logRecord.timeUnixNano != 0 {
+		timestamp = time.Unix(0, int64(logRecord.timeUnixNano))
+	} else if logRecord.observedTimeUnixNano != 0 {
+		timestamp = time.Unix(0, int64(logRecord.observedTimeUnixNano))
+	}
+	fields["timestamp"] = timestamp.UTC().Format(time.RFC3339Nano)
+	return receivedRecord{timestamp: timestamp, fields: fields}
+}
+
+func otlpScopeFields(resourceFields map[string]string, name string, version string, attributes map[string]interface{}) map[string]string {
+	fields := make(map[string]string, len(resourceFields)+len(attributes)+2)
+	for k, v := range resourceFields {
+		fields[k] = v
+	}
+	flattenJsonObject(OTLP_SCOPE_PREFIX, attributes, fields)
+	if name != "" {
+		fields[OTLP_SCOPE_PREFIX+"name"] = name
+	}
+	if version != "" {
+		fields[OTLP_SCOPE_PREFIX+"version"] = version
+	}
+	return fields
+}
+
+// Binary protobuf encoding. The messages of opentelemetry/proto/logs/v1/logs.proto are decoded field by field,
+// unknown fields are skipped
+
+func parseOtlpLogsProto(data []byte, now time.Time) ([]receivedRecord, error) {
+	records := make([]receivedRecord, 0)
+	err := forEachProtoField(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if num == 1 && typ == protowire.BytesType {
+			return parseOtlpProtoResourceLogs(value, now, &records)
+		}
+		return nil
+	})
+	return records, err
+}
+
+func parseOtlpProtoResourceLogs(data []byte, now time.Time, records *[]receivedRecord) error {
+	resourceAttributes := make(map[string]interface{})
+	scopeLogsList := make([][]byte, 0)
+	err := forEachProtoField(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			return forEachProtoField(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+				if num == 1 && typ == protowire.BytesType {
+					return parseOtlpProtoKeyValue(value, resourceAttributes, 0)
+				}
+				return nil
+			})
+		case 2:
+			scopeLogsList = append(scopeLogsList, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	resourceFields := make(map[string]string, len(resourceAttributes))
+	flattenJsonObject(OTLP_RESOURCE_PREFIX, resourceAttributes, resourceFields)
+	for _, scopeLogs := range scopeLogsList {
+		if err = parseOtlpProtoScopeLogs(scopeLogs, resourceFields, now, records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseOtlpProtoScopeLogs(data []byte, resourceFields map[string]string, now time.Time, records *[]receivedRecord) error {
+	var name, version string
+	scopeAttributes := make(map[string]interface{})
+	logRecords := make([]*otlpLogRecord, 0)
+	err := forEachProtoField(data, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			return forEachProtoField(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+				if typ != protowire.BytesType {
+					return nil
+				}
+				switch num {
+				case 1:
+					name = string(value)
+				case 2:
+					version = string(value)
+				case 3:
+					return parseOtlpProtoKeyValue(value, scopeAttributes, 0)
+				}
+				return nil
+			})
+		case 2:
+			logRecord, err := parseOtlpProtoLogRecord(value)
+			if err != nil {
+				return err
+			}
+			logRecords = append(logRecords, logRecord)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	scopeFields := otlpScopeFields(resourceFields, name, version, scopeAttributes)
+	for _, logRecord := range logRecords {
+		*records = append(*records, newOtlpRecord(scopeFields, logRecord, now))
+	}
+	return nil
+}
+
+func parseOtlpProtoLogRecord(data []byte) (*otlpLogRecord, error) {
+	logRecord := otlpLogRecord{attributes: make(map[string]interface{})}
+	err := forEachProtoField(data, func(num protowire.Number, typ protowire.Type, value []byte, number uint64) error {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			logRecord.timeUnixNano = number
+		case num == 11 && typ == protowire.Fixed64Type:
+			logRecord.observedTimeUnixNano = number
+		case num == 2 && typ == protowire.VarintType:
+			logRecord.severityNumber = int64(number)
+		case num == 3 && typ == protowire.BytesType:
+			logRecord.severityText = string(value)
+		case num == 5 && typ == protowire.BytesType:
+			body, err := parseOtlpProtoAnyValue(value, 0)
+			if err != nil {
+				return err
+			}
+			logRecord.body = body
+		case num == 6 && typ == protowire.BytesType:
+			return parseOtlpProtoKeyValue(value, logRecord.attributes, 0)
+		case num == 9 && typ == protowire.BytesType:
+			logRecord.traceId = hex.EncodeToString(value)
+		case num == 10 && typ == protowire.BytesType:
+			logRecord.spanId = hex.EncodeToString(value)
+		case num == 12 && typ == protowire.BytesType:
+			logRecord.eventName = string(value)
+		}
+		return nil
+	})
+	return &logRecord, err
+}
+
+func parseOtlpProtoKeyValue(data []byte, result map[string]interface{}, depth int) error {
+	var key string
+	var value interface{}
+	err := forEachProtoField(data, func(num protowire.Number, typ protowire.Type, data []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			key = string(data)
+		case 2:
+			var err error
+			value, err = parseOtlpProtoAnyValue(data, depth)
+			return err
+		}
+		return nil
+	})
+	if err == nil && key != "" {
+		result[key] = value
+	}
+	return err
+}
+
+// parseOtlpProtoAnyValue converts AnyValue into the same types as the JSON decoder produces, so the values are
+// formatted and flattened in the same way as JSON: key-value lists become objects, arrays keep their JSON representation.
+// The values nested deeper than OTLP_MAX_VALUE_DEPTH are rejected
+func parseOtlpProtoAnyValue(data []byte, depth int) (interface{}, error) {
+	if depth > OTLP_MAX_VALUE_DEPTH {
+		return nil, fmt.Errorf("value is nested deeper than %v levels", OTLP_MAX_VALUE_DEPTH)
+	}
+	var result interface{}
+	err := forEachProtoField(data, func(num protowire.Number, typ protowire.Type, value []byte, number uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			result = string(value)
+		case num == 2 && typ == protowire.VarintType:
+			result = number != 0
+		case num == 3 && typ == protowire.VarintType:
+			result = json.Number(strconv.FormatInt(int64(number), 10))
+		case num == 4 && typ == protowire.Fixed64Type:
+			result = json.Number(strconv.FormatFloat(math.Float64frombits(number), 'g', -1, 64))
+		case num == 5 && typ == protowire.BytesType:
+			values := make([]interface{}, 0)
+			err := forEachProtoField(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+				if num == 1 && typ == protowire.BytesType {
+					item, err := parseOtlpProtoAnyValue(value, depth+1)
+					values = append(values, item)
+					return err
+				}
+				return nil
+			})
+			result = values
+			return err
+		case num == 6 && typ == protowire.BytesType:
+			values := make(map[string]interface{})
+			err := forEachProtoField(value, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+				if num == 1 && typ == protowire.BytesType {
+					return parseOtlpProtoKeyValue(value, values, depth+1)
+				}
+				return nil
+			})
+			result = values
+			return err
+		case num == 7 && typ == protowire.BytesType:
+			result = base64.StdEncoding.EncodeToString(value)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// forEachProtoField calls handle for every field of the message with the field value:
+// the content for the length-delimited fields and the number for the others
+func forEachProtoField(data []byte, handle func(num protowire.Number, typ protowire.Type, value []byte, number uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value []byte
+		var number uint64
+		switch typ {
+		case protowire.VarintType:
+			number, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			number, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var number32 uint32
+			number32, n = protowire.ConsumeFixed32(data)
+			number = uint64(number32)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := handle(num, typ, value, number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JSON encoding. The field names are lowerCamelCase, 64-bit integers may be strings,
+// trace and span IDs are hex strings, bytes values are base64 strings
+
+type otlpJsonRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpJsonKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			Scope struct {
+				Name       string             `json:"name"`
+				Version    string             `json:"version"`
+				Attributes []otlpJsonKeyValue `json:"attributes"`
+			} `json:"scope"`
+			LogRecords []struct {
+				TimeUnixNano         json.Number        `json:"timeUnixNano"`
+				ObservedTimeUnixNano json.Number        `json:"observedTimeUnixNano"`
+				SeverityNumber       json.Number        `json:"severityNumber"`
+				SeverityText         string             `json:"severityText"`
+				Body                 *otlpJsonAnyValue  `json:"body"`
+				Attributes           []otlpJsonKeyValue `json:"attributes"`
+				TraceId              string             `json:"traceId"`
+				SpanId               string             `json:"spanId"`
+				EventName            string             `json:"eventName"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpJsonKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJsonAnyValue `json:"value"`
+}
+
+type otlpJsonAnyValue struct {
+	StringValue *string     `json:"stringValue"`
+	BoolValue   *bool       `json:"boolValue"`
+	IntValue    json.Number `json:"intValue"`
+	DoubleValue json.Number `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpJsonAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJsonKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue *string `json:"bytesValue"`
+}
+
+func parseOtlpLogsJson(data []byte, now time.Time) ([]receivedRecord, error) {
+	var request otlpJsonRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+	records := make([]receivedRecord, 0)
+	for _, resourceLogs := range request.ResourceLogs {
+		resourceFields := make(map[string]string)
+		flattenJsonObject(OTLP_RESOURCE_PREFIX, otlpJsonAttributes(resourceLogs.Resource.Attributes), resourceFields)
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			scope := scopeLogs.Scope
+			scopeFields := otlpScopeFields(resourceFields, scope.Name, scope.Version, otlpJsonAttributes(scope.Attributes))
+			for _, jsonRecord := range scopeLogs.LogRecords {
+				logRecord := otlpLogRecord{
+					severityText: jsonRecord.SeverityText,
+					attributes:   otlpJsonAttributes(jsonRecord.Attributes),
+					traceId:      jsonRecord.TraceId,
+					spanId:       jsonRecord.SpanId,
+					eventName:    jsonRecord.EventName,
+				}
+				var err error
+				if logRecord.timeUnixNano, err = parseOtlpJsonUint(jsonRecord.TimeUnixNano); err != nil {
+					return nil, fmt.Errorf("incorrect timeUnixNano %v", jsonRecord.TimeUnixNano)
+				}
+				if logRecord.observedTimeUnixNano, err = parseOtlpJsonUint(jsonRecord.ObservedTimeUnixNano); err != nil {
+					return nil, fmt.Errorf("incorrect observedTimeUnixNano %v", jsonRecord.ObservedTimeUnixNano)
+				}
+				if jsonRecord.SeverityNumber != "" {
+					if logRecord.severityNumber, err = jsonRecord.SeverityNumber.Int64(); err != nil {
+						return nil, fmt.Errorf("incorrect severityNumber %v", jsonRecord.SeverityNumber)
+					}
+				}
+				if jsonRecord.Body != nil {
+					logRecord.body = jsonRecord.Body.value()
+				}
+				records = append(records, newOtlpRecord(scopeFields, &logRecord, now))
+			}
+		}
+	}
+	return records, nil
+}
+
+func parseOtlpJsonUint(value json.Number) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	result, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return 0, errors.New("not an unsigned integer")
+	}
+	return result, nil
+}
+
+func otlpJsonAttributes(keyValues []otlpJsonKeyValue) map[string]interface{} {
+	result := make(map[string]interface{}, len(keyValues))
+	for _, kv := range keyValues {
+		if kv.Key != "" {
+			result[kv.Key] = kv.Value.value()
+		}
+	}
+	return result
+}
+
+func (v *otlpJsonAnyValue) value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != "":
+		return v.IntValue
+	case v.DoubleValue != "":
+		return v.DoubleValue
+	case v.ArrayValue != nil:
+		values := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for i := range v.ArrayValue.Values {
+			values = append(values, v.ArrayValue.Values[i].value())
+		}
+		return values
+	case v.KvlistValue != nil:
+		return otlpJsonAttributes(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	}
+	return nil
+}
@@ -21,7 +21,6 @@ import (
 	"log_exporter/internal/config"
 	ec "log_exporter/internal/utils/errorcodes"
 	"math"
-	"net"
 	"net/http"
 	"time"
 
@@ -70,16 +69,7 @@ func (p *PromRWService) WriteMetrics(metricFamilies []*dto.MetricFamily, queryNa
 		httpReq.SetBasicAuth(p.exportConfig.User, p.exportConfig.Password)
 	}
 
-	var transport http.RoundTripper = &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: p.exportConfig.ConnectionTimeout,
-		}).DialContext,
-		TLSClientConfig: p.exportConfig.TlsConfig,
-	}
-	client := http.Client{
-		Transport: transport,
-		Timeout:   p.exportConfig.ConnectionTimeout,
-	}
+	client := GetHTTPClient(&p.exportConfig.TLSHostConfig)
 	log.Infof("PromRWService : For query %v sending request, size of encoded message is %v bytes", queryName, encodedSize)
 	resp, err := client.Do(httpReq)
 	if err != nil {
@@ -88,6 +78,7 @@ func (p *PromRWService) WriteMetrics(metricFamilies []*dto.MetricFamily, queryNa
 
 	if resp.Body != nil {
 		defer func() {
+			_, _ = io.Copy(io.Discard, resp.Body) // the body must be read to the end to reuse the connection
 			if err := resp.Body.Close(); err != nil {
 				log.Errorf("PromRWService : Error closing response body : %+v", err)
 			}
@@ -158,6 +149,10 @@ func (p *PromRWService) metricFamilyToPrompb(in *dto.MetricFamily) ([]prompb.Tim
 			if metric.Histogram == nil {
 				return res, fmt.Errorf("expected histogram in metric %v : %+v", name, metric)
 			}
+			if metric.Histogram.Schema != nil {
+				res = append(res, p.getNativeHistogramTimeSeries(name, metric))
+				continue
+			}
 			infSeen := false
 			for _, b := range metric.Histogram.Bucket {
 				res = append(res, p.getTimeSeries(name, "_bucket", metric, model.BucketLabel, b.GetUpperBound(), float64(b.GetCumulativeCount())))
@@ -201,3 +196,32 @@ func (p *PromRWService) getTimeSeries(name, suffix string, metric *dto.Metric, a
 	}
 	return res
 }
+
+// getNativeHistogramTimeSeries converts the native histogram to the time series with the histogram sample,
+// the spans and the deltas of the exponential buckets are passed as is
+func (p *PromRWService) getNativeHistogramTimeSeries(name string, metric *dto.Metric) prompb.TimeSeries {
+	res := p.getTimeSeries(name, "", metric, "", 0, 0)
+	hist := metric.Histogram
+	res.Histograms = []prompb.Histogram{{
+		Count:          &prompb.Histogram_CountInt{CountInt: hist.GetSampleCount()},
+		Sum:            hist.GetSampleSum(),
+		Schema:         hist.GetSchema(),
+		ZeroThreshold:  hist.GetZeroThreshold(),
+		ZeroCount:      &prompb.Histogram_ZeroCountInt{ZeroCountInt: hist.GetZeroCount()},
+		NegativeSpans:  toPrompbBucketSpans(hist.GetNegativeSpan()),
+		NegativeDeltas: hist.GetNegativeDelta(),
+		PositiveSpans:  toPrompbBucketSpans(hist.GetPositiveSpan()),
+		PositiveDeltas: hist.GetPositiveDelta(),
+		Timestamp:      res.Samples[0].Timestamp,
+	}}
+	res.Samples = nil
+	return res
+}
+
+func toPrompbBucketSpans(spans []*dto.BucketSpan) []prompb.BucketSpan {
+	res := make([]prompb.BucketSpan, 0, len(spans))
+	for _, span := range spans {
+		res = append(res, prompb.BucketSpan{Offset: span.GetOffset(), Length: span.GetLength()})
+	}
+	return res
+}
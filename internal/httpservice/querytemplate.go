@@ -0,0 +1,111 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"bytes"
+	"fmt"
+	"log_exporter/internal/config"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	NEWRELIC_TEMPLATE_TIME_LAYOUT     = "2006-01-02 15:04:05 MST"
+	GRAYLOG_TEMPLATE_TIME_LAYOUT      = "2006-01-02 15:04:05.000"
+	LOKI_TEMPLATE_TIME_LAYOUT         = time.RFC3339Nano
+	OPENSEARCH_TEMPLATE_TIME_LAYOUT   = time.RFC3339Nano
+	VICTORIALOGS_TEMPLATE_TIME_LAYOUT = time.RFC3339Nano
+	CLICKHOUSE_TEMPLATE_TIME_LAYOUT   = "2006-01-02 15:04:05"
+)
+
+// QueryTemplateContext contains the variables available in the query_string templates
+type QueryTemplateContext struct {
+	QueryName string
+	// StartTime and EndTime are formatted in the layout native for the datasource query language
+	StartTime        string
+	EndTime          string
+	StartTimeRFC3339 string
+	EndTimeRFC3339   string
+	StartTimeUnix    int64
+	EndTimeUnix      int64
+	// Interval is the length of the time range, for example "60s", suitable for LogQL and PromQL range selectors
+	Interval string
+	// Env contains only the environment variables listed in query-template-env of the general section
+	Env    map[string]string
+	Labels map[string]string
+}
+
+// RenderQueryString executes query_string of the query as a text/template. Query strings without actions are returned as is.
+// The time layout is used for StartTime and EndTime; Graylog and ClickHouse times are converted to UTC.
+func RenderQueryString(appConfig *config.Config, qName string, startTime time.Time, endTime time.Time, timeLayout string) (string, error) {
+	qCfg := appConfig.Queries[qName]
+	if !strings.Contains(qCfg.QueryString, "{{") {
+		return qCfg.QueryString, nil
+	}
+	if timeLayout == GRAYLOG_TEMPLATE_TIME_LAYOUT || timeLayout == CLICKHOUSE_TEMPLATE_TIME_LAYOUT {
+		startTime = startTime.UTC()
+		endTime = endTime.UTC()
+	}
+
+	templateCtx := QueryTemplateContext{
+		QueryName:        qName,
+		StartTime:        startTime.Format(timeLayout),
+		EndTime:          endTime.Format(timeLayout),
+		StartTimeRFC3339: startTime.Format(time.RFC3339),
+		EndTimeRFC3339:   endTime.Format(time.RFC3339),
+		StartTimeUnix:    startTime.Unix(),
+		EndTimeUnix:      endTime.Unix(),
+		Interval:         formatTemplateInterval(endTime.Sub(startTime)),
+		Env:              make(map[string]string),
+		Labels:           make(map[string]string),
+	}
+	if appConfig.General != nil {
+		templateCtx.Env = getEnvironmentMap(appConfig.General.QueryTemplateEnv)
+	}
+	if dsConfig := appConfig.Datasources[qCfg.Datasource]; dsConfig != nil && dsConfig.Labels != nil {
+		templateCtx.Labels = dsConfig.Labels
+	}
+
+	tmpl, err := template.New("query_template").Parse(qCfg.QueryString)
+	if err != nil {
+		return "", fmt.Errorf("error creating template for query %v : %+v", qName, err)
+	}
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, templateCtx)
+	if err != nil {
+		return "", fmt.Errorf("error executing template for query %v : %+v", qName, err)
+	}
+	return buf.String(), nil
+}
+
+func formatTemplateInterval(interval time.Duration) string {
+	if interval%time.Second == 0 {
+		return fmt.Sprintf("%ds", interval/time.Second)
+	}
+	return fmt.Sprintf("%dms", interval/time.Millisecond)
+}
+
+func getEnvironmentMap(names []string) map[string]string {
+	result := make(map[string]string, len(names))
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			result[name] = value
+		}
+	}
+	return result
+}
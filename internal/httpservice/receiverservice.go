@@ -0,0 +1,322 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log_exporter/internal/config"
+	"log_exporter/internal/evaluator/conditions"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RECEIVER_MAX_RECORDS_DEFAULT = 100000
+	RECEIVER_JSON_LINES_ENDPOINT = "/api/v1/logs/jsonlines"
+	RECEIVER_MAX_LINE_SIZE       = 1024 * 1024
+	RECEIVER_MAX_REQUEST_SIZE    = 64 * 1024 * 1024
+)
+
+// errReceiverRequestTooLarge is returned by the reader of the decompressed request body exceeding RECEIVER_MAX_REQUEST_SIZE
+var errReceiverRequestTooLarge = errors.New("decompressed request body exceeds the max request size")
+
+type receivedRecord struct {
+	timestamp time.Time
+	fields    map[string]string
+}
+
+// receiverBuffer keeps the records received for the query until the window they belong to is closed.
+// The records are selected with the query conditions applied to the row of the condition fields
+type receiverBuffer struct {
+	sync.Mutex
+	records         []receivedRecord
+	dropped         int
+	condition       *conditions.MECondition
+	conditionFields []string
+}
+
+// ReceiverService receives the log records pushed by the log shippers (syslog over UDP and TCP, JSON lines and OTLP logs over HTTP)
+// and buffers them per query. The records are selected for the query with the query conditions
+// and are handed over by timestamp when the query time range is executed
+type ReceiverService struct {
+	appConfig  *config.Config
+	dsConfig   *config.DatasourceConfig
+	dsName     string
+	maxRecords int
+	buffers    map[string]*receiverBuffer
+	now        func() time.Time
+}
+
+func CreateReceiverService(appConfig *config.Config, dsName string) *ReceiverService {
+	g := ReceiverService{}
+	g.appConfig = appConfig
+	g.dsName = dsName
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.maxRecords = g.dsConfig.MaxRecords
+	if g.maxRecords <= 0 {
+		g.maxRecords = RECEIVER_MAX_RECORDS_DEFAULT
+	}
+	g.now = time.Now
+	g.buffers = make(map[string]*receiverBuffer)
+	for qName, qConfig := range appConfig.Queries {
+		if qConfig != nil && qConfig.Datasource == dsName {
+			g.buffers[qName] = newReceiverBuffer(qName, qConfig.Cond)
+		}
+	}
+	log.Infof("ReceiverService : Service created for datasource %v with %v queries and max records %v", dsName, len(g.buffers), g.maxRecords)
+	return &g
+}
+
+func newReceiverBuffer(qName string, cond []map[string]map[string]string) *receiverBuffer {
+	result := receiverBuffer{records: make([]receivedRecord, 0)}
+	if len(cond) == 0 {
+		return &result
+	}
+	fieldsSet := make(map[string]bool)
+	for _, condition := range cond {
+		for fieldName := range condition[conditions.EQU_COND_NAME] {
+			if !fieldsSet[fieldName] {
+				fieldsSet[fieldName] = true
+				result.conditionFields = append(result.conditionFields, fieldName)
+			}
+		}
+	}
+	result.condition = conditions.CreateMECondition(qName, cond, result.conditionFields)
+	return &result
+}
+
+// matches checks the record against the query conditions, the record matches if the query has no conditions
+func (b *receiverBuffer) matches(fields map[string]string) bool {
+	if b.condition == nil {
+		return true
+	}
+	row := make([]string, len(b.conditionFields))
+	for i, fieldName := range b.conditionFields {
+		row[i] = fields[fieldName]
+	}
+	return b.condition.Apply(row)
+}
+
+// Capabilities disables retries and splitting, the records are buffered locally
+func (g *ReceiverService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{}
+}
+
+// Start starts the configured listeners
+func (g *ReceiverService) Start() {
+	if g.dsConfig.SyslogUdpAddress != "" {
+		go g.listenSyslogUdp(g.dsConfig.SyslogUdpAddress)
+	}
+	if g.dsConfig.SyslogTcpAddress != "" {
+		go g.listenSyslogTcp(g.dsConfig.SyslogTcpAddress)
+	}
+	if g.dsConfig.HttpAddress != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(RECEIVER_JSON_LINES_ENDPOINT, g.jsonLinesHandler)
+		mux.HandleFunc(RECEIVER_OTLP_LOGS_ENDPOINT, g.otlpLogsHandler)
+		server := &http.Server{
+			Addr:              g.dsConfig.HttpAddress,
+			Handler:           mux,
+			ReadHeaderTimeout: time.Second * 30,
+		}
+		go func() {
+			log.Infof("ReceiverService : For datasource %v HTTP receiver is listening on %v", g.dsName, g.dsConfig.HttpAddress)
+			log.WithField(ec.FIELD, ec.LME_7210).Errorf("ReceiverService : For datasource %v HTTP receiver is stopped : %+v", g.dsName, server.ListenAndServe())
+		}()
+	}
+}
+
+func (g *ReceiverService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	now := time.Now()
+	var err error
+	defer func() {
+		log.Debugf("ReceiverService : For query %v buffered records are processed in %+v", qName, time.Since(now))
+		if err != nil && !errors.Is(err, ErrResponseTruncated) {
+			selfMonitorIncErrorCodeCount(qName, now)
+		} else {
+			selfMonitorRefreshErrorCodeCount(qName, now)
+		}
+	}()
+
+	records, errc, err := g.takeRecords(qName, endTime)
+	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
+	if errors.Is(err, ErrResponseTruncated) {
+		selfMonitorIncResponseTruncatedCount(qName, now)
+	} else {
+		selfMonitorRefreshResponseTruncatedCount(qName, now)
+	}
+	return records, errc, err
+}
+
+// takeRecords removes the records with the timestamp before the end of the time range from the buffer of the query
+// and turns them into rows. The late records, whose window is already closed, are handed over with the current window.
+// If some records were dropped because the buffer was full, the LME-7212 error code and wrapped ErrResponseTruncated are returned
+func (g *ReceiverService) takeRecords(qName string, endTime time.Time) ([][]string, string, error) {
+	buffer := g.buffers[qName]
+	if buffer == nil {
+		return nil, ec.LME_1604, fmt.Errorf("ReceiverService : Query %v does not belong to datasource %v", qName, g.dsName)
+	}
+
+	buffer.Lock()
+	entries := make([]map[string]string, 0)
+	rest := make([]receivedRecord, 0, len(buffer.records))
+	for _, record := range buffer.records {
+		if record.timestamp.Before(endTime) {
+			entries = append(entries, record.fields)
+		} else {
+			rest = append(rest, record)
+		}
+	}
+	buffer.records = rest
+	dropped := buffer.dropped
+	buffer.dropped = 0
+	buffer.Unlock()
+
+	records := fieldMapsToRecords(entries, g.appConfig.Queries[qName].FieldsInOrder)
+	log.Infof("ReceiverService : For query %v %v records are handed over, %v records are left in the buffer", qName, len(entries), len(rest))
+	log.Debugf("ReceiverService : For query %v result len = %v, result records = %+v", qName, len(records), records)
+	if dropped > 0 {
+		log.WithField(ec.FIELD, ec.LME_7212).Warnf("ReceiverService : For query %v %v records are dropped, because the buffer contains max-records %v records", qName, dropped, g.maxRecords)
+		return records, ec.LME_7212, fmt.Errorf("ReceiverService : For query %v %v records are dropped : %w", qName, dropped, ErrResponseTruncated)
+	}
+	return records, "", nil
+}
+
+// addRecord puts the record to the buffers of the queries whose conditions it matches
+func (g *ReceiverService) addRecord(timestamp time.Time, fields map[string]string) {
+	for _, buffer := range g.buffers {
+		if !buffer.matches(fields) {
+			continue
+		}
+		buffer.Lock()
+		if len(buffer.records) >= g.maxRecords {
+			buffer.dropped++
+		} else {
+			buffer.records = append(buffer.records, receivedRecord{timestamp: timestamp, fields: fields})
+		}
+		buffer.Unlock()
+	}
+}
+
+// jsonLinesHandler receives the JSON objects, one per line. Nested objects are flattened with the dotted names.
+// The request body is limited by RECEIVER_MAX_REQUEST_SIZE before and after the decompression. The records are buffered
+// only if the whole request is read, so the request retried by the shipper after the error does not duplicate them.
+// The record timestamp is taken from the timestamp-field of the datasource, if it is set and can be parsed
+func (g *ReceiverService) jsonLinesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, RECEIVER_MAX_REQUEST_SIZE)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = &decompressedBodyReader{reader: io.LimitReader(gzipReader, RECEIVER_MAX_REQUEST_SIZE+1)}
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), RECEIVER_MAX_LINE_SIZE)
+	records := make([]receivedRecord, 0)
+	invalid := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		var value map[string]interface{}
+		if err := decoder.Decode(&value); err != nil || value == nil {
+			invalid++
+			continue
+		}
+		fields := make(map[string]string, len(value))
+		flattenJsonObject("", value, fields)
+		records = append(records, receivedRecord{timestamp: g.getRecordTimestamp(fields), fields: fields})
+	}
+	if err := scanner.Err(); err != nil {
+		log.WithField(ec.FIELD, ec.LME_7211).Errorf("ReceiverService : For datasource %v error reading JSON lines request from %v, %v records are not received : %+v", g.dsName, r.RemoteAddr, len(records), err)
+		if isReceiverRequestTooLarge(err) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	for _, record := range records {
+		g.addRecord(record.timestamp, record.fields)
+	}
+	if invalid > 0 {
+		log.WithField(ec.FIELD, ec.LME_7211).Warnf("ReceiverService : For datasource %v %v lines from %v are not JSON objects and are skipped", g.dsName, invalid, r.RemoteAddr)
+	}
+	log.Debugf("ReceiverService : For datasource %v %v records are received from %v", g.dsName, len(records), r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decompressedBodyReader reads the decompressed request body limited to RECEIVER_MAX_REQUEST_SIZE + 1 bytes
+// and returns errReceiverRequestTooLarge if it is longer than RECEIVER_MAX_REQUEST_SIZE, so the rest is not dropped silently
+type decompressedBodyReader struct {
+	reader io.Reader
+	size   int64
+}
+
+func (d *decompressedBodyReader) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	d.size += int64(n)
+	if d.size > RECEIVER_MAX_REQUEST_SIZE {
+		return n, errReceiverRequestTooLarge
+	}
+	return n, err
+}
+
+// isReceiverRequestTooLarge returns true if the compressed or the decompressed request body exceeds RECEIVER_MAX_REQUEST_SIZE
+func isReceiverRequestTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError) || errors.Is(err, errReceiverRequestTooLarge)
+}
+
+// getRecordTimestamp parses the timestamp field as RFC 3339 time or Unix time in seconds or milliseconds.
+// If the field is not configured or can not be parsed, the receive time is used
+func (g *ReceiverService) getRecordTimestamp(fields map[string]string) time.Time {
+	if g.dsConfig.TimestampField == "" {
+		return g.now()
+	}
+	value := fields[g.dsConfig.TimestampField]
+	if ts, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return ts
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		if number > 1e11 {
+			return time.UnixMilli(int64(number))
+		}
+		return time.Unix(0, int64(number*float64(time.Second)))
+	}
+	return g.now()
+}
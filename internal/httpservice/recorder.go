@@ -0,0 +1,426 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log_exporter/internal/config"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RECORD_MAX_FILES_DEFAULT = 1440
+	RECORD_FILE_TIME_LAYOUT  = "20060102T150405.000Z"
+	RECORD_FILE_SUFFIX       = ".zip"
+	RECORD_CALLS_FILE_NAME   = "calls.json"
+)
+
+// RecordedWindow is the query execution for the time window kept in the archive
+type RecordedWindow struct {
+	StartTime time.Time
+	EndTime   time.Time
+	Path      string
+}
+
+// RecordedCall is the datasource call of the query execution with the HTTP responses it received.
+// The execution consists of several calls if its time range is split
+type RecordedCall struct {
+	StartTime time.Time           `json:"startTime"`
+	EndTime   time.Time           `json:"endTime"`
+	Responses []*RecordedResponse `json:"responses"`
+}
+
+// RecordedResponse is the HTTP response of the datasource. The body contains only the part read by the datasource,
+// for example the rest of the truncated response is not recorded
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	File       string      `json:"file"`
+	Body       []byte      `json:"-"`
+}
+
+// ResponseRecorder writes the raw HTTP responses of the datasource to the archive. The responses of the calls used
+// by the query execution are written to the file <path>/<query name>/<start>_<end>.zip, the oldest files of the query
+// are removed when there are more than max-files of them or they are older than max-age
+type ResponseRecorder struct {
+	dsName     string
+	dsConfig   *config.DatasourceConfig
+	mutex      sync.Mutex
+	executions map[string]*recordedExecution
+	queryMutex sync.Map
+}
+
+// recordedExecution keeps the calls of the current query execution until the execution is completed
+type recordedExecution struct {
+	calls   []*RecordedCall
+	current *RecordedCall
+}
+
+var (
+	responseRecordersMutex sync.Mutex
+	responseRecorders      = make(map[*config.DatasourceConfig]*ResponseRecorder)
+)
+
+// GetResponseRecorder returns the recorder of the datasource, it is shared by the calls processor, which marks
+// the query executions and the calls, and by the datasource, which records the responses.
+// It returns nil if the recording is not configured for the datasource
+func GetResponseRecorder(appConfig *config.Config, dsName string) *ResponseRecorder {
+	dsConfig := appConfig.Datasources[dsName]
+	if dsConfig.Record == nil {
+		return nil
+	}
+	responseRecordersMutex.Lock()
+	defer responseRecordersMutex.Unlock()
+	if recorder, ok := responseRecorders[dsConfig]; ok {
+		return recorder
+	}
+	recorder := &ResponseRecorder{dsName: dsName, dsConfig: dsConfig, executions: make(map[string]*recordedExecution)}
+	responseRecorders[dsConfig] = recorder
+	log.Infof("HTTP responses of datasource %v are recorded to %v", dsName, dsConfig.Record.Path)
+	return recorder
+}
+
+// BeginExecution starts the query execution, the calls left from the previous execution are dropped
+func (r *ResponseRecorder) BeginExecution(qName string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.executions[qName] = &recordedExecution{}
+}
+
+// BeginCall starts the datasource call for the time range, the responses read by the datasource are added to the call
+func (r *ResponseRecorder) BeginCall(qName string, startTime time.Time, endTime time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.execution(qName).current = &RecordedCall{StartTime: startTime, EndTime: endTime}
+}
+
+// EndCall completes the datasource call, the responses of the failed call are dropped
+func (r *ResponseRecorder) EndCall(qName string, succeeded bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	execution := r.execution(qName)
+	if succeeded && execution.current != nil {
+		execution.calls = append(execution.calls, execution.current)
+	}
+	execution.current = nil
+}
+
+func (r *ResponseRecorder) execution(qName string) *recordedExecution {
+	execution := r.executions[qName]
+	if execution == nil {
+		execution = &recordedExecution{}
+		r.executions[qName] = execution
+	}
+	return execution
+}
+
+// recordResponse wraps the response body, the response is added to the current call of the query when the body is closed
+func (r *ResponseRecorder) recordResponse(qName string, resp *http.Response) {
+	response := &RecordedResponse{StatusCode: resp.StatusCode, Header: resp.Header.Clone()}
+	resp.Body = &recordingBody{ReadCloser: resp.Body, onClose: func(body []byte) {
+		response.Body = body
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if call := r.execution(qName).current; call != nil {
+			call.Responses = append(call.Responses, response)
+		} else {
+			log.Debugf("For datasource %v response of query %v is received outside of the datasource call and is not recorded", r.dsName, qName)
+		}
+	}}
+}
+
+// recordingBody keeps the part of the response body read by the datasource
+type recordingBody struct {
+	io.ReadCloser
+	body    bytes.Buffer
+	once    sync.Once
+	onClose func(body []byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.body.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.once.Do(func() { b.onClose(b.body.Bytes()) })
+	return b.ReadCloser.Close()
+}
+
+// Record writes the calls of the query execution for the time window, the errors are logged.
+// The execution without responses, for example of the datasource which does not send HTTP requests, is not recorded
+func (r *ResponseRecorder) Record(qName string, startTime time.Time, endTime time.Time) {
+	r.mutex.Lock()
+	execution := r.executions[qName]
+	delete(r.executions, qName)
+	r.mutex.Unlock()
+	if execution == nil {
+		return
+	}
+	calls := usedRecordedCalls(execution.calls)
+	responses := 0
+	for _, call := range calls {
+		responses += len(call.Responses)
+	}
+	if responses == 0 {
+		log.Debugf("For datasource %v execution of query %v, startTime %v, endTime %v has no responses to record", r.dsName, qName, startTime, endTime)
+		return
+	}
+
+	mutex, _ := r.queryMutex.LoadOrStore(qName, &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	defer mutex.(*sync.Mutex).Unlock()
+	if err := r.record(qName, startTime, endTime, calls); err != nil {
+		log.WithField(ec.FIELD, ec.LME_7220).Errorf("For datasource %v error recording responses of query %v, startTime %v, endTime %v : %+v", r.dsName, qName, startTime, endTime, err)
+	}
+}
+
+// usedRecordedCalls drops the calls whose results are not used by the execution: the time range of such call is split
+// or retried, so a later call covers a part of it
+func usedRecordedCalls(calls []*RecordedCall) []*RecordedCall {
+	result := make([]*RecordedCall, 0, len(calls))
+	for i, call := range calls {
+		used := true
+		for _, later := range calls[i+1:] {
+			if !later.StartTime.Before(call.StartTime) && !later.EndTime.After(call.EndTime) {
+				used = false
+				break
+			}
+		}
+		if used {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+func (r *ResponseRecorder) record(qName string, startTime time.Time, endTime time.Time, calls []*RecordedCall) error {
+	dir := filepath.Join(r.dsConfig.Record.Path, qName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fileName := startTime.UTC().Format(RECORD_FILE_TIME_LAYOUT) + "_" + endTime.UTC().Format(RECORD_FILE_TIME_LAYOUT) + RECORD_FILE_SUFFIX
+	tmpFile, err := os.CreateTemp(dir, ".record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	err = writeRecordedCalls(tmpFile, calls)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), filepath.Join(dir, fileName)); err != nil {
+		return err
+	}
+	log.Debugf("For datasource %v responses of query %v are recorded to %v", r.dsName, qName, fileName)
+	return r.rotate(qName)
+}
+
+// writeRecordedCalls writes the response bodies as the separate files of the zip archive
+// and the calls with the response status codes and headers as RECORD_CALLS_FILE_NAME
+func writeRecordedCalls(w io.Writer, calls []*RecordedCall) error {
+	zipWriter := zip.NewWriter(w)
+	for i, call := range calls {
+		for j, response := range call.Responses {
+			response.File = fmt.Sprintf("responses/%d-%d", i, j)
+			bodyWriter, err := zipWriter.Create(response.File)
+			if err != nil {
+				return err
+			}
+			if _, err = bodyWriter.Write(response.Body); err != nil {
+				return err
+			}
+		}
+	}
+	callsWriter, err := zipWriter.Create(RECORD_CALLS_FILE_NAME)
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(callsWriter).Encode(calls); err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
+
+// rotate removes the files of the query over max-files and older than max-age
+func (r *ResponseRecorder) rotate(qName string) error {
+	windows, err := ListRecordedWindows(r.dsConfig.Record.Path, qName)
+	if err != nil {
+		return err
+	}
+	maxFiles := r.dsConfig.Record.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = RECORD_MAX_FILES_DEFAULT
+	}
+	for i, window := range windows {
+		expired := r.dsConfig.Record.MaxAge > 0 && time.Since(window.EndTime) > r.dsConfig.Record.MaxAge
+		if i >= len(windows)-maxFiles && !expired {
+			continue
+		}
+		if err = os.Remove(window.Path); err != nil {
+			return err
+		}
+		log.Debugf("For datasource %v recorded file %v is removed", r.dsName, window.Path)
+	}
+	return nil
+}
+
+// ListRecordedWindows returns the recorded windows of the query sorted by the start time
+func ListRecordedWindows(archivePath string, qName string) ([]RecordedWindow, error) {
+	entries, err := os.ReadDir(filepath.Join(archivePath, qName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	windows := make([]RecordedWindow, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), RECORD_FILE_SUFFIX)
+		if entry.IsDir() || !ok {
+			continue
+		}
+		start, end, ok := strings.Cut(name, "_")
+		startTime, startErr := time.Parse(RECORD_FILE_TIME_LAYOUT, start)
+		endTime, endErr := time.Parse(RECORD_FILE_TIME_LAYOUT, end)
+		if !ok || startErr != nil || endErr != nil {
+			log.Warnf("File %v in the archive of query %v is not a recorded result and is skipped", entry.Name(), qName)
+			continue
+		}
+		windows = append(windows, RecordedWindow{StartTime: startTime, EndTime: endTime, Path: filepath.Join(archivePath, qName, entry.Name())})
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].StartTime.Before(windows[j].StartTime)
+	})
+	return windows, nil
+}
+
+// ReadRecordedWindow reads the recorded calls of the query execution with the response bodies
+func ReadRecordedWindow(window RecordedWindow) ([]*RecordedCall, error) {
+	zipReader, err := zip.OpenReader(window.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v : %w", window.Path, err)
+	}
+	defer zipReader.Close()
+	data, err := readArchiveFile(&zipReader.Reader, RECORD_CALLS_FILE_NAME)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v : %w", window.Path, err)
+	}
+	var calls []*RecordedCall
+	if err = json.Unmarshal(data, &calls); err != nil {
+		return nil, fmt.Errorf("error reading %v : %w", window.Path, err)
+	}
+	for _, call := range calls {
+		for _, response := range call.Responses {
+			if response.Body, err = readArchiveFile(&zipReader.Reader, response.File); err != nil {
+				return nil, fmt.Errorf("error reading %v : %w", window.Path, err)
+			}
+		}
+	}
+	return calls, nil
+}
+
+func readArchiveFile(archive *zip.Reader, name string) ([]byte, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// ResponsePlayer serves the recorded HTTP responses to the datasource in the replay mode instead of sending the requests
+type ResponsePlayer struct {
+	dsName    string
+	mutex     sync.Mutex
+	responses map[string][]*RecordedResponse
+}
+
+var (
+	responsePlayersMutex sync.Mutex
+	responsePlayers      = make(map[*config.DatasourceConfig]*ResponsePlayer)
+)
+
+// NewResponsePlayer creates the player of the datasource, it must be created before the datasource
+func NewResponsePlayer(appConfig *config.Config, dsName string) *ResponsePlayer {
+	player := &ResponsePlayer{dsName: dsName, responses: make(map[string][]*RecordedResponse)}
+	responsePlayersMutex.Lock()
+	defer responsePlayersMutex.Unlock()
+	responsePlayers[appConfig.Datasources[dsName]] = player
+	return player
+}
+
+func getResponsePlayer(dsConfig *config.DatasourceConfig) *ResponsePlayer {
+	responsePlayersMutex.Lock()
+	defer responsePlayersMutex.Unlock()
+	return responsePlayers[dsConfig]
+}
+
+// Play sets the responses served to the next requests of the query and returns the number of the responses
+// left unrequested from the previous call
+func (p *ResponsePlayer) Play(qName string, responses []*RecordedResponse) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	left := len(p.responses[qName])
+	p.responses[qName] = responses
+	return left
+}
+
+func (p *ResponsePlayer) next(qName string, req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	responses := p.responses[qName]
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("no recorded response of datasource %v is left for query %v, request %v %v", p.dsName, qName, req.Method, req.URL.Path)
+	}
+	p.responses[qName] = responses[1:]
+	response := responses[0]
+	header := response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode)),
+		StatusCode:    response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(response.Body)),
+		ContentLength: int64(len(response.Body)),
+		Request:       req,
+	}, nil
+}
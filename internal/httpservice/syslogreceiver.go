@@ -0,0 +1,338 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SYSLOG_MAX_MESSAGE_SIZE    = 64 * 1024
+	SYSLOG_TCP_IDLE_TIMEOUT    = 5 * time.Minute
+	SYSLOG_TCP_MAX_CONNECTIONS = 1000
+	SYSLOG_NIL_VALUE           = "-"
+	RFC3164_TIME_LAYOUT        = time.Stamp
+)
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var syslogFacilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+	"ntp", "security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+func (g *ReceiverService) listenSyslogUdp(address string) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7210).Errorf("ReceiverService : For datasource %v error listening syslog UDP on %v : %+v", g.dsName, address, err)
+		return
+	}
+	log.Infof("ReceiverService : For datasource %v syslog UDP receiver is listening on %v", g.dsName, address)
+	buf := make([]byte, SYSLOG_MAX_MESSAGE_SIZE)
+	for {
+		n, remoteAddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.WithField(ec.FIELD, ec.LME_7210).Errorf("ReceiverService : For datasource %v error reading syslog UDP message : %+v", g.dsName, err)
+			continue
+		}
+		g.receiveSyslogMessage(string(buf[:n]), remoteAddr.String())
+	}
+}
+
+func (g *ReceiverService) listenSyslogTcp(address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7210).Errorf("ReceiverService : For datasource %v error listening syslog TCP on %v : %+v", g.dsName, address, err)
+		return
+	}
+	log.Infof("ReceiverService : For datasource %v syslog TCP receiver is listening on %v", g.dsName, address)
+	connections := make(chan struct{}, SYSLOG_TCP_MAX_CONNECTIONS)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.WithField(ec.FIELD, ec.LME_7210).Errorf("ReceiverService : For datasource %v error accepting syslog TCP connection : %+v", g.dsName, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		select {
+		case connections <- struct{}{}:
+			go func() {
+				defer func() { <-connections }()
+				g.handleSyslogTcpConnection(conn)
+			}()
+		default:
+			log.WithField(ec.FIELD, ec.LME_7210).Warnf("ReceiverService : For datasource %v syslog TCP connection from %v is rejected, the limit of %v connections is reached", g.dsName, conn.RemoteAddr(), SYSLOG_TCP_MAX_CONNECTIONS)
+			if err := conn.Close(); err != nil {
+				log.Errorf("ReceiverService : Error closing syslog TCP connection : %+v", err)
+			}
+		}
+	}
+}
+
+// idleTimeoutConn closes the connection if the client sends nothing during the timeout
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (g *ReceiverService) handleSyslogTcpConnection(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Errorf("ReceiverService : Error closing syslog TCP connection : %+v", err)
+		}
+	}()
+	remoteAddr := conn.RemoteAddr().String()
+	err := readSyslogFrames(&idleTimeoutConn{Conn: conn, timeout: SYSLOG_TCP_IDLE_TIMEOUT}, func(message string) {
+		g.receiveSyslogMessage(message, remoteAddr)
+	})
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		log.Infof("ReceiverService : For datasource %v syslog TCP connection from %v is closed after %v of inactivity", g.dsName, remoteAddr, SYSLOG_TCP_IDLE_TIMEOUT)
+		return
+	}
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7211).Warnf("ReceiverService : For datasource %v error reading syslog TCP stream from %v : %+v", g.dsName, remoteAddr, err)
+	}
+}
+
+// readSyslogFrames splits the TCP stream into messages. Both framing methods of RFC 6587 are supported:
+// octet counting ("<length> <message>") and non-transparent framing with the new line as the trailer.
+// The messages longer than SYSLOG_MAX_MESSAGE_SIZE break the stream
+func readSyslogFrames(reader io.Reader, onMessage func(message string)) error {
+	r := bufio.NewReaderSize(reader, SYSLOG_MAX_MESSAGE_SIZE)
+	for {
+		first, err := r.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first[0] >= '0' && first[0] <= '9' {
+			length, err := readSyslogFrameLength(r)
+			if err != nil {
+				return err
+			}
+			message := make([]byte, length)
+			if _, err = io.ReadFull(r, message); err != nil {
+				return err
+			}
+			onMessage(string(message))
+			continue
+		}
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return fmt.Errorf("message exceeds %v bytes", SYSLOG_MAX_MESSAGE_SIZE)
+		}
+		if len(strings.TrimSpace(string(line))) > 0 {
+			onMessage(string(line))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readSyslogFrameLength reads the octet counting length followed by the space
+func readSyslogFrameLength(r *bufio.Reader) (int, error) {
+	length := 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b == ' ' {
+			break
+		}
+		if b < '0' || b > '9' {
+			return 0, fmt.Errorf("incorrect message length, unexpected character %q", b)
+		}
+		length = length*10 + int(b-'0')
+		if length > SYSLOG_MAX_MESSAGE_SIZE {
+			return 0, fmt.Errorf("message length exceeds %v bytes", SYSLOG_MAX_MESSAGE_SIZE)
+		}
+	}
+	if length == 0 {
+		return 0, fmt.Errorf("incorrect message length 0")
+	}
+	return length, nil
+}
+
+func (g *ReceiverService) receiveSyslogMessage(message string, remoteAddr string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.WithField(ec.FIELD, ec.LME_1601).Errorf("ReceiverService : For datasource %v panic during processing of syslog message from %v : %+v ; Stacktrace of the panic : %v", g.dsName, remoteAddr, rec, string(debug.Stack()))
+		}
+	}()
+	timestamp, fields, err := parseSyslogMessage(strings.TrimRight(message, "\r\n\x00"), g.now())
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7211).Warnf("ReceiverService : For datasource %v syslog message from %v can not be parsed and is skipped : %+v", g.dsName, remoteAddr, err)
+		return
+	}
+	log.Tracef("ReceiverService : For datasource %v syslog message is received from %v : %+v", g.dsName, remoteAddr, fields)
+	g.addRecord(timestamp, fields)
+}
+
+// parseSyslogMessage parses the RFC 5424 or RFC 3164 message into the fields facility, severity, hostname, appname,
+// procid, timestamp and msgid (RFC 5424 only), message and the structured data parameters named "<SD-ID>.<PARAM-NAME>".
+// The RFC 3164 timestamp has neither year nor time zone, so the local time zone and the year closest to now are used
+func parseSyslogMessage(message string, now time.Time) (time.Time, map[string]string, error) {
+	if !strings.HasPrefix(message, "<") {
+		return time.Time{}, nil, fmt.Errorf("message does not start with PRI")
+	}
+	end := strings.IndexByte(message, '>')
+	if end < 2 || end > 4 {
+		return time.Time{}, nil, fmt.Errorf("message has incorrect PRI")
+	}
+	for _, c := range message[1:end] {
+		if c < '0' || c > '9' {
+			return time.Time{}, nil, fmt.Errorf("message has incorrect PRI %v", message[1:end])
+		}
+	}
+	pri, err := strconv.Atoi(message[1:end])
+	if err != nil || pri > 191 {
+		return time.Time{}, nil, fmt.Errorf("message has incorrect PRI %v", message[1:end])
+	}
+	fields := map[string]string{
+		"facility": syslogFacilities[pri/8],
+		"severity": syslogSeverities[pri%8],
+	}
+	rest := message[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		timestamp, err := parseRfc5424(rest[2:], fields, now)
+		return timestamp, fields, err
+	}
+	return parseRfc3164(rest, fields, now), fields, nil
+}
+
+func parseRfc5424(rest string, fields map[string]string, now time.Time) (time.Time, error) {
+	headerNames := []string{"timestamp", "hostname", "appname", "procid", "msgid"}
+	headerValues := make([]string, 0, len(headerNames))
+	for range headerNames {
+		value, tail, _ := strings.Cut(rest, " ")
+		headerValues = append(headerValues, value)
+		rest = tail
+	}
+	timestamp := now
+	if headerValues[0] != SYSLOG_NIL_VALUE {
+		ts, err := time.Parse(time.RFC3339Nano, headerValues[0])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("message has incorrect timestamp %v", headerValues[0])
+		}
+		timestamp = ts
+	}
+	for i, name := range headerNames {
+		if value := headerValues[i]; value != SYSLOG_NIL_VALUE {
+			fields[name] = value
+		}
+	}
+
+	if strings.HasPrefix(rest, SYSLOG_NIL_VALUE) {
+		rest = strings.TrimPrefix(rest[1:], " ")
+	} else {
+		var err error
+		rest, err = parseStructuredData(rest, fields)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	fields[MESSAGE_FIELD_NAME] = strings.TrimPrefix(rest, "\ufeff")
+	return timestamp, nil
+}
+
+// parseStructuredData parses the SD-ELEMENTs [id name="value" ...] and returns the rest of the message
+func parseStructuredData(rest string, fields map[string]string) (string, error) {
+	for strings.HasPrefix(rest, "[") {
+		i := 1
+		for i < len(rest) && rest[i] != ' ' && rest[i] != ']' {
+			i++
+		}
+		sdId := rest[1:i]
+		for i < len(rest) && rest[i] != ']' {
+			for i < len(rest) && rest[i] == ' ' {
+				i++
+			}
+			start := i
+			for i < len(rest) && rest[i] != '=' {
+				i++
+			}
+			name := rest[start:i]
+			if i+1 >= len(rest) || rest[i+1] != '"' {
+				return "", fmt.Errorf("structured data element %v has incorrect parameter %v", sdId, name)
+			}
+			var value strings.Builder
+			for i += 2; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			fields[sdId+"."+name] = value.String()
+			i++
+		}
+		if i >= len(rest) {
+			return "", fmt.Errorf("structured data element %v is not closed", sdId)
+		}
+		rest = rest[i+1:]
+	}
+	return strings.TrimPrefix(rest, " "), nil
+}
+
+func parseRfc3164(rest string, fields map[string]string, now time.Time) time.Time {
+	timestamp := now
+	if len(rest) >= len(RFC3164_TIME_LAYOUT) {
+		if ts, err := time.ParseInLocation(RFC3164_TIME_LAYOUT, rest[:len(RFC3164_TIME_LAYOUT)], now.Location()); err == nil {
+			timestamp = ts.AddDate(now.Year(), 0, 0)
+			if timestamp.Sub(now) > time.Hour*24*180 {
+				timestamp = timestamp.AddDate(-1, 0, 0)
+			}
+			rest = strings.TrimPrefix(rest[len(RFC3164_TIME_LAYOUT):], " ")
+			if hostname, tail, ok := strings.Cut(rest, " "); ok {
+				fields["hostname"] = hostname
+				rest = tail
+			}
+		}
+	}
+	if tag, tail, ok := strings.Cut(rest, ": "); ok && !strings.Contains(tag, " ") {
+		if name, pid, ok := strings.Cut(tag, "["); ok {
+			fields["appname"] = name
+			fields["procid"] = strings.TrimSuffix(pid, "]")
+		} else {
+			fields["appname"] = tag
+		}
+		rest = tail
+	}
+	fields[MESSAGE_FIELD_NAME] = rest
+	return timestamp
+}
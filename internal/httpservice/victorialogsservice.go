@@ -0,0 +1,232 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log_exporter/internal/config"
+	ec "log_exporter/internal/utils/errorcodes"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const VICTORIALOGS_MAX_RECORDS_DEFAULT = 100000
+
+type VictoriaLogsService struct {
+	appConfig  *config.Config
+	dsConfig   *config.DatasourceConfig
+	maxRecords int
+	transport  *datasourceTransport
+}
+
+func CreateVictoriaLogsService(appConfig *config.Config, dsName string) *VictoriaLogsService {
+	g := VictoriaLogsService{}
+	g.appConfig = appConfig
+	g.dsConfig = appConfig.Datasources[dsName]
+	g.transport = newDatasourceTransport(appConfig, dsName)
+	g.maxRecords = g.dsConfig.MaxRecords
+	if g.maxRecords <= 0 {
+		g.maxRecords = VICTORIALOGS_MAX_RECORDS_DEFAULT
+	}
+	log.Infof("VictoriaLogsService : Service created with max records %v", g.maxRecords)
+	return &g
+}
+
+// Capabilities disables splitting, the LogsQL queries may contain the stats pipes whose results of the sub-ranges can not be merged
+func (g *VictoriaLogsService) Capabilities() DatasourceCapabilities {
+	return DatasourceCapabilities{Retries: true}
+}
+
+func (g *VictoriaLogsService) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	now := time.Now()
+	var err error
+	defer func() {
+		log.Debugf("VictoriaLogsService : For query %v request executed and response is processed in %+v", qName, time.Since(now))
+		if err != nil && !errors.Is(err, ErrResponseTruncated) {
+			selfMonitorIncErrorCodeCount(qName, now)
+		} else {
+			selfMonitorRefreshErrorCodeCount(qName, now)
+		}
+	}()
+
+	records, responseSize, errc, err := g.queryVictoriaLogs(qName, startTime, endTime)
+	selfMonitorObserveQueryLatency(float64(time.Since(now))/float64(time.Second), qName, now)
+	selfMonitorObserveQueryResponseSize(float64(responseSize), qName, now)
+	if errors.Is(err, ErrResponseTruncated) {
+		selfMonitorIncResponseTruncatedCount(qName, now)
+	} else if err != nil {
+		return make([][]string, 0), errc, err
+	} else {
+		selfMonitorRefreshResponseTruncatedCount(qName, now)
+	}
+
+	return records, errc, err
+}
+
+// queryVictoriaLogs executes the LogsQL query for the time range with the /select/logsql/query endpoint.
+// The response contains one JSON object per line; it is parsed while it is being read. If the response contains
+// more than max-records entries or exceeds max-response-size, the entries read so far are returned
+// together with the LME-7182 error code and wrapped ErrResponseTruncated
+func (g *VictoriaLogsService) queryVictoriaLogs(qName string, startTime time.Time, endTime time.Time) ([][]string, int64, string, error) {
+	queryString, err := RenderQueryString(g.appConfig, qName, startTime, endTime, VICTORIALOGS_TEMPLATE_TIME_LAYOUT)
+	if err != nil {
+		return nil, 0, ec.LME_8102, fmt.Errorf("VictoriaLogsService : For query %v error creating queryString : %+v", qName, err)
+	}
+	params := url.Values{}
+	params.Add("query", queryString)
+	params.Add("start", startTime.Format(time.RFC3339Nano))
+	params.Add("end", endTime.Format(time.RFC3339Nano))
+	// one extra entry is requested to find out whether the result is truncated
+	params.Add("limit", strconv.Itoa(g.maxRecords+1))
+
+	client := GetHTTPClient(&g.dsConfig.TLSHostConfig)
+
+	victoriaLogsEndpoint := strings.Trim(g.dsConfig.Host, " /") + "/select/logsql/query"
+	req, err := http.NewRequest("POST", victoriaLogsEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, 0, ec.LME_7180, fmt.Errorf("VictoriaLogsService : For query %v error creating request to %v : %+v", qName, victoriaLogsEndpoint, err)
+	}
+	if g.dsConfig.User != "" {
+		req.SetBasicAuth(g.dsConfig.User, g.dsConfig.Password)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	log.Debugf("VictoriaLogsService : For query %v request generated : %+v, params : %v", qName, req.URL.String(), params.Encode())
+
+	resp, err := g.transport.do(qName, client, req)
+	if err != nil {
+		return nil, 0, ec.LME_7180, fmt.Errorf("VictoriaLogsService : For query %v error accessing %v : %w", qName, victoriaLogsEndpoint, err)
+	}
+
+	if resp.Body != nil {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.Errorf("VictoriaLogsService : Error closing response body : %+v", err)
+			}
+		}()
+	}
+
+	log.Debugf("VictoriaLogsService : For query %v received response : %+v", qName, resp)
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 10000))
+		log.WithField(ec.FIELD, ec.LME_7181).Errorf("VictoriaLogsService : For query %v received response with status code %v from victorialogs, response body (limited) : %s", qName, resp.StatusCode, body)
+		return nil, int64(len(body)), ec.LME_7181, statusCodeError("VictoriaLogsService", qName, resp.StatusCode)
+	}
+	if resp.StatusCode != 200 {
+		log.WithField(ec.FIELD, ec.LME_7181).Errorf("VictoriaLogsService : For query %v received response with status code %v from victorialogs", qName, resp.StatusCode)
+	}
+
+	body := &sizeLimitedReader{reader: resp.Body, limit: g.dsConfig.MaxResponseSize}
+	entries, truncated, err := g.readEntries(body)
+	log.Infof("VictoriaLogsService : For query %v to %v response status is %v, body length is %v, entries count is %v", qName, victoriaLogsEndpoint, resp.Status, body.size, len(entries))
+	if errors.Is(err, errResponseSizeLimit) {
+		log.WithField(ec.FIELD, ec.LME_7182).Warnf("VictoriaLogsService : For query %v response is larger than max-response-size %v bytes, only %v entries are read", qName, g.dsConfig.MaxResponseSize, len(entries))
+		return g.processEntries(entries, qName), body.size, ec.LME_7182, fmt.Errorf("VictoriaLogsService : For query %v max-response-size %v is exceeded : %w", qName, g.dsConfig.MaxResponseSize, ErrResponseTruncated)
+	}
+	if err != nil {
+		return nil, body.size, ec.LME_7183, fmt.Errorf("VictoriaLogsService : For query %v to %v error reading body : %w", qName, victoriaLogsEndpoint, err)
+	}
+	if truncated {
+		log.WithField(ec.FIELD, ec.LME_7182).Warnf("VictoriaLogsService : For query %v the number of entries exceeds max-records %v, the result is truncated", qName, g.maxRecords)
+		return g.processEntries(entries, qName), body.size, ec.LME_7182, fmt.Errorf("VictoriaLogsService : For query %v max-records %v is reached : %w", qName, g.maxRecords, ErrResponseTruncated)
+	}
+	return g.processEntries(entries, qName), body.size, "", nil
+}
+
+// readEntries decodes the JSON lines of the response. It stops after max-records entries
+// and reports whether there are more entries in the response
+func (g *VictoriaLogsService) readEntries(reader io.Reader) ([]map[string]string, bool, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	entries := make([]map[string]string, 0)
+	for {
+		var entry map[string]interface{}
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return entries, false, nil
+		}
+		if err != nil {
+			return entries, false, err
+		}
+		if entry == nil {
+			continue
+		}
+		if len(entries) >= g.maxRecords {
+			return entries, true, nil
+		}
+		fields := make(map[string]string, len(entry))
+		for k, v := range entry {
+			fields[k] = formatJsonValue(v)
+		}
+		entries = append(entries, fields)
+	}
+}
+
+// fieldMapsToRecords turns the entries with named fields into rows. The header contains the fields from fields_in_order
+// followed by the other fields of the entries in alphabetical order; missing fields are empty
+func fieldMapsToRecords(entries []map[string]string, fieldsInOrder []string) [][]string {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	keyList := make([]string, 0)
+	keyListSet := make(map[string]int)
+	for _, field := range fieldsInOrder {
+		if _, ok := keyListSet[field]; ok {
+			continue
+		}
+		keyListSet[field] = len(keyList)
+		keyList = append(keyList, field)
+	}
+	otherFields := make([]string, 0)
+	for _, entry := range entries {
+		for k := range entry {
+			if _, ok := keyListSet[k]; !ok {
+				keyListSet[k] = -1
+				otherFields = append(otherFields, k)
+			}
+		}
+	}
+	sort.Strings(otherFields)
+	for _, k := range otherFields {
+		keyListSet[k] = len(keyList)
+		keyList = append(keyList, k)
+	}
+
+	records := make([][]string, 0, len(entries)+1)
+	records = append(records, keyList)
+	for _, entry := range entries {
+		row := make([]string, len(keyList))
+		for k, v := range entry {
+			row[keyListSet[k]] = v
+		}
+		records = append(records, row)
+	}
+	return records
+}
+
+func (g *VictoriaLogsService) processEntries(entries []map[string]string, qName string) [][]string {
+	records := fieldMapsToRecords(entries, g.appConfig.Queries[qName].FieldsInOrder)
+	log.Debugf("VictoriaLogsService : Json processing : For query %v result len = %v, result records = %+v", qName, len(records), records)
+	return records
+}
@@ -20,7 +20,6 @@ import (
 	"io"
 	"log_exporter/internal/config"
 	ec "log_exporter/internal/utils/errorcodes"
-	"net"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
@@ -40,16 +39,7 @@ func NewVictoriaService(exportConfig *config.ExportConfig) *VictoriaService {
 }
 
 func (v *VictoriaService) PushBuffer(buffer *bytes.Buffer, queryName string) (string, error) {
-	var transport http.RoundTripper = &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: v.exportConfig.ConnectionTimeout,
-		}).DialContext,
-		TLSClientConfig: v.exportConfig.TlsConfig,
-	}
-	client := http.Client{
-		Transport: transport,
-		Timeout:   v.exportConfig.ConnectionTimeout,
-	}
+	client := GetHTTPClient(&v.exportConfig.TLSHostConfig)
 
 	req, err := http.NewRequest("POST", v.url, buffer)
 	if err != nil {
@@ -70,6 +60,7 @@ func (v *VictoriaService) PushBuffer(buffer *bytes.Buffer, queryName string) (st
 	} else {
 		if resp.Body != nil {
 			defer func() {
+				_, _ = io.Copy(io.Discard, resp.Body) // the body must be read to the end to reuse the connection
 				if err := resp.Body.Close(); err != nil {
 					log.Errorf("VictoriaService : Error closing response body : %+v", err)
 				}
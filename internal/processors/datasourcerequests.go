@@ -0,0 +1,162 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"errors"
+	"log_exporter/internal/config"
+	"log_exporter/internal/httpservice"
+	"log_exporter/internal/queues"
+	"log_exporter/internal/selfmonitor"
+	ec "log_exporter/internal/utils/errorcodes"
+	"runtime/debug"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DatasourceCallsProcessor executes the queries of one datasource: it takes the query time from the gts-queue,
+// queries the datasource according to its capabilities and puts the result to the gd-queue
+type DatasourceCallsProcessor struct {
+	dsName     string
+	appConfig  *config.Config
+	gtsQueue   *queues.GTSQueue
+	gdQueue    *queues.GDQueue
+	datasource httpservice.Datasource
+	retrier    *DatasourceRetrier
+	recorder   *httpservice.ResponseRecorder
+}
+
+func NewDatasourceCallsProcessor(appConfig *config.Config, dsName string, gtsQueue *queues.GTSQueue, gdQueue *queues.GDQueue) *DatasourceCallsProcessor {
+	result := DatasourceCallsProcessor{
+		dsName:     dsName,
+		appConfig:  appConfig,
+		gtsQueue:   gtsQueue,
+		gdQueue:    gdQueue,
+		datasource: httpservice.CreateDatasource(appConfig, dsName),
+	}
+	if result.datasource.Capabilities().Retries {
+		result.retrier = NewDatasourceRetrier(appConfig, dsName)
+	}
+	result.recorder = httpservice.GetResponseRecorder(appConfig, dsName)
+	return &result
+}
+
+func (dcp *DatasourceCallsProcessor) Start() {
+	log.Infof("DatasourceCallsProcessor : Start() for datasource %v", dcp.dsName)
+	if starter, ok := dcp.datasource.(httpservice.DatasourceStarter); ok {
+		starter.Start()
+	}
+	for queryName, queryConfig := range dcp.appConfig.Queries {
+		if queryConfig.Datasource != dcp.dsName {
+			continue
+		}
+		go dcp.startGoroutine(queryName, queryConfig)
+		dcp.selfMonitorIncPanicRecoveries(queryName, 0.0, time.Now())
+	}
+	log.Infof("DatasourceCallsProcessor : Start() for datasource %v finished", dcp.dsName)
+}
+
+func (dcp *DatasourceCallsProcessor) startGoroutine(queryName string, queryConfig *config.QueryConfig) {
+	defer log.Infof("DatasourceCallsProcessor : Goroutine for query %v is finished", queryName)
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.WithField(ec.FIELD, ec.LME_1601).Errorf("DatasourceCallsProcessor : Panic during execution of query %v : %+v ; Stacktrace of the panic : %v", queryName, rec, string(debug.Stack()))
+			time.Sleep(time.Second * 5)
+			log.Infof("DatasourceCallsProcessor : Starting gouroutine for query %v again ...", queryName)
+			go dcp.startGoroutine(queryName, queryConfig)
+			dcp.selfMonitorIncPanicRecoveries(queryName, 1.0, time.Now())
+		}
+	}()
+	log.Infof("DatasourceCallsProcessor : Goroutine for query %v is started", queryName)
+	for {
+		time, ok := dcp.gtsQueue.Get(queryName)
+		if !ok {
+			log.WithField(ec.FIELD, ec.LME_1621).Errorf("DatasourceCallsProcessor : Chan is closed for the query %v, stopping goroutine", queryName)
+			return
+		}
+		if time.IsZero() {
+			log.Infof("DatasourceCallsProcessor : Zero time received for query %v", queryName)
+			continue
+		}
+		if gd := dcp.executeQuery(queryName, queryConfig, time); gd != nil {
+			dcp.gdQueue.Put(queryName, gd)
+			if committer, ok := dcp.datasource.(httpservice.DatasourceCommitter); ok {
+				committer.Commit(queryName)
+			}
+		}
+	}
+}
+
+// executeQuery returns nil if the retries of the failed request are exhausted, the time range is not evaluated then
+func (dcp *DatasourceCallsProcessor) executeQuery(qName string, queryConfig *config.QueryConfig, startTime time.Time) *queues.GraylogData {
+	endTime := startTime.Add(queryConfig.TimerangeDuration)
+	log.Debugf("executeQuery for datasource %v, query %v, startTime %v, endTime %v", dcp.dsName, qName, startTime, endTime)
+
+	if dcp.recorder != nil {
+		dcp.recorder.BeginExecution(qName)
+	}
+	var queryResult [][]string
+	var errc string
+	var err error
+	capabilities := dcp.datasource.Capabilities()
+	switch {
+	case dcp.retrier == nil:
+		queryResult, errc, err = dcp.query(qName, startTime, endTime)
+		if err != nil && !errors.Is(err, httpservice.ErrResponseTruncated) {
+			log.WithField(ec.FIELD, errc).Errorf("Error executing query %v, startTime %v , endTime %v : %+v", qName, startTime, endTime, err)
+		}
+	case capabilities.TimerangeSplitting:
+		queryResult, errc, err = dcp.retrier.QuerySplitting(qName, queryConfig, startTime, endTime, dcp.query)
+	default:
+		queryResult, errc, err = dcp.retrier.Query(qName, startTime, endTime, func() ([][]string, string, error) {
+			return dcp.query(qName, startTime, endTime)
+		})
+	}
+	if err != nil && !errors.Is(err, httpservice.ErrResponseTruncated) && dcp.retrier != nil && *dcp.appConfig.General.DatasourceRetry {
+		log.WithField(ec.FIELD, ec.LME_1641).Errorf("Time range startTime %v , endTime %v of query %v is skipped : requests to datasource %v failed", startTime, endTime, qName, dcp.dsName)
+		return nil
+	}
+	if errors.Is(err, httpservice.ErrResponseTruncated) {
+		log.WithField(ec.FIELD, errc).Warnf("Partial result is received from datasource %v for query %v, startTime %v , endTime %v : %+v", dcp.dsName, qName, startTime, endTime, err)
+	}
+	if dcp.recorder != nil && (err == nil || errors.Is(err, httpservice.ErrResponseTruncated)) {
+		dcp.recorder.Record(qName, startTime, endTime)
+	}
+
+	return &queues.GraylogData{
+		Data:      queryResult,
+		StartTime: startTime,
+		EndTime:   endTime,
+	}
+}
+
+// query executes the call to the datasource, the responses of the successful call are kept for the recording
+func (dcp *DatasourceCallsProcessor) query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	if dcp.recorder == nil {
+		return dcp.datasource.Query(qName, startTime, endTime)
+	}
+	dcp.recorder.BeginCall(qName, startTime, endTime)
+	queryResult, errc, err := dcp.datasource.Query(qName, startTime, endTime)
+	dcp.recorder.EndCall(qName, err == nil || errors.Is(err, httpservice.ErrResponseTruncated))
+	return queryResult, errc, err
+}
+
+func (dcp *DatasourceCallsProcessor) selfMonitorIncPanicRecoveries(qName string, value float64, timestamp time.Time) {
+	labels := make(map[string]string)
+	labels["query_name"] = qName
+	labels["process_name"] = "DatasourceCallsProcessor"
+	selfmonitor.IncPanicRecoveriesCount(labels, value, &timestamp)
+}
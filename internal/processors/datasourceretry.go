@@ -0,0 +1,223 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"log_exporter/internal/config"
+	"log_exporter/internal/httpservice"
+	"log_exporter/internal/selfmonitor"
+	ec "log_exporter/internal/utils/errorcodes"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CIRCUIT_BREAKER_CLOSED    = 0
+	CIRCUIT_BREAKER_HALF_OPEN = 1
+	CIRCUIT_BREAKER_OPEN      = 2
+)
+
+// DatasourceRetrier executes datasource requests with exponential backoff and a circuit breaker.
+// One retrier is shared by all queries of the same datasource.
+type DatasourceRetrier struct {
+	dsName  string
+	general *config.GeneralConfig
+	breaker *circuitBreaker
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+func NewDatasourceRetrier(appConfig *config.Config, dsName string) *DatasourceRetrier {
+	result := DatasourceRetrier{
+		dsName:  dsName,
+		general: appConfig.General,
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+	result.breaker = &circuitBreaker{
+		dsName:      dsName,
+		threshold:   appConfig.General.DatasourceBreakerThresholdParsed,
+		openTimeout: appConfig.General.DatasourceBreakerOpenTimeoutParsed,
+		now:         func() time.Time { return result.now() },
+		onStateChange: func(state int) {
+			result.selfMonitorSetCircuitBreakerState(state)
+		},
+	}
+	result.selfMonitorSetCircuitBreakerState(CIRCUIT_BREAKER_CLOSED)
+	return &result
+}
+
+// Query calls the query function until it succeeds or the retry policy gives up.
+// Truncated responses are treated as successful, the time range split requests are not retried.
+// The result of the last attempt is returned.
+func (dr *DatasourceRetrier) Query(qName string, startTime time.Time, endTime time.Time, query func() ([][]string, string, error)) ([][]string, string, error) {
+	var queryResult [][]string
+	var errc string
+	var err error
+
+	begin := dr.now()
+	delay := dr.general.DatasourceRetryPeriodParsed
+	attempts := 0
+	for {
+		allowed, openRemaining := dr.breaker.allow()
+		if allowed {
+			attempts++
+			queryResult, errc, err = query()
+			if err == nil || errors.Is(err, httpservice.ErrResponseTruncated) {
+				dr.breaker.success()
+				return queryResult, errc, err
+			}
+			if errors.Is(err, errSplitRequired) {
+				if httpservice.IsTimeoutError(err) {
+					dr.breaker.failure()
+				} else {
+					dr.breaker.success()
+				}
+				return queryResult, errc, err
+			}
+			dr.breaker.failure()
+			log.WithField(ec.FIELD, errc).Errorf("Error requesting datasource %v for query %v, startTime %v , endTime %v, attempt %v : %+v", dr.dsName, qName, startTime, endTime, attempts, err)
+		} else {
+			queryResult, errc, err = nil, ec.LME_1640, fmt.Errorf("circuit breaker for datasource %v is open", dr.dsName)
+			log.WithField(ec.FIELD, errc).Warnf("Request to datasource %v for query %v, startTime %v , endTime %v is rejected : circuit breaker is open", dr.dsName, qName, startTime, endTime)
+		}
+
+		if !*dr.general.DatasourceRetry {
+			return queryResult, errc, err
+		}
+		if dr.general.DatasourceRetryMaxAttemptsParsed > 0 && attempts >= dr.general.DatasourceRetryMaxAttemptsParsed {
+			log.WithField(ec.FIELD, ec.LME_1641).Errorf("Giving up requesting datasource %v for query %v, startTime %v , endTime %v : %v attempts are made", dr.dsName, qName, startTime, endTime, attempts)
+			return queryResult, errc, err
+		}
+
+		wait := dr.jitter(delay)
+		if wait < openRemaining {
+			wait = openRemaining
+		}
+		if dr.general.DatasourceRetryMaxElapsedTimeParsed > 0 && dr.now().Sub(begin)+wait > dr.general.DatasourceRetryMaxElapsedTimeParsed {
+			log.WithField(ec.FIELD, ec.LME_1641).Errorf("Giving up requesting datasource %v for query %v, startTime %v , endTime %v : datasource-retry-max-elapsed-time %v is exceeded", dr.dsName, qName, startTime, endTime, dr.general.DatasourceRetryMaxElapsedTimeParsed)
+			return queryResult, errc, err
+		}
+		dr.sleep(wait)
+		log.Infof("Retry requesting datasource %v for query %v, startTime %v , endTime %v", dr.dsName, qName, startTime, endTime)
+
+		delay = time.Duration(float64(delay) * dr.general.DatasourceRetryMultiplierParsed)
+		if delay > dr.general.DatasourceRetryMaxPeriodParsed {
+			delay = dr.general.DatasourceRetryMaxPeriodParsed
+		}
+	}
+}
+
+func (dr *DatasourceRetrier) jitter(delay time.Duration) time.Duration {
+	jitter := dr.general.DatasourceRetryJitterParsed
+	if jitter <= 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+func (dr *DatasourceRetrier) selfMonitorSetCircuitBreakerState(state int) {
+	labels := make(map[string]string)
+	labels["datasource"] = dr.dsName
+	now := time.Now()
+	selfmonitor.SetCircuitBreakerState(float64(state), labels, &now)
+}
+
+// circuitBreaker opens after threshold consecutive failures and rejects requests for openTimeout.
+// After that a single probe request is allowed in half-open state; its result closes or reopens the breaker.
+type circuitBreaker struct {
+	sync.Mutex
+	dsName        string
+	threshold     int
+	openTimeout   time.Duration
+	state         int
+	failures      int
+	openedAt      time.Time
+	probing       bool
+	now           func() time.Time
+	onStateChange func(int)
+}
+
+// allow returns whether the request may be executed and, if not, how long the breaker stays open
+func (cb *circuitBreaker) allow() (bool, time.Duration) {
+	if cb.threshold <= 0 {
+		return true, 0
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	switch cb.state {
+	case CIRCUIT_BREAKER_OPEN:
+		elapsed := cb.now().Sub(cb.openedAt)
+		if elapsed < cb.openTimeout {
+			return false, cb.openTimeout - elapsed
+		}
+		cb.setState(CIRCUIT_BREAKER_HALF_OPEN)
+		cb.probing = true
+		return true, 0
+	case CIRCUIT_BREAKER_HALF_OPEN:
+		if cb.probing {
+			return false, 0
+		}
+		cb.probing = true
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+func (cb *circuitBreaker) success() {
+	if cb.threshold <= 0 {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	cb.failures = 0
+	cb.probing = false
+	cb.setState(CIRCUIT_BREAKER_CLOSED)
+}
+
+func (cb *circuitBreaker) failure() {
+	if cb.threshold <= 0 {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	cb.failures++
+	cb.probing = false
+	if cb.state == CIRCUIT_BREAKER_HALF_OPEN || (cb.state == CIRCUIT_BREAKER_CLOSED && cb.failures >= cb.threshold) {
+		cb.openedAt = cb.now()
+		cb.setState(CIRCUIT_BREAKER_OPEN)
+	}
+}
+
+func (cb *circuitBreaker) setState(state int) {
+	if cb.state == state {
+		return
+	}
+	if state == CIRCUIT_BREAKER_OPEN {
+		log.Warnf("Circuit breaker for datasource %v is opened after %v consecutive failures, requests are rejected for %v", cb.dsName, cb.failures, cb.openTimeout)
+	} else {
+		log.Infof("Circuit breaker state for datasource %v is changed from %v to %v", cb.dsName, cb.state, state)
+	}
+	cb.state = state
+	if cb.onStateChange != nil {
+		cb.onStateChange(state)
+	}
+}
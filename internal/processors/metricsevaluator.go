@@ -42,11 +42,15 @@ type MetricsEvaluationProcessor struct {
 	counterVecs     map[string]*collectors.CustomCounter
 	gaugeVecs       map[string]*collectors.CustomGauge
 	histogramVecs   map[string]*collectors.CustomHistogram
+	summaryVecs     map[string]*collectors.CustomSummary
 	metricEvaluator *evaluator.Evaluator
 }
 
 var emptyStringMap = make(map[string]string)
 
+// QUANTILE_LABEL is the label with the quantile added to the metrics of the quantile operation
+const QUANTILE_LABEL = "quantile"
+
 func NewMetricsEvaluationProcessor(appConfig *config.Config, gdQueue *queues.GDQueue, gmQueue *queues.GMQueue, deRegistry *registry.DERegistry) *MetricsEvaluationProcessor {
 	result := MetricsEvaluationProcessor{
 		appConfig:  appConfig,
@@ -58,6 +62,7 @@ func NewMetricsEvaluationProcessor(appConfig *config.Config, gdQueue *queues.GDQ
 	result.counterVecs = make(map[string]*collectors.CustomCounter)
 	result.gaugeVecs = make(map[string]*collectors.CustomGauge)
 	result.histogramVecs = make(map[string]*collectors.CustomHistogram)
+	result.summaryVecs = make(map[string]*collectors.CustomSummary)
 	result.metricEvaluator = evaluator.CreateEvaluator(appConfig)
 
 	result.initPrometheus()
@@ -132,7 +137,10 @@ func (mep *MetricsEvaluationProcessor) initMetric(metricName string, queryName s
 		return
 	}
 	labelsList := metricCfg.Labels
-	constLabels := mep.getConstLabels(metricCfg)
+	if metricCfg.Operation == "quantile" && metricCfg.Type == "gauge" {
+		labelsList = append(append(make([]string, 0, len(labelsList)+1), labelsList...), QUANTILE_LABEL)
+	}
+	constLabels := mep.getConstLabels(metricCfg, queryName)
 	switch metricCfg.Type {
 	case "gauge":
 		gaugeVec := collectors.NewCustomGauge(
@@ -160,18 +168,38 @@ func (mep *MetricsEvaluationProcessor) initMetric(metricName string, queryName s
 		initCounterMetric(metricCfg, metricName, counterVec)
 		log.Infof("counterVec %v registered with labels %+v and constLabels %+v", metricName, labelsList, constLabels)
 	case "histogram":
-		customHistogram := collectors.NewCustomHistogram(
+		histogramDesc := prometheus.NewDesc(
+			metricName,
+			metricCfg.Description,
+			labelsList,
+			constLabels,
+		)
+		var customHistogram *collectors.CustomHistogram
+		if metricCfg.NativeHistogram != nil {
+			customHistogram = collectors.NewCustomNativeHistogram(histogramDesc, metricCfg.NativeHistogram.SchemaParsed, metricCfg.NativeHistogram.ZeroThresholdParsed)
+		} else {
+			customHistogram = collectors.NewCustomHistogram(histogramDesc)
+		}
+		deRegistry.MustRegister(queryName, customHistogram)
+		mep.histogramVecs[metricName] = customHistogram
+		initHistogramMetric(metricCfg, metricName, customHistogram)
+		log.Infof("customHistogram %v registered with labels %+v", metricName, labelsList)
+	case "summary":
+		customSummary := collectors.NewCustomSummary(
 			prometheus.NewDesc(
 				metricName,
 				metricCfg.Description,
 				labelsList,
 				constLabels,
 			),
+			metricCfg.Objectives,
+			metricCfg.MaxAge,
+			metricCfg.AgeBuckets,
 		)
-		deRegistry.MustRegister(queryName, customHistogram)
-		mep.histogramVecs[metricName] = customHistogram
-		initHistogramMetric(metricCfg, metricName, customHistogram)
-		log.Infof("customHistogram %v registered with labels %+v", metricName, labelsList)
+		deRegistry.MustRegister(queryName, customSummary)
+		mep.summaryVecs[metricName] = customSummary
+		initSummaryMetric(metricCfg, metricName, customSummary)
+		log.Infof("customSummary %v registered with labels %+v, objectives %v and max age %v", metricName, labelsList, metricCfg.Objectives, metricCfg.MaxAge)
 	default:
 		log.WithField(ec.FIELD, ec.LME_8102).Errorf("Metric %v has not supported type %v", metricName, metricCfg.Type)
 	}
@@ -234,15 +262,22 @@ func initHistogramMetric(metricConfig *config.MetricsConfig, metricName string,
 		buckets[bucketKey] = 0
 	}
 	buckets[math.Inf(1.0)] = 0
+	observeEmpty := func(labels map[string]string) {
+		if metricConfig.NativeHistogram != nil {
+			customHistogram.ObserveNative(0, 0, 0, metricConfig.NativeHistogram.SchemaParsed, nil, nil, labels, metricConfig.Labels, nil)
+		} else {
+			customHistogram.Observe(0, 0, buckets, labels, metricConfig.Labels, nil)
+		}
+	}
 	if len(metricConfig.Labels) == 0 {
-		customHistogram.Observe(0, 0, buckets, emptyStringMap, metricConfig.Labels, nil)
+		observeEmpty(emptyStringMap)
 		log.Infof("Histogram %v without labels is initialized", metricName)
 	} else if len(metricConfig.ExpectedLabels) > 0 {
 		for itemNum, expectedLabelsItem := range metricConfig.ExpectedLabels {
 			cartesian := utils.LabelsCartesian(expectedLabelsItem)
 			log.Infof("For metric %v (itemNum %v) expected labels cartesian generated : %+v", metricName, itemNum, cartesian)
 			for _, labels := range cartesian {
-				customHistogram.Observe(0, 0, buckets, labels, metricConfig.Labels, nil)
+				observeEmpty(labels)
 			}
 		}
 	} else {
@@ -250,10 +285,34 @@ func initHistogramMetric(metricConfig *config.MetricsConfig, metricName string,
 	}
 }
 
-func (mep *MetricsEvaluationProcessor) getConstLabels(metricCfg *config.MetricsConfig) map[string]string {
+func initSummaryMetric(metricConfig *config.MetricsConfig, metricName string, customSummary *collectors.CustomSummary) {
+	initValue := metricConfig.Parameters["init-value"]
+	if initValue == "" {
+		log.Infof("Parameter init-value is not set for metric %v (summary)", metricName)
+		return
+	}
+	if len(metricConfig.Labels) == 0 {
+		customSummary.Observe(collectors.CreateQuantileSketch(metricConfig.QuantileRelativeAccuracy), emptyStringMap, metricConfig.Labels, nil)
+		log.Infof("Summary %v without labels is initialized", metricName)
+	} else if len(metricConfig.ExpectedLabels) > 0 {
+		for itemNum, expectedLabelsItem := range metricConfig.ExpectedLabels {
+			cartesian := utils.LabelsCartesian(expectedLabelsItem)
+			log.Infof("For metric %v (itemNum %v) expected labels cartesian generated : %+v", metricName, itemNum, cartesian)
+			for _, labels := range cartesian {
+				customSummary.Observe(collectors.CreateQuantileSketch(metricConfig.QuantileRelativeAccuracy), labels, metricConfig.Labels, nil)
+			}
+		}
+	} else {
+		log.WithField(ec.FIELD, ec.LME_8102).Errorf("Metric %v can not be initialized because it has labels and expected labels are not defined", metricName)
+	}
+}
+
+func (mep *MetricsEvaluationProcessor) getConstLabels(metricCfg *config.MetricsConfig, queryName string) map[string]string {
 	labels := make(map[string]string)
-	for label, labelValue := range mep.appConfig.Datasources[mep.appConfig.DsName].Labels {
-		labels[label] = labelValue
+	if dsConfig := mep.appConfig.Datasources[mep.appConfig.Queries[queryName].Datasource]; dsConfig != nil {
+		for label, labelValue := range dsConfig.Labels {
+			labels[label] = labelValue
+		}
 	}
 	for label, labelValue := range metricCfg.ConstLabels {
 		labels[label] = labelValue
@@ -287,6 +346,10 @@ func (mep *MetricsEvaluationProcessor) updateMetricBySeries(metricSeries []evalu
 			counterVec.Add(ms.Sum, ms.Labels, metricCfg.Labels, ms.Timestamp)
 		}
 	case "gauge":
+		if metricCfg.Operation == "quantile" {
+			mep.updateQuantileGauges(metricSeries, metric, metricCfg)
+			return
+		}
 		for _, ms := range metricSeries {
 			gaugeVec := mep.gaugeVecs[metric]
 			gaugeVec.Set(ms.Average, ms.Labels, metricCfg.Labels, ms.Timestamp)
@@ -297,15 +360,47 @@ func (mep *MetricsEvaluationProcessor) updateMetricBySeries(metricSeries []evalu
 			histValue := ms.HistValue
 			if histValue == nil {
 				log.WithField(ec.FIELD, ec.LME_1604).Errorf("Error evaluating histogram metric %v for labels %v : histValue is nil", metric, ms.Labels)
+			} else if histValue.Native {
+				histogramVec.ObserveNative(histValue.Sum, histValue.Cnt, histValue.ZeroCount, histValue.Schema, histValue.PositiveBuckets, histValue.NegativeBuckets, ms.Labels, metricCfg.Labels, ms.Timestamp)
 			} else {
 				histogramVec.Observe(histValue.Sum, histValue.Cnt, histValue.Buckets, ms.Labels, metricCfg.Labels, ms.Timestamp)
 			}
 		}
+	case "summary":
+		for _, ms := range metricSeries {
+			summaryVec := mep.summaryVecs[metric]
+			if ms.Sketch == nil {
+				log.WithField(ec.FIELD, ec.LME_1004).Errorf("Error evaluating summary metric %v for labels %v : sketch is nil", metric, ms.Labels)
+			} else {
+				summaryVec.Observe(ms.Sketch, ms.Labels, metricCfg.Labels, ms.Timestamp)
+			}
+		}
 	default:
 		log.WithField(ec.FIELD, ec.LME_8102).Errorf("Metric %v has not supported type %v", metric, metricCfg.Type)
 	}
 }
 
+// updateQuantileGauges sets one gauge per configured quantile with the quantile label. The series without sketch
+// (the label sets without data in the time range) get the default value of the metric for all quantiles
+func (mep *MetricsEvaluationProcessor) updateQuantileGauges(metricSeries []evaluator.MetricSeries, metric string, metricCfg *config.MetricsConfig) {
+	gaugeVec := mep.gaugeVecs[metric]
+	labelKeys := append(append(make([]string, 0, len(metricCfg.Labels)+1), metricCfg.Labels...), QUANTILE_LABEL)
+	for _, ms := range metricSeries {
+		for _, quantile := range metricCfg.Quantiles {
+			labels := make(map[string]string, len(ms.Labels)+1)
+			for k, v := range ms.Labels {
+				labels[k] = v
+			}
+			labels[QUANTILE_LABEL] = strconv.FormatFloat(quantile, 'g', -1, 64)
+			value := ms.Average
+			if ms.Sketch != nil {
+				value = ms.Sketch.Quantile(quantile)
+			}
+			gaugeVec.Set(value, labels, labelKeys, ms.Timestamp)
+		}
+	}
+}
+
 func (mep *MetricsEvaluationProcessor) selfMonitorIncPanicRecoveries(qName string, value float64, timestamp time.Time) {
 	labels := make(map[string]string)
 	labels["query_name"] = qName
@@ -15,15 +15,28 @@
 package processors
 
 import (
+	"errors"
+	"fmt"
 	"log_exporter/internal/config"
+	"log_exporter/internal/httpservice"
 	"log_exporter/internal/queues"
+	"log_exporter/internal/registry"
+	"log_exporter/internal/selfmonitor"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/robfig/cron/v3"
 )
 
+func TestMain(m *testing.M) {
+	testCfg := &config.Config{}
+	selfmonitor.InitSelfMonitoring(testCfg, nil, registry.NewDERegistry(testCfg))
+	os.Exit(m.Run())
+}
+
 func TestSignalProcessorCreation(t *testing.T) {
 	// Test that we can create a SignalProcessor instance
 	// This ensures the processors package can be imported and basic types work
@@ -219,6 +232,262 @@ func TestPushProcessorCreation(t *testing.T) {
 	}
 }
 
+func newTestDatasourceRetrier(general *config.GeneralConfig) (*DatasourceRetrier, *time.Time, *[]time.Duration) {
+	now := time.Unix(1700000000, 0)
+	sleeps := []time.Duration{}
+	retrier := NewDatasourceRetrier(&config.Config{General: general}, "test-ds")
+	retrier.now = func() time.Time { return now }
+	retrier.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+	}
+	return retrier, &now, &sleeps
+}
+
+func TestDatasourceRetrier_BackoffAndGiveUp(t *testing.T) {
+	retry := true
+	retrier, _, sleeps := newTestDatasourceRetrier(&config.GeneralConfig{
+		DatasourceRetry:                  &retry,
+		DatasourceRetryPeriodParsed:      time.Second,
+		DatasourceRetryMaxPeriodParsed:   5 * time.Second,
+		DatasourceRetryMultiplierParsed:  2,
+		DatasourceRetryMaxAttemptsParsed: 5,
+	})
+
+	calls := 0
+	_, _, err := retrier.Query("q", time.Time{}, time.Time{}, func() ([][]string, string, error) {
+		calls++
+		return nil, "LME-7100", errors.New("unavailable")
+	})
+	if err == nil {
+		t.Fatal("Expected error after giving up")
+	}
+	if calls != 5 {
+		t.Errorf("Expected 5 attempts, got %v", calls)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	if len(*sleeps) != len(expected) {
+		t.Fatalf("Expected sleeps %v, got %v", expected, *sleeps)
+	}
+	for i := range expected {
+		if (*sleeps)[i] != expected[i] {
+			t.Errorf("Expected sleeps %v, got %v", expected, *sleeps)
+			break
+		}
+	}
+
+	calls = 0
+	result, _, err := retrier.Query("q", time.Time{}, time.Time{}, func() ([][]string, string, error) {
+		calls++
+		if calls < 3 {
+			return nil, "LME-7100", errors.New("unavailable")
+		}
+		return [][]string{{"a"}}, "", nil
+	})
+	if err != nil || len(result) != 1 || calls != 3 {
+		t.Errorf("Expected success on the 3rd attempt, got result %v, calls %v, err %v", result, calls, err)
+	}
+}
+
+func TestDatasourceRetrier_CircuitBreaker(t *testing.T) {
+	retry := false
+	retrier, now, _ := newTestDatasourceRetrier(&config.GeneralConfig{
+		DatasourceRetry:                    &retry,
+		DatasourceRetryPeriodParsed:        time.Second,
+		DatasourceRetryMaxPeriodParsed:     time.Second,
+		DatasourceRetryMultiplierParsed:    1,
+		DatasourceBreakerThresholdParsed:   2,
+		DatasourceBreakerOpenTimeoutParsed: 30 * time.Second,
+	})
+
+	calls := 0
+	failing := func() ([][]string, string, error) {
+		calls++
+		return nil, "LME-7100", errors.New("unavailable")
+	}
+	retrier.Query("q1", time.Time{}, time.Time{}, failing)
+	retrier.Query("q2", time.Time{}, time.Time{}, failing)
+	if retrier.breaker.state != CIRCUIT_BREAKER_OPEN {
+		t.Fatalf("Expected breaker to be open, got state %v", retrier.breaker.state)
+	}
+
+	_, errc, err := retrier.Query("q3", time.Time{}, time.Time{}, failing)
+	if err == nil || errc != "LME-1640" || calls != 2 {
+		t.Errorf("Expected request to be rejected by the open breaker, got errc %v, calls %v, err %v", errc, calls, err)
+	}
+
+	*now = now.Add(31 * time.Second)
+	retrier.Query("q1", time.Time{}, time.Time{}, failing)
+	if calls != 3 || retrier.breaker.state != CIRCUIT_BREAKER_OPEN {
+		t.Errorf("Expected failed probe to reopen the breaker, got calls %v, state %v", calls, retrier.breaker.state)
+	}
+
+	*now = now.Add(31 * time.Second)
+	_, _, err = retrier.Query("q1", time.Time{}, time.Time{}, func() ([][]string, string, error) {
+		return [][]string{}, "", nil
+	})
+	if err != nil || retrier.breaker.state != CIRCUIT_BREAKER_CLOSED {
+		t.Errorf("Expected successful probe to close the breaker, got state %v, err %v", retrier.breaker.state, err)
+	}
+}
+
+func TestDatasourceRetrier_QuerySplitting(t *testing.T) {
+	retry := true
+	retrier, _, _ := newTestDatasourceRetrier(&config.GeneralConfig{
+		DatasourceRetry:                 &retry,
+		DatasourceRetryPeriodParsed:     time.Second,
+		DatasourceRetryMaxPeriodParsed:  time.Second,
+		DatasourceRetryMultiplierParsed: 1,
+	})
+	queryConfig := &config.QueryConfig{
+		SplitMinTimerangeParsed: 15 * time.Minute,
+		SplitMaxRecords:         2,
+	}
+	startTime := time.Unix(1700000000, 0)
+	endTime := startTime.Add(time.Hour)
+
+	// every 15 minutes contain 2 records, the whole hour times out
+	ranges := []string{}
+	query := func(qName string, from time.Time, to time.Time) ([][]string, string, error) {
+		ranges = append(ranges, to.Sub(from).String())
+		if to.Sub(from) == time.Hour {
+			return nil, "LME-7100", fmt.Errorf("timeout : %w", httpservice.ErrRequestTimeout)
+		}
+		result := [][]string{{"time", "message"}}
+		for ts := from; ts.Before(to); ts = ts.Add(7*time.Minute + 30*time.Second) {
+			result = append(result, []string{ts.Format(time.RFC3339), "msg"})
+		}
+		return result, "", nil
+	}
+
+	result, _, err := retrier.QuerySplitting("q", queryConfig, startTime, endTime, query)
+	if err != nil {
+		t.Fatalf("Unexpected error : %+v", err)
+	}
+	if len(result) != 9 {
+		t.Fatalf("Expected header and 8 records, got %v", result)
+	}
+	if result[1][0] != startTime.Format(time.RFC3339) || result[8][0] != endTime.Add(-7*time.Minute-30*time.Second).Format(time.RFC3339) {
+		t.Errorf("Expected records in time order, got %v", result)
+	}
+	expectedRanges := "[1h0m0s 30m0s 15m0s 15m0s 30m0s 15m0s 15m0s]"
+	if fmt.Sprint(ranges) != expectedRanges {
+		t.Errorf("Expected requested ranges %v, got %v", expectedRanges, ranges)
+	}
+}
+
+type fakeDatasource struct {
+	capabilities httpservice.DatasourceCapabilities
+	calls        []string
+}
+
+func (fd *fakeDatasource) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	fd.calls = append(fd.calls, endTime.Sub(startTime).String())
+	if len(fd.calls) == 1 {
+		return [][]string{{"message"}, {"partial"}}, "LME-7105", fmt.Errorf("fake : %w", httpservice.ErrResponseTruncated)
+	}
+	return [][]string{{"message"}, {"full"}}, "", nil
+}
+
+func (fd *fakeDatasource) Capabilities() httpservice.DatasourceCapabilities {
+	return fd.capabilities
+}
+
+func TestDatasourceCallsProcessor_Capabilities(t *testing.T) {
+	retry := false
+	appConfig := &config.Config{
+		General:     &config.GeneralConfig{DatasourceRetry: &retry},
+		Datasources: map[string]*config.DatasourceConfig{"fake-ds": {Type: "Fake"}},
+	}
+	queryConfig := &config.QueryConfig{TimerangeDuration: time.Hour, SplitMinTimerangeParsed: 30 * time.Minute}
+	startTime := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		capabilities httpservice.DatasourceCapabilities
+		expected     string
+	}{
+		{httpservice.DatasourceCapabilities{}, "[1h0m0s] [[message] [partial]]"},
+		{httpservice.DatasourceCapabilities{Retries: true}, "[1h0m0s] [[message] [partial]]"},
+		{httpservice.DatasourceCapabilities{Retries: true, TimerangeSplitting: true}, "[1h0m0s 30m0s 30m0s] [[message] [full] [full]]"},
+	}
+	t.Cleanup(func() { httpservice.UnregisterDatasource("fake") })
+	for _, tt := range tests {
+		datasource := &fakeDatasource{capabilities: tt.capabilities}
+		httpservice.RegisterDatasource("fake", func(appConfig *config.Config, dsName string) httpservice.Datasource {
+			return datasource
+		})
+		processor := NewDatasourceCallsProcessor(appConfig, "fake-ds", nil, nil)
+		if (processor.retrier != nil) != tt.capabilities.Retries {
+			t.Errorf("For capabilities %+v expected retrier to be created only with retries", tt.capabilities)
+		}
+		result := processor.executeQuery("q", queryConfig, startTime)
+		if fmt.Sprint(datasource.calls, " ", result.Data) != tt.expected {
+			t.Errorf("For capabilities %+v expected %v, got: %v %v", tt.capabilities, tt.expected, datasource.calls, result.Data)
+		}
+		if !result.StartTime.Equal(startTime) || !result.EndTime.Equal(startTime.Add(time.Hour)) {
+			t.Errorf("Expected time range %v - %v, got: %v - %v", startTime, startTime.Add(time.Hour), result.StartTime, result.EndTime)
+		}
+	}
+}
+
+type failingDatasource struct {
+	calls int
+}
+
+func (fd *failingDatasource) Query(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error) {
+	fd.calls++
+	return nil, "LME-7100", errors.New("unavailable")
+}
+
+func (fd *failingDatasource) Capabilities() httpservice.DatasourceCapabilities {
+	return httpservice.DatasourceCapabilities{Retries: true}
+}
+
+func TestDatasourceCallsProcessor_RetriesExhausted(t *testing.T) {
+	retry := true
+	appConfig := &config.Config{
+		General: &config.GeneralConfig{
+			DatasourceRetry:                  &retry,
+			DatasourceRetryPeriodParsed:      time.Millisecond,
+			DatasourceRetryMaxPeriodParsed:   time.Millisecond,
+			DatasourceRetryMultiplierParsed:  1,
+			DatasourceRetryMaxAttemptsParsed: 2,
+		},
+		Datasources: map[string]*config.DatasourceConfig{"failing-ds": {Type: "Failing"}},
+	}
+	datasource := &failingDatasource{}
+	httpservice.RegisterDatasource("failing", func(appConfig *config.Config, dsName string) httpservice.Datasource {
+		return datasource
+	})
+	t.Cleanup(func() { httpservice.UnregisterDatasource("failing") })
+	processor := NewDatasourceCallsProcessor(appConfig, "failing-ds", nil, nil)
+	if result := processor.executeQuery("q", &config.QueryConfig{TimerangeDuration: time.Hour}, time.Unix(1700000000, 0)); result != nil {
+		t.Errorf("Expected the time range to be skipped after the retries are exhausted, got %+v", result)
+	}
+	if datasource.calls != 2 {
+		t.Errorf("Expected 2 attempts, got %v", datasource.calls)
+	}
+}
+
+func TestMergeQueryResults(t *testing.T) {
+	left := [][]string{{"a", "b"}, {"1", "2"}}
+	right := [][]string{{"b", "c"}, {"3", "4"}}
+	expected := [][]string{{"a", "b", "c"}, {"1", "2", ""}, {"", "3", "4"}}
+	if result := mergeQueryResults(left, right); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	right = [][]string{{"a", "b"}, {"3", "4"}}
+	expected = [][]string{{"a", "b"}, {"1", "2"}, {"3", "4"}}
+	if result := mergeQueryResults(left, right); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	if result := mergeQueryResults([][]string{}, right); fmt.Sprint(result) != fmt.Sprint(right) {
+		t.Errorf("Expected %v, got %v", right, result)
+	}
+}
+
 // Helper function for creating string pointers
 func stringPtr(s string) *string {
 	return &s
@@ -0,0 +1,125 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"errors"
+	"log_exporter/internal/config"
+	"log_exporter/internal/httpservice"
+	"log_exporter/internal/queues"
+	ec "log_exporter/internal/utils/errorcodes"
+	"runtime/debug"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplayProcessor replaces the datasource calls in the replay mode: it reads the HTTP responses recorded to the archive
+// and serves them to the datasources, so the query results are parsed by the datasources as they were in the past.
+// The results are put to the gd-queue in the order of the time windows
+type ReplayProcessor struct {
+	appConfig        *config.Config
+	archivePath      string
+	gdQueue          *queues.GDQueue
+	datasourcesMutex sync.Mutex
+	datasources      map[string]httpservice.Datasource
+	players          map[string]*httpservice.ResponsePlayer
+}
+
+func NewReplayProcessor(appConfig *config.Config, archivePath string, gdQueue *queues.GDQueue) *ReplayProcessor {
+	return &ReplayProcessor{
+		appConfig:   appConfig,
+		archivePath: archivePath,
+		gdQueue:     gdQueue,
+		datasources: make(map[string]httpservice.Datasource),
+		players:     make(map[string]*httpservice.ResponsePlayer),
+	}
+}
+
+func (rp *ReplayProcessor) Start() {
+	log.Infof("ReplayProcessor : Start() for archive %v", rp.archivePath)
+	for queryName := range rp.appConfig.Queries {
+		go rp.replay(queryName)
+	}
+	log.Info("ReplayProcessor : Start() finished")
+}
+
+// getDatasource creates the datasource with the response player on the first use, so the datasources
+// without recorded responses are not created
+func (rp *ReplayProcessor) getDatasource(dsName string) (httpservice.Datasource, *httpservice.ResponsePlayer) {
+	rp.datasourcesMutex.Lock()
+	defer rp.datasourcesMutex.Unlock()
+	if rp.datasources[dsName] == nil {
+		rp.players[dsName] = httpservice.NewResponsePlayer(rp.appConfig, dsName)
+		rp.datasources[dsName] = httpservice.CreateDatasource(rp.appConfig, dsName)
+	}
+	return rp.datasources[dsName], rp.players[dsName]
+}
+
+func (rp *ReplayProcessor) replay(queryName string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.WithField(ec.FIELD, ec.LME_1601).Errorf("ReplayProcessor : Panic during replay of query %v : %+v ; Stacktrace of the panic : %v", queryName, rec, string(debug.Stack()))
+		}
+	}()
+	windows, err := httpservice.ListRecordedWindows(rp.archivePath, queryName)
+	if err != nil {
+		log.WithField(ec.FIELD, ec.LME_7221).Errorf("ReplayProcessor : Error reading archive of query %v : %+v", queryName, err)
+		return
+	}
+	if len(windows) == 0 {
+		log.Warnf("ReplayProcessor : No recorded responses found for query %v", queryName)
+		return
+	}
+	datasource, player := rp.getDatasource(rp.appConfig.Queries[queryName].Datasource)
+	log.Infof("ReplayProcessor : Replay of %v recorded executions for query %v is started, from %v to %v", len(windows), queryName, windows[0].StartTime, windows[len(windows)-1].EndTime)
+	for _, window := range windows {
+		calls, err := httpservice.ReadRecordedWindow(window)
+		if err != nil {
+			log.WithField(ec.FIELD, ec.LME_7221).Errorf("ReplayProcessor : Error reading recorded responses of query %v, startTime %v, endTime %v : %+v", queryName, window.StartTime, window.EndTime, err)
+			continue
+		}
+		data, err := replayCalls(queryName, datasource, player, calls)
+		if err != nil {
+			log.WithField(ec.FIELD, ec.LME_7221).Errorf("ReplayProcessor : Error replaying recorded responses of query %v, startTime %v, endTime %v : %+v", queryName, window.StartTime, window.EndTime, err)
+			continue
+		}
+		log.Debugf("ReplayProcessor : Replaying query %v, startTime %v, endTime %v, %v rows", queryName, window.StartTime, window.EndTime, len(data))
+		rp.gdQueue.Put(queryName, &queues.GraylogData{
+			Data:      data,
+			StartTime: window.StartTime,
+			EndTime:   window.EndTime,
+		})
+	}
+	log.Infof("ReplayProcessor : Replay for query %v is finished", queryName)
+}
+
+// replayCalls executes the recorded calls of the query with the recorded responses and merges their results
+// in the same way as the results of the split time range
+func replayCalls(queryName string, datasource httpservice.Datasource, player *httpservice.ResponsePlayer, calls []*httpservice.RecordedCall) ([][]string, error) {
+	var result [][]string
+	for _, call := range calls {
+		player.Play(queryName, call.Responses)
+		callResult, _, err := datasource.Query(queryName, call.StartTime, call.EndTime)
+		if err != nil && !errors.Is(err, httpservice.ErrResponseTruncated) {
+			return nil, err
+		}
+		if left := player.Play(queryName, nil); left > 0 {
+			log.Warnf("ReplayProcessor : For query %v %v recorded responses of the call startTime %v, endTime %v are not requested", queryName, left, call.StartTime, call.EndTime)
+		}
+		result = mergeQueryResults(result, callResult)
+	}
+	return result, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Qubership
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"log_exporter/internal/config"
+	"log_exporter/internal/httpservice"
+	"log_exporter/internal/selfmonitor"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errSplitRequired is returned (wrapped) to the retrier when the time range has to be split instead of being retried
+var errSplitRequired = errors.New("time range split is required")
+
+// QuerySplitting executes the query with retries and, if the response is oversized, truncated or timed out,
+// re-executes the time range as two halves recursively until split-min-timerange is reached.
+// The results of the sub-ranges are merged into a single table.
+func (dr *DatasourceRetrier) QuerySplitting(qName string, queryConfig *config.QueryConfig, startTime time.Time, endTime time.Time, query func(qName string, startTime time.Time, endTime time.Time) ([][]string, string, error)) ([][]string, string, error) {
+	canSplit := queryConfig.SplitMinTimerangeParsed > 0 && endTime.Sub(startTime)/2 >= queryConfig.SplitMinTimerangeParsed
+	queryResult, errc, err := dr.Query(qName, startTime, endTime, func() ([][]string, string, error) {
+		queryResult, errc, err := query(qName, startTime, endTime)
+		if !canSplit {
+			return queryResult, errc, err
+		}
+		if errors.Is(err, httpservice.ErrResponseTruncated) || httpservice.IsTimeoutError(err) {
+			return queryResult, errc, fmt.Errorf("%w : %w", errSplitRequired, err)
+		}
+		if err == nil && queryConfig.SplitMaxRecords > 0 && len(queryResult)-1 > queryConfig.SplitMaxRecords {
+			return queryResult, errc, fmt.Errorf("%w : %v records received, split-max-records is %v", errSplitRequired, len(queryResult)-1, queryConfig.SplitMaxRecords)
+		}
+		return queryResult, errc, err
+	})
+	if !errors.Is(err, errSplitRequired) {
+		return queryResult, errc, err
+	}
+
+	middleTime := startTime.Add(endTime.Sub(startTime) / 2)
+	log.Warnf("For query %v time range startTime %v , endTime %v is split at %v : %+v", qName, startTime, endTime, middleTime, err)
+	selfMonitorIncTimerangeSplitCount(qName, time.Now())
+
+	leftResult, leftErrc, leftErr := dr.QuerySplitting(qName, queryConfig, startTime, middleTime, query)
+	rightResult, rightErrc, rightErr := dr.QuerySplitting(qName, queryConfig, middleTime, endTime, query)
+	queryResult = mergeQueryResults(leftResult, rightResult)
+	if leftErr != nil {
+		return queryResult, leftErrc, leftErr
+	}
+	return queryResult, rightErrc, rightErr
+}
+
+// mergeQueryResults appends the rows of the second table to the first one. If the headers differ,
+// the merged header contains the columns of both tables and the missing values are empty.
+func mergeQueryResults(left [][]string, right [][]string) [][]string {
+	if len(left) == 0 {
+		return right
+	}
+	if len(right) == 0 {
+		return left
+	}
+
+	header := append([]string{}, left[0]...)
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[column] = i
+	}
+	sameHeader := len(left[0]) == len(right[0])
+	for i, column := range right[0] {
+		if _, ok := columns[column]; !ok {
+			columns[column] = len(header)
+			header = append(header, column)
+		}
+		if sameHeader && left[0][i] != column {
+			sameHeader = false
+		}
+	}
+
+	result := make([][]string, 0, len(left)+len(right)-1)
+	if sameHeader {
+		result = append(result, left...)
+		return append(result, right[1:]...)
+	}
+
+	result = append(result, header)
+	for _, table := range [][][]string{left, right} {
+		for _, row := range table[1:] {
+			mergedRow := make([]string, len(header))
+			for i, value := range row {
+				if i < len(table[0]) {
+					mergedRow[columns[table[0][i]]] = value
+				}
+			}
+			result = append(result, mergedRow)
+		}
+	}
+	return result
+}
+
+func selfMonitorIncTimerangeSplitCount(qName string, timestamp time.Time) {
+	labels := make(map[string]string)
+	labels["query_name"] = qName
+	selfmonitor.IncTimerangeSplitCount(labels, &timestamp)
+}
@@ -20,6 +20,7 @@ import (
 	"log_exporter/internal/selfmonitor"
 	"log_exporter/internal/utils"
 	ec "log_exporter/internal/utils/errorcodes"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
@@ -63,6 +64,10 @@ func (gtsq *GTSQueue) generateHistoryTimestamps() {
 			if gtsq.lastTimestampService == nil {
 				return
 			}
+			if dsConfig := gtsq.appConfig.Datasources[queryConfig.Datasource]; dsConfig != nil && strings.ToLower(dsConfig.Type) == "file" {
+				log.Infof("NewGTSQueue : For query %v history won't be processed : datasource %v of type file reads the lines from the stored offsets regardless of the time range", queryName, queryConfig.Datasource)
+				return
+			}
 			if queryConfig.IntervalDuration <= 0 {
 				log.WithField(ec.FIELD, ec.LME_8102).Errorf("NewGTSQueue : For query %v intervalDuration is %v, which is <= 0. History won't be processed for the query", queryName, queryConfig.IntervalDuration)
 				return
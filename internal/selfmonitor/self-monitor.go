@@ -31,6 +31,7 @@ var (
 	queryLatencyBuckets            = []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60}
 	metricEvaluationLatencyBuckets = []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60}
 	queryResponseSizeBuckets       = []float64{1, 32, 1024, 32768, 1048576, 33554432, 1073741824}
+	requestWaitTimeBuckets         = []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60}
 
 	dataExporterCacheSize                 *collectors.CustomGauge
 	graylogResponseErrorCount             *collectors.CustomCounter
@@ -42,12 +43,20 @@ var (
 	regexNotMatchedCounterVec             *collectors.CustomCounter
 	panicCounterVec                       *collectors.CustomCounter
 	queueSizeGaugeVec                     *collectors.CustomGauge
+	responseTruncatedCounterVec           *collectors.CustomCounter
+	httpConnectionsCounterVec             *collectors.CustomCounter
+	circuitBreakerStateGaugeVec           *collectors.CustomGauge
+	timerangeSplitCounterVec              *collectors.CustomCounter
+	requestWaitTimeHistogramVec           *collectors.CustomHistogram
+	queuedRequestsGaugeVec                *collectors.CustomGauge
 
 	querySelfLabels        = []string{"query_name"}
 	metricSelfLabels       = []string{"metric_name"}
 	enrichSelfLabels       = []string{"query_name", "enrich_index"}
 	queryProcessSelfLabels = []string{"query_name", "process_name"}
 	queryQueueSelfLabels   = []string{"query_name", "queue_name"}
+	httpConnSelfLabels     = []string{"host", "reused"}
+	datasourceSelfLabels   = []string{"datasource"}
 	emptySelfLabels        = []string{}
 )
 
@@ -142,6 +151,60 @@ func InitSelfMonitoring(appConfig *config.Config, omnipresentLabels map[string]s
 		),
 	)
 
+	responseTruncatedCounterVec = collectors.NewCustomCounter(
+		prometheus.NewDesc(
+			"datasource_response_truncated_count",
+			"Count of datasource responses truncated because of the configured limits by query",
+			querySelfLabels,
+			omnipresentLabels,
+		),
+	)
+
+	httpConnectionsCounterVec = collectors.NewCustomCounter(
+		prometheus.NewDesc(
+			"http_client_connections_count",
+			"Count of connections obtained by HTTP clients by host and whether the idle connection was reused",
+			httpConnSelfLabels,
+			omnipresentLabels,
+		),
+	)
+
+	circuitBreakerStateGaugeVec = collectors.NewCustomGauge(
+		prometheus.NewDesc(
+			"datasource_circuit_breaker_state",
+			"State of the circuit breaker by datasource: 0 - closed, 1 - half-open, 2 - open",
+			datasourceSelfLabels,
+			omnipresentLabels,
+		),
+	)
+
+	timerangeSplitCounterVec = collectors.NewCustomCounter(
+		prometheus.NewDesc(
+			"datasource_timerange_split_count",
+			"Count of query time ranges split into sub-ranges because of the oversized or truncated response or the timeout by query",
+			querySelfLabels,
+			omnipresentLabels,
+		),
+	)
+
+	requestWaitTimeHistogramVec = collectors.NewCustomHistogram(
+		prometheus.NewDesc(
+			"datasource_request_wait_time",
+			"Time in seconds the datasource requests wait for the concurrency and rate limits by datasource",
+			datasourceSelfLabels,
+			omnipresentLabels,
+		),
+	)
+
+	queuedRequestsGaugeVec = collectors.NewCustomGauge(
+		prometheus.NewDesc(
+			"datasource_queued_requests",
+			"Number of datasource requests waiting for the concurrency and rate limits by datasource",
+			datasourceSelfLabels,
+			omnipresentLabels,
+		),
+	)
+
 	initRegexMatchedNotMatched(appConfig)
 
 	deRegistry.MustRegister(utils.SELF_METRICS_REGISTRY_NAME, &SelfmonitorCollector{})
@@ -180,6 +243,12 @@ func (c *SelfmonitorCollector) Describe(ch chan<- *prometheus.Desc) {
 	regexNotMatchedCounterVec.Describe(ch)
 	panicCounterVec.Describe(ch)
 	queueSizeGaugeVec.Describe(ch)
+	responseTruncatedCounterVec.Describe(ch)
+	httpConnectionsCounterVec.Describe(ch)
+	circuitBreakerStateGaugeVec.Describe(ch)
+	timerangeSplitCounterVec.Describe(ch)
+	requestWaitTimeHistogramVec.Describe(ch)
+	queuedRequestsGaugeVec.Describe(ch)
 }
 
 func (c *SelfmonitorCollector) Collect(ch chan<- prometheus.Metric) {
@@ -196,6 +265,12 @@ func (c *SelfmonitorCollector) Collect(ch chan<- prometheus.Metric) {
 		regexNotMatchedCounterVec.Collect(ch)
 		panicCounterVec.Collect(ch)
 		queueSizeGaugeVec.Collect(ch)
+		responseTruncatedCounterVec.Collect(ch)
+		httpConnectionsCounterVec.Collect(ch)
+		circuitBreakerStateGaugeVec.Collect(ch)
+		timerangeSplitCounterVec.Collect(ch)
+		requestWaitTimeHistogramVec.Collect(ch)
+		queuedRequestsGaugeVec.Collect(ch)
 	} else {
 		timestamp := time.Now()
 		dataExporterCacheSize.CollectWithTimestamp(ch, timestamp)
@@ -208,6 +283,12 @@ func (c *SelfmonitorCollector) Collect(ch chan<- prometheus.Metric) {
 		regexNotMatchedCounterVec.CollectWithTimestamp(ch, timestamp)
 		panicCounterVec.CollectWithTimestamp(ch, timestamp)
 		queueSizeGaugeVec.CollectWithTimestamp(ch, timestamp)
+		responseTruncatedCounterVec.CollectWithTimestamp(ch, timestamp)
+		httpConnectionsCounterVec.CollectWithTimestamp(ch, timestamp)
+		circuitBreakerStateGaugeVec.CollectWithTimestamp(ch, timestamp)
+		timerangeSplitCounterVec.CollectWithTimestamp(ch, timestamp)
+		requestWaitTimeHistogramVec.CollectWithTimestamp(ch, timestamp)
+		queuedRequestsGaugeVec.CollectWithTimestamp(ch, timestamp)
 	}
 }
 
@@ -284,3 +365,52 @@ func SetQueueSize(value float64, labels map[string]string, timestamp *time.Time)
 	}
 	queueSizeGaugeVec.Set(value, labels, queryQueueSelfLabels, timestamp)
 }
+
+func IncResponseTruncatedCount(labels map[string]string, timestamp *time.Time) {
+	if *utils.DisableTimestamp {
+		timestamp = nil
+	}
+	responseTruncatedCounterVec.Add(1.0, labels, querySelfLabels, timestamp)
+}
+
+func RefreshResponseTruncatedCount(labels map[string]string, timestamp *time.Time) {
+	if *utils.DisableTimestamp {
+		return
+	}
+	responseTruncatedCounterVec.Add(0.0, labels, querySelfLabels, timestamp)
+}
+
+func IncHttpConnectionsCount(labels map[string]string, timestamp *time.Time) {
+	if *utils.DisableTimestamp {
+		timestamp = nil
+	}
+	httpConnectionsCounterVec.Add(1.0, labels, httpConnSelfLabels, timestamp)
+}
+
+func SetCircuitBreakerState(value float64, labels map[string]string, timestamp *time.Time) {
+	if *utils.DisableTimestamp {
+		timestamp = nil
+	}
+	circuitBreakerStateGaugeVec.Set(value, labels, datasourceSelfLabels, timestamp)
+}
+
+func IncTimerangeSplitCount(labels map[string]string, timestamp *time.Time) {
+	if *utils.DisableTimestamp {
+		timestamp = nil
+	}
+	timerangeSplitCounterVec.Add(1.0, labels, querySelfLabels, timestamp)
+}
+
+func ObserveRequestWaitTime(labels map[string]string, value float64, timestamp *time.Time) {
+	if *utils.DisableTimestamp {
+		timestamp = nil
+	}
+	requestWaitTimeHistogramVec.ObserveSingle(value, requestWaitTimeBuckets, labels, datasourceSelfLabels, timestamp)
+}
+
+func SetQueuedRequests(value float64, labels map[string]string, timestamp *time.Time) {
+	if *utils.DisableTimestamp {
+		timestamp = nil
+	}
+	queuedRequestsGaugeVec.Set(value, labels, datasourceSelfLabels, timestamp)
+}
@@ -67,6 +67,16 @@ const (
 	LME_1624 = "LME-1624" // Attempt to write to non-existent or nil chan
 	LME_1625 = "LME-1625" // Attempt to write to full chan
 
+	// LME inner technical error codes for datasource retries LME-1640 - LME-1649
+
+	LME_1640 = "LME-1640" // Datasource circuit breaker is open, request is rejected
+	LME_1641 = "LME-1641" // Datasource retries are exhausted, request is abandoned
+
+	// LME inner technical error codes for HTTP authorization LME-1650 - LME-1659
+
+	LME_1650 = "LME-1650" // Bearer token file reading error
+	LME_1651 = "LME-1651" // OAuth2 token request error
+
 	// Graylog communication error codes LME-7100 - LME-7109
 
 	LME_7100 = "LME-7100" // General Graylog communication error
@@ -74,6 +84,7 @@ const (
 	LME_7102 = "LME-7102" // Graylog responded with unexpected status code
 	LME_7103 = "LME-7103" // Graylog response parsing error
 	LME_7104 = "LME-7104" // Graylog response is not supported
+	LME_7105 = "LME-7105" // Graylog response is truncated because of the configured limits
 
 	// Victoria communication error codes LME-7110 - LME-7119
 
@@ -106,6 +117,8 @@ const (
 	LME_7142 = "LME-7142" // New Relic service responded with unexpected status code
 	LME_7143 = "LME-7143" // New Relic service response parsing error
 	LME_7144 = "LME-7144" // New Relic response is not supported
+	LME_7145 = "LME-7145" // New Relic NerdGraph responded with errors
+	LME_7146 = "LME-7146" // New Relic asynchronous query is not completed before the retry deadline
 
 	// Consul communication error codes LME-7150 - LME-7159
 
@@ -119,6 +132,49 @@ const (
 
 	LME_7160 = "LME-7160" // General Loki communication error
 	LME_7161 = "LME-7161" // Loki access error
+	LME_7162 = "LME-7162" // Loki response is truncated because of the configured limits
+	LME_7163 = "LME-7163" // Loki response is not supported
+
+	// OpenSearch communication error codes LME-7170 - LME-7179
+
+	LME_7170 = "LME-7170" // General OpenSearch communication error
+	LME_7171 = "LME-7171" // OpenSearch responded with error status code
+	LME_7172 = "LME-7172" // OpenSearch responded with unexpected status code
+	LME_7173 = "LME-7173" // OpenSearch response parsing error
+	LME_7174 = "LME-7174" // OpenSearch response is not supported
+	LME_7175 = "LME-7175" // OpenSearch search timed out, the response may be incomplete
+
+	// VictoriaLogs communication error codes LME-7180 - LME-7189
+
+	LME_7180 = "LME-7180" // General VictoriaLogs communication error
+	LME_7181 = "LME-7181" // VictoriaLogs responded with error or unexpected status code
+	LME_7182 = "LME-7182" // VictoriaLogs response is truncated because of the configured limits
+	LME_7183 = "LME-7183" // VictoriaLogs response parsing error
+
+	// ClickHouse communication error codes LME-7190 - LME-7199
+
+	LME_7190 = "LME-7190" // General ClickHouse communication error
+	LME_7191 = "LME-7191" // ClickHouse responded with error or unexpected status code
+	LME_7192 = "LME-7192" // ClickHouse response is truncated because of the configured limits
+	LME_7193 = "LME-7193" // ClickHouse response parsing error
+
+	// File datasource error codes LME-7200 - LME-7209
+
+	LME_7200 = "LME-7200" // Log file access error
+	LME_7201 = "LME-7201" // File offsets reading or saving error
+	LME_7202 = "LME-7202" // Log file lines are left for the next execution because of the configured limits
+	LME_7203 = "LME-7203" // Log file line is skipped because it exceeds the max line size
+
+	// Receiver datasource error codes LME-7210 - LME-7219
+
+	LME_7210 = "LME-7210" // Receiver listener error
+	LME_7211 = "LME-7211" // Received log record parsing error
+	LME_7212 = "LME-7212" // Received log records are dropped because of the configured limits
+
+	// Datasource recording and replay error codes LME-7220 - LME-7229
+
+	LME_7220 = "LME-7220" // Datasource response recording error
+	LME_7221 = "LME-7221" // Recorded datasource response reading or replay error
 
 	// Invalid configuration error codes LME-8100 - LME-8200
 
@@ -30,7 +30,6 @@ import (
 	_ "net/http/pprof"
 	"runtime/debug"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -45,6 +44,7 @@ var (
 	addr                 = flag.String("listen-address", "", "The address to listen (port) for HTTP requests")
 	configPath           = flag.String("config-path", "config.yaml", "Path to the yaml configuration")
 	disabledSelfMonitor  = flag.Bool("disable-self-monitor", false, "Disables self monitoring")
+	replayArchive        = flag.String("replay-archive", "", "Path to the archive of the recorded datasource responses. If set, the metrics are evaluated from the archive instead of the datasources, push exports are disabled")
 	appConfig            *config.Config
 	croniter             *cron.Cron
 	victoriaService      *httpservice.VictoriaService
@@ -130,22 +130,32 @@ func main() {
 
 	croniter = utils.GetCron()
 
+	deRegistry = registry.NewDERegistry(appConfig)
+	selfmonitor.InitSelfMonitoring(appConfig, getCommonDatasourceLabels(), deRegistry)
+
 	initExports()
+	if *replayArchive != "" {
+		log.Infof("Replay mode : query results are read from archive %v, push exports are disabled", *replayArchive)
+		victoriaService, promRWService, lastTimestampService = nil, nil, nil
+		if pullPort <= 0 && *addr == "" {
+			log.WithField(ec.FIELD, ec.LME_8101).Fatal("Replay mode requires pull strategy or listen-address to expose the metrics. Exiting.")
+		}
+	}
 
-	gtsQueue = queues.NewGTSQueue(appConfig, lastTimestampService, croniter)
+	if *replayArchive == "" {
+		gtsQueue = queues.NewGTSQueue(appConfig, lastTimestampService, croniter)
+	}
 	gdQueue = queues.NewGDQueue(appConfig)
 	if victoriaService != nil || promRWService != nil {
 		gmQueue = queues.NewGMQueue(appConfig)
 	}
 
-	deRegistry = registry.NewDERegistry(appConfig)
-	selfmonitor.InitSelfMonitoring(appConfig, appConfig.Datasources[appConfig.DsName].Labels, deRegistry)
-	if strings.ToUpper(appConfig.Datasources[appConfig.DsName].Type) == "NEWRELIC" {
-		processors.NewNewRelicCallsProcessor(appConfig, gtsQueue, gdQueue).Start()
-	} else if strings.ToUpper(appConfig.Datasources[appConfig.DsName].Type) == "LOKI" {
-		processors.NewLokiCallsProcessor(appConfig, gtsQueue, gdQueue).Start()
+	if *replayArchive != "" {
+		processors.NewReplayProcessor(appConfig, *replayArchive, gdQueue).Start()
 	} else {
-		processors.NewGraylogCallsProcessor(appConfig, gtsQueue, gdQueue).Start()
+		for dsName := range appConfig.Datasources {
+			processors.NewDatasourceCallsProcessor(appConfig, dsName, gtsQueue, gdQueue).Start()
+		}
 	}
 	processors.NewMetricsEvaluationProcessor(appConfig, gdQueue, gmQueue, deRegistry).Start()
 	if victoriaService != nil {
@@ -232,3 +242,24 @@ func reapplyFlags() {
 		}
 	}
 }
+
+// getCommonDatasourceLabels returns the datasource labels with the same value for all datasources, they are used as
+// labels of the self-metrics
+func getCommonDatasourceLabels() map[string]string {
+	var result map[string]string
+	for _, dsConfig := range appConfig.Datasources {
+		if result == nil {
+			result = make(map[string]string, len(dsConfig.Labels))
+			for k, v := range dsConfig.Labels {
+				result[k] = v
+			}
+			continue
+		}
+		for k, v := range result {
+			if dsValue, ok := dsConfig.Labels[k]; !ok || dsValue != v {
+				delete(result, k)
+			}
+		}
+	}
+	return result
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"log_exporter/internal/config"
 	"strings"
 	"testing"
 )
@@ -90,3 +91,21 @@ func TestVersionString_EmptyValues(t *testing.T) {
 // Note: Other functions in main.go (like main(), initExports(), etc.) are difficult to unit test
 // because they depend on global state, configuration files, and external services.
 // Integration tests would be more appropriate for those functions.
+
+func TestGetCommonDatasourceLabels(t *testing.T) {
+	originalAppConfig := appConfig
+	defer func() {
+		appConfig = originalAppConfig
+	}()
+
+	appConfig = &config.Config{
+		Datasources: map[string]*config.DatasourceConfig{
+			"graylog": {Labels: map[string]string{"env": "prod", "dbtype": "graylog", "region": "eu"}},
+			"loki":    {Labels: map[string]string{"env": "prod", "dbtype": "loki"}},
+		},
+	}
+	result := getCommonDatasourceLabels()
+	if len(result) != 1 || result["env"] != "prod" {
+		t.Errorf("Expected only env=prod label to be common, got: %+v", result)
+	}
+}